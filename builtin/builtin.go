@@ -12,29 +12,39 @@ import (
 	"strings"
 
 	"github.com/steinarvk/heisenlisp/code"
+	"github.com/steinarvk/heisenlisp/compile"
 	"github.com/steinarvk/heisenlisp/env"
 	"github.com/steinarvk/heisenlisp/equality"
 	"github.com/steinarvk/heisenlisp/expr"
 	"github.com/steinarvk/heisenlisp/hashcode"
+	"github.com/steinarvk/heisenlisp/lambdalist"
 	"github.com/steinarvk/heisenlisp/lisperr"
 	"github.com/steinarvk/heisenlisp/listops"
 	"github.com/steinarvk/heisenlisp/logic"
+	"github.com/steinarvk/heisenlisp/memo"
+	"github.com/steinarvk/heisenlisp/module"
 	"github.com/steinarvk/heisenlisp/numerics"
+	"github.com/steinarvk/heisenlisp/outcome"
+	"github.com/steinarvk/heisenlisp/parallel"
+	"github.com/steinarvk/heisenlisp/pattern"
 	"github.com/steinarvk/heisenlisp/purity"
+	"github.com/steinarvk/heisenlisp/strops"
 	"github.com/steinarvk/heisenlisp/types"
 	"github.com/steinarvk/heisenlisp/unknown"
 	"github.com/steinarvk/heisenlisp/value/boolean"
 	"github.com/steinarvk/heisenlisp/value/builtinfunc"
+	"github.com/steinarvk/heisenlisp/value/condition"
 	"github.com/steinarvk/heisenlisp/value/cons"
-	"github.com/steinarvk/heisenlisp/value/function"
 	"github.com/steinarvk/heisenlisp/value/integer"
 	"github.com/steinarvk/heisenlisp/value/macro"
 	"github.com/steinarvk/heisenlisp/value/null"
 	"github.com/steinarvk/heisenlisp/value/real"
+	"github.com/steinarvk/heisenlisp/value/record"
 	"github.com/steinarvk/heisenlisp/value/str"
 	"github.com/steinarvk/heisenlisp/value/symbol"
 	"github.com/steinarvk/heisenlisp/value/unknowns/anyof"
 	"github.com/steinarvk/heisenlisp/value/unknowns/fullyunknown"
+	"github.com/steinarvk/heisenlisp/value/unknowns/maythrow"
 	"github.com/steinarvk/heisenlisp/value/unknowns/numinrange"
 	"github.com/steinarvk/heisenlisp/value/unknowns/typed"
 
@@ -60,7 +70,7 @@ func wrap(name string, f func(a []types.Value) (types.Value, error)) func([]type
 func Unary(e types.Env, name string, f func(a types.Value) (types.Value, error)) {
 	checker := func(vs []types.Value) (types.Value, error) {
 		if len(vs) != 1 {
-			return nil, fmt.Errorf("want 1 param, got %d", len(vs))
+			return nil, lisperr.WrongArity{"1 param", len(vs)}
 		}
 		return f(vs[0])
 	}
@@ -70,7 +80,7 @@ func Unary(e types.Env, name string, f func(a types.Value) (types.Value, error))
 func Binary(e types.Env, name string, f func(a, b types.Value) (types.Value, error)) {
 	checker := func(vs []types.Value) (types.Value, error) {
 		if len(vs) != 2 {
-			return nil, fmt.Errorf("want 2 params, got %d", len(vs))
+			return nil, lisperr.WrongArity{"2 params", len(vs)}
 		}
 		return f(vs[0], vs[1])
 	}
@@ -80,7 +90,7 @@ func Binary(e types.Env, name string, f func(a, b types.Value) (types.Value, err
 func Ternary(e types.Env, name string, f func(a, b, c types.Value) (types.Value, error)) {
 	checker := func(vs []types.Value) (types.Value, error) {
 		if len(vs) != 3 {
-			return nil, fmt.Errorf("want 3 params, got %d", len(vs))
+			return nil, lisperr.WrongArity{"3 params", len(vs)}
 		}
 		return f(vs[0], vs[1], vs[2])
 	}
@@ -91,6 +101,15 @@ func Values(e types.Env, name string, f func(xs []types.Value) (types.Value, err
 	e.Bind(symbol.StringToIdOrPanic(name), builtinfunc.New(name, purity.NameIsPure(name), f))
 }
 
+// numericLess compares numbers, as by <, for sorting.
+func numericLess(a, b types.Value) (types.TernaryTruthValue, error) {
+	rv, err := numerics.BinaryLess(a, b)
+	if err != nil {
+		return types.InvalidTernary, err
+	}
+	return unknown.TruthValue(rv)
+}
+
 func specialFormString(s string) string { return fmt.Sprintf("#<special %q>", s) }
 
 type ifSpecialForm struct{}
@@ -105,40 +124,87 @@ func (i ifSpecialForm) Execute(e types.Env, unevaluated []types.Value) (types.Va
 	if len(unevaluated) != 3 {
 		return nil, fmt.Errorf("'if' expects 3 params, got %d", len(unevaluated))
 	}
-	conditionClause := unevaluated[0]
-	thenClause := unevaluated[1]
-	elseClause := unevaluated[2]
+	return runIf(e, compile.Interpreted(unevaluated[0]), compile.Interpreted(unevaluated[1]), compile.Interpreted(unevaluated[2]))
+}
 
-	condition, err := conditionClause.Eval(e)
+func (i ifSpecialForm) Compile(c *compile.Compiler, args []types.Value) (compile.Code, error) {
+	if len(args) != 3 {
+		return nil, fmt.Errorf("'if' expects 3 params, got %d", len(args))
+	}
+	codes, err := c.CompileAll(args)
 	if err != nil {
 		return nil, err
 	}
+	conditionClause, thenClause, elseClause := codes[0], codes[1], codes[2]
+	return func(e types.Env) (types.Value, error) {
+		return runIf(e, conditionClause, thenClause, elseClause)
+	}, nil
+}
 
-	tv, err := unknown.TruthValue(condition)
+func (i ifSpecialForm) Assemble(a *compile.Assembler, args []types.Value, tail bool) error {
+	if len(args) != 3 {
+		return fmt.Errorf("'if' expects 3 params, got %d", len(args))
+	}
+	return a.If(args[0], args[1], args[2], tail)
+}
+
+func runIf(e types.Env, conditionClause, thenClause, elseClause compile.Code) (types.Value, error) {
+	conditionValue, err := conditionClause(e)
 	if err != nil {
 		return nil, err
 	}
+	conditionValue, exceptions := maythrow.Split(conditionValue)
 
+	tv, err := unknown.TruthValue(conditionValue)
+	if err != nil {
+		return nil, err
+	}
+
+	var clauses []compile.Code
 	switch tv {
 	case types.Maybe:
-		thenVal, err := thenClause.Eval(e)
-		if err != nil {
-			return nil, err
+		clauses = []compile.Code{thenClause, elseClause}
+	case types.True:
+		clauses = []compile.Code{thenClause}
+	case types.False:
+		clauses = []compile.Code{elseClause}
+	default:
+		return nil, errors.New("impossible state: ternary truth value neither true, false, or maybe")
+	}
+
+	if len(clauses) == 1 && len(exceptions) == 0 {
+		return clauses[0](e)
+	}
+
+	// Both branches are possible worlds; if only one of them throws, the
+	// result is the other branch's value or the exception. In a pure
+	// context they are independent, and may be evaluated in parallel.
+	results := make([]types.Value, len(clauses))
+	errs := make([]error, len(clauses))
+	evaluated := e.IsInPureContext()
+	if evaluated {
+		parallel.Run(len(clauses), func(i int) {
+			results[i], errs[i] = clauses[i](e)
+		})
+	}
+
+	var values []types.Value
+	for i, clause := range clauses {
+		val, err := results[i], errs[i]
+		if !evaluated {
+			val, err = clause(e)
 		}
-		elseVal, err := elseClause.Eval(e)
 		if err != nil {
-			return nil, err
+			if !outcome.IsCatchable(err) {
+				return nil, err
+			}
+			exceptions = append(exceptions, maythrow.Exceptions(err)...)
+			continue
 		}
-
-		return anyof.New([]types.Value{
-			thenVal, elseVal,
-		})
-	case types.True:
-		return thenClause.Eval(e)
-	case types.False:
-		return elseClause.Eval(e)
+		values = append(values, val)
 	}
-	return nil, errors.New("impossible state: ternary truth value neither true, false, or maybe")
+
+	return outcome.Combine(values, exceptions)
 }
 
 type setSpecialForm struct{}
@@ -184,6 +250,21 @@ func (i quoteSpecialForm) Execute(e types.Env, unevaluated []types.Value) (types
 	return unevaluated[0], nil
 }
 
+func (i quoteSpecialForm) Compile(c *compile.Compiler, args []types.Value) (compile.Code, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("quote: unary special form, got %d params", len(args))
+	}
+	return compile.Constant(args[0]), nil
+}
+
+func (i quoteSpecialForm) Assemble(a *compile.Assembler, args []types.Value, tail bool) error {
+	if len(args) != 1 {
+		return fmt.Errorf("quote: unary special form, got %d params", len(args))
+	}
+	a.Constant(args[0])
+	return nil
+}
+
 type quasiquoteSpecialForm struct{}
 
 func (i quasiquoteSpecialForm) TypeName() string                    { return "special" }
@@ -212,92 +293,917 @@ func (i defunSpecialForm) Execute(e types.Env, unevaluated []types.Value) (types
 		return nil, fmt.Errorf("defun!: too few arguments")
 	}
 
-	name, err := symbol.Name(unevaluated[0])
+	name, err := symbol.Name(unevaluated[0])
+	if err != nil {
+		return nil, fmt.Errorf("must defun! symbol as name, not %v", unevaluated[0])
+	}
+
+	funcVal, err := makeFunction(&name, e, unevaluated[1], unevaluated[2:])
+	if err != nil {
+		return nil, err
+	}
+
+	e.BindRoot(symbol.StringToIdOrPanic(name), funcVal)
+	return funcVal, nil
+}
+
+func (i defunSpecialForm) Assemble(a *compile.Assembler, args []types.Value, tail bool) error {
+	if len(args) < 3 {
+		return fmt.Errorf("defun!: too few arguments")
+	}
+
+	name, err := symbol.Name(args[0])
+	if err != nil {
+		return fmt.Errorf("must defun! symbol as name, not %v", args[0])
+	}
+
+	if err := a.Lambda(name, args[1], args[2:]); err != nil {
+		return err
+	}
+	a.BindRoot(args[0])
+	return nil
+}
+
+type defmacroSpecialForm struct{}
+
+func (i defmacroSpecialForm) TypeName() string                    { return "special" }
+func (i defmacroSpecialForm) IsPure() bool                        { return false }
+func (i defmacroSpecialForm) Hashcode() uint32                    { return hashcode.Hash("special:", []byte(i.String())) }
+func (i defmacroSpecialForm) String() string                      { return specialFormString("defmacro!") }
+func (i defmacroSpecialForm) Falsey() bool                        { return false }
+func (i defmacroSpecialForm) Eval(types.Env) (types.Value, error) { return i, nil }
+func (i defmacroSpecialForm) Execute(e types.Env, unevaluated []types.Value) (types.Value, error) {
+	if len(unevaluated) < 3 {
+		return nil, fmt.Errorf("defmacro!: too few arguments")
+	}
+
+	name, err := symbol.Name(unevaluated[0])
+	if err != nil {
+		return nil, fmt.Errorf("defmacro! name: %v", err)
+	}
+
+	macroValue, err := macro.New(e, name, unevaluated[1], unevaluated[2:])
+	if err != nil {
+		return nil, err
+	}
+
+	e.BindRoot(symbol.StringToIdOrPanic(name), macroValue)
+
+	return macroValue, nil
+}
+
+type letSpecialForm struct{}
+
+func (i letSpecialForm) TypeName() string                    { return "special" }
+func (i letSpecialForm) IsPure() bool                        { return true }
+func (i letSpecialForm) Hashcode() uint32                    { return hashcode.Hash("special:", []byte(i.String())) }
+func (i letSpecialForm) String() string                      { return specialFormString("let") }
+func (i letSpecialForm) Falsey() bool                        { return false }
+func (i letSpecialForm) Eval(types.Env) (types.Value, error) { return i, nil }
+func (i letSpecialForm) Execute(e types.Env, unevaluated []types.Value) (types.Value, error) {
+	// (let (bindings) forms...)
+	if len(unevaluated) < 2 {
+		return nil, fmt.Errorf("let: too few arguments")
+	}
+
+	if symbol.Is(unevaluated[0]) {
+		return namedLet(e, unevaluated)
+	}
+
+	bindings, err := parseLetBindings(unevaluated[0], compile.Interpreted)
+	if err != nil {
+		return nil, err
+	}
+
+	frames, exceptions, err := bindLet(e, letVariables(bindings), bindings)
+	if err != nil {
+		return nil, err
+	}
+
+	return outcome.InWorlds(len(frames), exceptions, func(i int) (types.Value, error) {
+		return expr.Progn(frames[i], unevaluated[1:])
+	})
+}
+
+func (i letSpecialForm) Compile(c *compile.Compiler, args []types.Value) (compile.Code, error) {
+	if len(args) < 2 || symbol.Is(args[0]) {
+		// Named let is left to the interpreter.
+		return nil, fmt.Errorf("let: cannot compile")
+	}
+
+	var compileErr error
+	bindings, err := parseLetBindings(args[0], func(v types.Value) compile.Code {
+		code, err := c.Compile(v)
+		if err != nil && compileErr == nil {
+			compileErr = err
+		}
+		return code
+	})
+	if err != nil {
+		return nil, err
+	}
+	if compileErr != nil {
+		return nil, compileErr
+	}
+
+	ids := letVariables(bindings)
+	body, err := c.Binding(ids...).CompileBody(args[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	return func(e types.Env) (types.Value, error) {
+		frames, exceptions, err := bindLet(e, ids, bindings)
+		if err != nil {
+			return nil, err
+		}
+		return outcome.InWorlds(len(frames), exceptions, func(i int) (types.Value, error) {
+			return body(frames[i])
+		})
+	}, nil
+}
+
+func (i letSpecialForm) Assemble(a *compile.Assembler, args []types.Value, tail bool) error {
+	if len(args) < 2 || symbol.Is(args[0]) {
+		return fmt.Errorf("let: cannot assemble")
+	}
+
+	bindings, err := parseLetBindings(args[0], compile.Interpreted)
+	if err != nil {
+		return err
+	}
+
+	var forms []types.Value
+	for _, binding := range bindings {
+		if binding.pattern != nil {
+			// Destructuring may bind in several possible worlds, which
+			// is left to the closure compiler.
+			return fmt.Errorf("let: cannot assemble destructuring")
+		}
+		forms = append(forms, binding.form)
+	}
+
+	bind := func(e, frame types.Env, values []types.Value) error {
+		for i, binding := range bindings {
+			frame.Bind(binding.id, values[i])
+		}
+		return nil
+	}
+
+	return a.Let(letVariables(bindings), forms, bind, args[1:], tail)
+}
+
+type letBinding struct {
+	// form is the value expression.
+	form types.Value
+	// id is set if the target is a symbol; otherwise the value is
+	// destructured by pattern.
+	id      uint32
+	pattern pattern.Pattern
+	value   compile.Code
+}
+
+// parseLetBindings parses the binding list of a let, turning the value
+// expressions into code with the given function.
+func parseLetBindings(v types.Value, toCode func(types.Value) compile.Code) ([]letBinding, error) {
+	bindings, err := expr.UnwrapList(v)
+	if err != nil {
+		return nil, fmt.Errorf("error unwrapping bindings: %v", err)
+	}
+
+	var rv []letBinding
+	for i, binding := range bindings {
+		bindingList, err := expr.UnwrapList(binding)
+		if err != nil {
+			return nil, fmt.Errorf("binding %d: error unwrapping: %v", i, err)
+		}
+		if len(bindingList) != 2 {
+			return nil, fmt.Errorf("binding %d: wrong length (want 2): %d", i, len(bindingList))
+		}
+
+		parsed := letBinding{form: bindingList[1], value: toCode(bindingList[1])}
+
+		if sym, err := symbol.Id(bindingList[0]); err == nil {
+			parsed.id = sym
+		} else {
+			// Destructuring binding, e.g. (let (((a b) (list 1 2))) ...)
+			parsed.pattern, err = pattern.Parse(bindingList[0])
+			if err != nil {
+				return nil, fmt.Errorf("binding %d: %v", i, err)
+			}
+		}
+
+		rv = append(rv, parsed)
+	}
+
+	return rv, nil
+}
+
+// letVariables returns the names bound by a let.
+func letVariables(bindings []letBinding) []uint32 {
+	var rv []uint32
+	for _, binding := range bindings {
+		if binding.pattern == nil {
+			rv = append(rv, binding.id)
+		} else {
+			rv = append(rv, binding.pattern.Variables()...)
+		}
+	}
+	return rv
+}
+
+// bindLet binds the values of a let in new frames with the given names, one
+// for each possible world of destructuring them (see pattern.Worlds).
+func bindLet(e types.Env, ids []uint32, bindings []letBinding) ([]types.Env, []error, error) {
+	worlds := pattern.NewWorlds()
+
+	for i, binding := range bindings {
+		val, err := binding.value(e)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if binding.pattern == nil {
+			worlds.Bind(binding.id, val)
+		} else if err := worlds.Destructure(e, binding.pattern, val); err != nil {
+			return nil, nil, fmt.Errorf("binding %d: %w", i, err)
+		}
+	}
+
+	var frames []types.Env
+	for _, bindings := range worlds.Bindings {
+		frame := env.NewFrame(e, ids)
+		for _, binding := range bindings {
+			frame.Bind(binding.Name, binding.Value)
+		}
+		frames = append(frames, frame)
+	}
+	return frames, worlds.Exceptions, nil
+}
+
+type matchSpecialForm struct{}
+
+func (i matchSpecialForm) TypeName() string                    { return "special" }
+func (i matchSpecialForm) IsPure() bool                        { return true }
+func (i matchSpecialForm) Hashcode() uint32                    { return hashcode.Hash("special:", []byte(i.String())) }
+func (i matchSpecialForm) String() string                      { return specialFormString("match") }
+func (i matchSpecialForm) Falsey() bool                        { return false }
+func (i matchSpecialForm) Eval(types.Env) (types.Value, error) { return i, nil }
+func (i matchSpecialForm) Execute(e types.Env, unevaluated []types.Value) (types.Value, error) {
+	// (match expr (pattern forms...)...)
+	if len(unevaluated) < 2 {
+		return nil, fmt.Errorf("match: too few arguments")
+	}
+
+	type clause struct {
+		pattern pattern.Pattern
+		body    []types.Value
+	}
+
+	var clauses []clause
+	for i, clauseValue := range unevaluated[1:] {
+		xs, err := expr.UnwrapList(clauseValue)
+		if err != nil {
+			return nil, fmt.Errorf("match: clause %d: %v", i, err)
+		}
+		if len(xs) < 2 {
+			return nil, fmt.Errorf("match: clause %d: want pattern and body", i)
+		}
+		p, err := pattern.Parse(xs[0])
+		if err != nil {
+			return nil, fmt.Errorf("match: clause %d: %v", i, err)
+		}
+		clauses = append(clauses, clause{p, xs[1:]})
+	}
+
+	scrutinee, err := unevaluated[0].Eval(e)
+	if err != nil {
+		return nil, err
+	}
+	scrutinee, exceptions := maythrow.Split(scrutinee)
+
+	// Each possible value of the scrutinee goes through the clauses
+	// separately, so that a clause only sees the values that did not
+	// certainly match an earlier clause.
+	alternatives := []types.Value{scrutinee}
+	if anyof.Is(scrutinee) && !anyof.IsMaybe(scrutinee) {
+		alternatives, _ = anyof.PossibleValues(scrutinee)
+	}
+
+	var results []types.Value
+
+	for _, alternative := range alternatives {
+		matchedAny := false
+
+		for _, c := range clauses {
+			ms, exhaustive, err := c.pattern.Match(e, alternative)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, m := range ms {
+				matchedAny = true
+
+				clauseEnv := env.New(e)
+				for _, binding := range m.Bindings {
+					clauseEnv.Bind(binding.Name, binding.Value)
+				}
+
+				result, err := expr.Progn(clauseEnv, c.body)
+				if err != nil {
+					return nil, err
+				}
+				results = append(results, result)
+			}
+
+			if exhaustive {
+				break
+			}
+		}
+
+		if !matchedAny {
+			return nil, lisperr.Wrap("match", lisperr.NoMatchingClause{alternative})
+		}
+	}
+
+	return outcome.Combine(results, exceptions)
+}
+
+// namedLet executes (let name ((var init)...) forms...), which binds name
+// to a function of the variables in the body, and calls it with the initial
+// values. Unlike do, a named let recurses, so it is bounded by stack depth.
+func namedLet(e types.Env, unevaluated []types.Value) (types.Value, error) {
+	if len(unevaluated) < 3 {
+		return nil, fmt.Errorf("let: too few arguments")
+	}
+
+	name, err := symbol.Name(unevaluated[0])
+	if err != nil {
+		return nil, err
+	}
+
+	bindings, err := expr.UnwrapList(unevaluated[1])
+	if err != nil {
+		return nil, fmt.Errorf("error unwrapping bindings: %v", err)
+	}
+
+	var params, args []types.Value
+	for i, binding := range bindings {
+		bindingList, err := expr.UnwrapList(binding)
+		if err != nil {
+			return nil, fmt.Errorf("binding %d: error unwrapping: %v", i, err)
+		}
+		if len(bindingList) != 2 {
+			return nil, fmt.Errorf("binding %d: wrong length (want 2): %d", i, len(bindingList))
+		}
+
+		val, err := bindingList[1].Eval(e)
+		if err != nil {
+			return nil, err
+		}
+
+		params = append(params, bindingList[0])
+		args = append(args, val)
+	}
+
+	childEnv := env.New(e)
+
+	funcVal, err := makeFunction(&name, childEnv, expr.WrapList(params), unevaluated[2:])
+	if err != nil {
+		return nil, err
+	}
+	childEnv.Bind(symbol.IdOrPanic(unevaluated[0]), funcVal)
+
+	return funcVal.(types.Callable).Call(args)
+}
+
+type doSpecialForm struct{}
+
+func (i doSpecialForm) TypeName() string                    { return "special" }
+func (i doSpecialForm) IsPure() bool                        { return true }
+func (i doSpecialForm) Hashcode() uint32                    { return hashcode.Hash("special:", []byte(i.String())) }
+func (i doSpecialForm) String() string                      { return specialFormString("do") }
+func (i doSpecialForm) Falsey() bool                        { return false }
+func (i doSpecialForm) Eval(types.Env) (types.Value, error) { return i, nil }
+func (i doSpecialForm) Execute(e types.Env, unevaluated []types.Value) (types.Value, error) {
+	// (do ((var init step)...) (test result...) body...)
+	if len(unevaluated) < 2 {
+		return nil, fmt.Errorf("do: too few arguments")
+	}
+
+	bindings, err := expr.UnwrapList(unevaluated[0])
+	if err != nil {
+		return nil, fmt.Errorf("error unwrapping bindings: %v", err)
+	}
+
+	// steps[i] is nil for a variable without a step form.
+	var ids []uint32
+	var values, steps []types.Value
+
+	for i, binding := range bindings {
+		bindingList, err := expr.UnwrapList(binding)
+		if err != nil {
+			return nil, fmt.Errorf("binding %d: error unwrapping: %v", i, err)
+		}
+		if len(bindingList) != 2 && len(bindingList) != 3 {
+			return nil, fmt.Errorf("binding %d: wrong length (want 2 or 3): %d", i, len(bindingList))
+		}
+
+		sym, err := symbol.Id(bindingList[0])
+		if err != nil {
+			return nil, fmt.Errorf("binding %d: error getting binding name: %v", i, err)
+		}
+
+		val, err := bindingList[1].Eval(e)
+		if err != nil {
+			return nil, err
+		}
+
+		var step types.Value
+		if len(bindingList) == 3 {
+			step = bindingList[2]
+		}
+
+		ids = append(ids, sym)
+		values = append(values, val)
+		steps = append(steps, step)
+	}
+
+	exitClause, err := expr.UnwrapList(unevaluated[1])
+	if err != nil {
+		return nil, fmt.Errorf("error unwrapping exit clause: %v", err)
+	}
+	if len(exitClause) < 1 {
+		return nil, fmt.Errorf("do: exit clause has no test")
+	}
+
+	body := unevaluated[2:]
+
+	// Results of the worlds in which the loop terminated. When the test is
+	// maybe, the loop both terminates and continues, like both branches of
+	// an if; the result is then the any-of of the results. A test that may
+	// throw adds its exceptions as outcomes, as for an if. A test that stays
+	// maybe may never be true, so past as many maybe exits as an any-of can
+	// hold, the result is widened to unknown (as by anyof.New).
+	var results []types.Value
+	var exceptions []error
+	var maybeExits int64
+
+	for {
+		// Each iteration gets a fresh environment, so that closures
+		// created in one iteration are not affected by the next.
+		loopEnv := env.New(e)
+		for i, sym := range ids {
+			loopEnv.Bind(sym, values[i])
+		}
+
+		condition, err := exitClause[0].Eval(loopEnv)
+		if err != nil {
+			return nil, err
+		}
+		condition, conditionExceptions := maythrow.Split(condition)
+		exceptions = append(exceptions, conditionExceptions...)
+
+		tv, err := unknown.TruthValue(condition)
+		if err != nil {
+			return nil, err
+		}
+
+		if tv != types.False {
+			var result types.Value = null.Nil
+			if len(exitClause) > 1 {
+				result, err = expr.Progn(loopEnv, exitClause[1:])
+				if err != nil {
+					return nil, err
+				}
+			}
+			results = append(results, result)
+		}
+
+		if tv == types.True {
+			break
+		}
+
+		if tv == types.Maybe {
+			maybeExits++
+			if maybeExits >= anyof.MaxAnyOfElements {
+				return maythrow.New(fullyunknown.Value, exceptions), nil
+			}
+		}
+
+		for _, form := range body {
+			if _, err := form.Eval(loopEnv); err != nil {
+				return nil, err
+			}
+		}
+
+		nextValues := make([]types.Value, len(values))
+		for i, step := range steps {
+			if step == nil {
+				nextValues[i] = values[i]
+				continue
+			}
+			nextValues[i], err = step.Eval(loopEnv)
+			if err != nil {
+				return nil, err
+			}
+		}
+		values = nextValues
+	}
+
+	return outcome.Combine(results, exceptions)
+}
+
+// bindRecursively binds names to values computed by valueOf in a new child
+// environment of e. The values are computed in order in the new environment
+// itself, so that functions defined there can refer to themselves and to each
+// other. (A value that uses a binding directly, rather than from within a
+// function body, can only see the bindings before it.)
+func bindRecursively(e types.Env, what string, bindings []types.Value, valueOf func(childEnv types.Env, name string, bindingList []types.Value) (types.Value, error)) (types.Env, error) {
+	childEnv := env.New(e)
+
+	for i, binding := range bindings {
+		bindingList, err := expr.UnwrapList(binding)
+		if err != nil {
+			return nil, fmt.Errorf("%s: binding %d: error unwrapping: %v", what, i, err)
+		}
+		if len(bindingList) < 1 {
+			return nil, fmt.Errorf("%s: binding %d: empty binding", what, i)
+		}
+
+		name, err := symbol.Name(bindingList[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s: binding %d: error getting binding name: %v", what, i, err)
+		}
+
+		val, err := valueOf(childEnv, name, bindingList)
+		if err != nil {
+			return nil, err
+		}
+
+		childEnv.Bind(symbol.IdOrPanic(bindingList[0]), val)
+	}
+
+	return childEnv, nil
+}
+
+type letrecSpecialForm struct{}
+
+func (i letrecSpecialForm) TypeName() string                    { return "special" }
+func (i letrecSpecialForm) IsPure() bool                        { return true }
+func (i letrecSpecialForm) Hashcode() uint32                    { return hashcode.Hash("special:", []byte(i.String())) }
+func (i letrecSpecialForm) String() string                      { return specialFormString("letrec") }
+func (i letrecSpecialForm) Falsey() bool                        { return false }
+func (i letrecSpecialForm) Eval(types.Env) (types.Value, error) { return i, nil }
+func (i letrecSpecialForm) Execute(e types.Env, unevaluated []types.Value) (types.Value, error) {
+	// (letrec ((name value)...) forms...)
+	if len(unevaluated) < 2 {
+		return nil, fmt.Errorf("letrec: too few arguments")
+	}
+
+	bindings, err := expr.UnwrapList(unevaluated[0])
+	if err != nil {
+		return nil, fmt.Errorf("error unwrapping bindings: %v", err)
+	}
+
+	childEnv, err := bindRecursively(e, "letrec", bindings, func(childEnv types.Env, name string, bindingList []types.Value) (types.Value, error) {
+		if len(bindingList) != 2 {
+			return nil, fmt.Errorf("letrec: binding %q: wrong length (want 2): %d", name, len(bindingList))
+		}
+		return bindingList[1].Eval(childEnv)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return expr.Progn(childEnv, unevaluated[1:])
+}
+
+type labelsSpecialForm struct{}
+
+func (i labelsSpecialForm) TypeName() string                    { return "special" }
+func (i labelsSpecialForm) IsPure() bool                        { return true }
+func (i labelsSpecialForm) Hashcode() uint32                    { return hashcode.Hash("special:", []byte(i.String())) }
+func (i labelsSpecialForm) String() string                      { return specialFormString("labels") }
+func (i labelsSpecialForm) Falsey() bool                        { return false }
+func (i labelsSpecialForm) Eval(types.Env) (types.Value, error) { return i, nil }
+func (i labelsSpecialForm) Execute(e types.Env, unevaluated []types.Value) (types.Value, error) {
+	// (labels ((name (params) body...)...) forms...)
+	if len(unevaluated) < 2 {
+		return nil, fmt.Errorf("labels: too few arguments")
+	}
+
+	bindings, err := expr.UnwrapList(unevaluated[0])
+	if err != nil {
+		return nil, fmt.Errorf("error unwrapping bindings: %v", err)
+	}
+
+	childEnv, err := bindRecursively(e, "labels", bindings, func(childEnv types.Env, name string, bindingList []types.Value) (types.Value, error) {
+		if len(bindingList) < 3 {
+			return nil, fmt.Errorf("labels: binding %q: too few elements (want name, parameters and body)", name)
+		}
+		return makeFunction(&name, childEnv, bindingList[1], bindingList[2:])
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return expr.Progn(childEnv, unevaluated[1:])
+}
+
+// mapRecord applies f to the field values of v, which must be a record of
+// type t. If v is an #any-of, f is applied to each possible value and the
+// results are combined. If v is some other unknown that may be a record of
+// type t, the result is unknown.
+func mapRecord(t *record.Type, v types.Value, f func([]types.Value) (types.Value, error)) (types.Value, error) {
+	xs, ok := anyof.PossibleValues(v)
+	if !ok {
+		if typenames, ok := v.(types.Unknown).ActualTypeName(); ok && !containsString(typenames, t.Name()) {
+			return nil, lisperr.UnexpectedValue{Expectation: t.Name(), Value: v}
+		}
+		return fullyunknown.Value, nil
+	}
+
+	var results []types.Value
+	for _, x := range xs {
+		xt, values, ok := record.Decompose(x)
+		if !ok || xt != t {
+			return nil, lisperr.UnexpectedValue{Expectation: t.Name(), Value: x}
+		}
+		result, err := f(values)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+
+	if len(results) == 1 {
+		return results[0], nil
+	}
+	return anyof.New(results)
+}
+
+func containsString(xs []string, s string) bool {
+	for _, x := range xs {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// isRecordOfType checks whether v is a record of type t.
+func isRecordOfType(t *record.Type, v types.Value) types.Value {
+	xs, ok := anyof.PossibleValues(v)
+	if !ok {
+		if typenames, ok := v.(types.Unknown).ActualTypeName(); ok && !containsString(typenames, t.Name()) {
+			return boolean.False
+		}
+		return unknown.MaybeValue
+	}
+
+	var sawTrue, sawFalse bool
+	for _, x := range xs {
+		if xt, _, ok := record.Decompose(x); ok && xt == t {
+			sawTrue = true
+		} else {
+			sawFalse = true
+		}
+	}
+
+	switch {
+	case sawTrue && sawFalse:
+		return unknown.MaybeValue
+	case sawTrue:
+		return boolean.True
+	}
+	return boolean.False
+}
+
+type defstructSpecialForm struct{}
+
+func (i defstructSpecialForm) TypeName() string                    { return "special" }
+func (i defstructSpecialForm) IsPure() bool                        { return false }
+func (i defstructSpecialForm) Hashcode() uint32                    { return hashcode.Hash("special:", []byte(i.String())) }
+func (i defstructSpecialForm) String() string                      { return specialFormString("defstruct!") }
+func (i defstructSpecialForm) Falsey() bool                        { return false }
+func (i defstructSpecialForm) Eval(types.Env) (types.Value, error) { return i, nil }
+func (i defstructSpecialForm) Execute(e types.Env, unevaluated []types.Value) (types.Value, error) {
+	// (defstruct! name fields...)
+	// defines (make-name :field value...), (name-field record), (name? value)
+	// and (name-with record :field value...).
+	if len(unevaluated) < 1 {
+		return nil, fmt.Errorf("defstruct!: too few arguments")
+	}
+
+	name, err := symbol.Name(unevaluated[0])
+	if err != nil || symbol.IsKeyword(unevaluated[0]) {
+		return nil, fmt.Errorf("defstruct!: invalid name: %v", unevaluated[0])
+	}
+
+	var fields []string
+	for _, x := range unevaluated[1:] {
+		field, err := symbol.Name(x)
+		if err != nil || symbol.IsKeyword(x) {
+			return nil, fmt.Errorf("defstruct!: invalid field name: %v", x)
+		}
+		fields = append(fields, field)
+	}
+
+	t, err := record.NewType(name, fields)
+	if err != nil {
+		return nil, fmt.Errorf("defstruct!: %v", err)
+	}
+
+	define := func(fname string, f func([]types.Value) (types.Value, error)) {
+		e.BindRoot(symbol.StringToIdOrPanic(fname), builtinfunc.New(fname, purity.NameIsPure(fname), f))
+	}
+
+	define("make-"+name, func(xs []types.Value) (types.Value, error) {
+		kws, err := lambdalist.ParseKeywords(xs, fields...)
+		if err != nil {
+			return nil, err
+		}
+		values := make([]types.Value, len(fields))
+		for i, field := range fields {
+			if v, ok := kws[field]; ok {
+				values[i] = v
+			} else {
+				values[i] = null.Nil
+			}
+		}
+		return record.New(t, values)
+	})
+
+	define(name+"?", func(xs []types.Value) (types.Value, error) {
+		if len(xs) != 1 {
+			return nil, lisperr.WrongArity{"1 param", len(xs)}
+		}
+		return isRecordOfType(t, xs[0]), nil
+	})
+
+	for index, field := range fields {
+		index := index
+		define(name+"-"+field, func(xs []types.Value) (types.Value, error) {
+			if len(xs) != 1 {
+				return nil, lisperr.WrongArity{"1 param", len(xs)}
+			}
+			return mapRecord(t, xs[0], func(values []types.Value) (types.Value, error) {
+				return values[index], nil
+			})
+		})
+	}
+
+	define(name+"-with", func(xs []types.Value) (types.Value, error) {
+		if len(xs) < 1 {
+			return nil, lisperr.WrongArity{"at least 1 param", len(xs)}
+		}
+		kws, err := lambdalist.ParseKeywords(xs[1:], fields...)
+		if err != nil {
+			return nil, err
+		}
+		return mapRecord(t, xs[0], func(values []types.Value) (types.Value, error) {
+			updated := make([]types.Value, len(values))
+			for i, field := range fields {
+				if v, ok := kws[field]; ok {
+					updated[i] = v
+				} else {
+					updated[i] = values[i]
+				}
+			}
+			return record.New(t, updated)
+		})
+	})
+
+	return symbol.New(name), nil
+}
+
+type moduleSpecialForm struct{}
+
+func (i moduleSpecialForm) TypeName() string                    { return "special" }
+func (i moduleSpecialForm) IsPure() bool                        { return true }
+func (i moduleSpecialForm) Hashcode() uint32                    { return hashcode.Hash("special:", []byte(i.String())) }
+func (i moduleSpecialForm) String() string                      { return specialFormString("module") }
+func (i moduleSpecialForm) Falsey() bool                        { return false }
+func (i moduleSpecialForm) Eval(types.Env) (types.Value, error) { return i, nil }
+func (i moduleSpecialForm) Execute(e types.Env, unevaluated []types.Value) (types.Value, error) {
+	// (module name (export names...))
+	// The header is interpreted by the module loader. When a module file is
+	// run directly, its definitions simply go into the global namespace.
+	header, err := module.ParseHeader(unevaluated)
 	if err != nil {
-		return nil, fmt.Errorf("must defun! symbol as name, not %v", unevaluated[0])
+		return nil, err
 	}
+	return symbol.New(header.Name), nil
+}
 
-	funcVal, err := makeFunction(&name, e, unevaluated[1], unevaluated[2:])
+// parseModuleAlias parses the optional ":as alias" after the module given to
+// import or require.
+func parseModuleAlias(what string, xs []types.Value) (string, bool, error) {
+	kws, err := lambdalist.ParseKeywords(xs, "as")
 	if err != nil {
-		return nil, err
+		return "", false, fmt.Errorf("%s: %v", what, err)
 	}
-
-	e.BindRoot(symbol.StringToIdOrPanic(name), funcVal)
-	return funcVal, nil
+	alias, ok := kws["as"]
+	if !ok {
+		return "", false, nil
+	}
+	name, err := symbol.Name(alias)
+	if err != nil {
+		return "", false, fmt.Errorf("%s: invalid alias %v: %v", what, alias, err)
+	}
+	return name, true, nil
 }
 
-type defmacroSpecialForm struct{}
+type importSpecialForm struct {
+	loader *module.Loader
+}
 
-func (i defmacroSpecialForm) TypeName() string                    { return "special" }
-func (i defmacroSpecialForm) IsPure() bool                        { return false }
-func (i defmacroSpecialForm) Hashcode() uint32                    { return hashcode.Hash("special:", []byte(i.String())) }
-func (i defmacroSpecialForm) String() string                      { return specialFormString("defmacro!") }
-func (i defmacroSpecialForm) Falsey() bool                        { return false }
-func (i defmacroSpecialForm) Eval(types.Env) (types.Value, error) { return i, nil }
-func (i defmacroSpecialForm) Execute(e types.Env, unevaluated []types.Value) (types.Value, error) {
-	if len(unevaluated) < 3 {
-		return nil, fmt.Errorf("defmacro!: too few arguments")
+func (i importSpecialForm) TypeName() string                    { return "special" }
+func (i importSpecialForm) IsPure() bool                        { return false }
+func (i importSpecialForm) Hashcode() uint32                    { return hashcode.Hash("special:", []byte(i.String())) }
+func (i importSpecialForm) String() string                      { return specialFormString("import") }
+func (i importSpecialForm) Falsey() bool                        { return false }
+func (i importSpecialForm) Eval(types.Env) (types.Value, error) { return i, nil }
+func (i importSpecialForm) Execute(e types.Env, unevaluated []types.Value) (types.Value, error) {
+	// (import name [:as alias])
+	if len(unevaluated) < 1 {
+		return nil, fmt.Errorf("import: too few arguments")
 	}
 
 	name, err := symbol.Name(unevaluated[0])
 	if err != nil {
-		return nil, fmt.Errorf("defmacro! name: %v", err)
+		return nil, fmt.Errorf("import: invalid module name %v: %v", unevaluated[0], err)
 	}
 
-	macroValue, err := macro.New(e, name, unevaluated[1], unevaluated[2:])
+	prefix, hasAlias, err := parseModuleAlias("import", unevaluated[1:])
 	if err != nil {
 		return nil, err
 	}
+	if !hasAlias {
+		prefix = name
+	}
 
-	e.BindRoot(symbol.StringToIdOrPanic(name), macroValue)
+	m, err := i.loader.Import(name)
+	if err != nil {
+		return nil, err
+	}
 
-	return macroValue, nil
+	if err := module.BindExports(e, m, prefix); err != nil {
+		return nil, err
+	}
+
+	return m, nil
 }
 
-type letSpecialForm struct{}
+type requireSpecialForm struct {
+	loader *module.Loader
+}
 
-func (i letSpecialForm) TypeName() string                    { return "special" }
-func (i letSpecialForm) IsPure() bool                        { return true }
-func (i letSpecialForm) Hashcode() uint32                    { return hashcode.Hash("special:", []byte(i.String())) }
-func (i letSpecialForm) String() string                      { return specialFormString("let") }
-func (i letSpecialForm) Falsey() bool                        { return false }
-func (i letSpecialForm) Eval(types.Env) (types.Value, error) { return i, nil }
-func (i letSpecialForm) Execute(e types.Env, unevaluated []types.Value) (types.Value, error) {
-	// (let (bindings) forms...)
-	if len(unevaluated) < 2 {
-		return nil, fmt.Errorf("let: too few arguments")
+func (i requireSpecialForm) TypeName() string                    { return "special" }
+func (i requireSpecialForm) IsPure() bool                        { return false }
+func (i requireSpecialForm) Hashcode() uint32                    { return hashcode.Hash("special:", []byte(i.String())) }
+func (i requireSpecialForm) String() string                      { return specialFormString("require") }
+func (i requireSpecialForm) Falsey() bool                        { return false }
+func (i requireSpecialForm) Eval(types.Env) (types.Value, error) { return i, nil }
+func (i requireSpecialForm) Execute(e types.Env, unevaluated []types.Value) (types.Value, error) {
+	// (require "path" [:as alias])
+	if len(unevaluated) < 1 {
+		return nil, fmt.Errorf("require: too few arguments")
 	}
 
-	bindings, err := expr.UnwrapList(unevaluated[0])
+	pathValue, err := unevaluated[0].Eval(e)
 	if err != nil {
-		return nil, fmt.Errorf("error unwrapping bindings: %v", err)
+		return nil, err
 	}
 
-	childEnv := env.New(e)
+	path, err := str.ToString(pathValue)
+	if err != nil {
+		return nil, lisperr.UnexpectedValue{Expectation: "module path string", Value: pathValue}
+	}
 
-	for i, binding := range bindings {
-		bindingList, err := expr.UnwrapList(binding)
-		if err != nil {
-			return nil, fmt.Errorf("binding %d: error unwrapping: %v", i, err)
-		}
-		if len(bindingList) != 2 {
-			return nil, fmt.Errorf("binding %d: wrong length (want 2): %d", i, len(bindingList))
-		}
+	prefix, hasAlias, err := parseModuleAlias("require", unevaluated[1:])
+	if err != nil {
+		return nil, err
+	}
 
-		sym, err := symbol.Id(bindingList[0])
-		if err != nil {
-			return nil, fmt.Errorf("binding %d: error getting binding name: %v", i, err)
-		}
+	m, err := i.loader.Require(path)
+	if err != nil {
+		return nil, err
+	}
 
-		val, err := bindingList[1].Eval(e)
+	if !hasAlias {
+		prefix, err = module.Name(m)
 		if err != nil {
 			return nil, err
 		}
+	}
 
-		childEnv.Bind(sym, val)
+	if err := module.BindExports(e, m, prefix); err != nil {
+		return nil, err
 	}
 
-	return expr.Progn(childEnv, unevaluated[1:])
+	return m, nil
 }
 
 type handleExceptionSpecialForm struct{}
@@ -333,18 +1239,220 @@ func (i handleExceptionSpecialForm) Execute(e types.Env, unevaluated []types.Val
 		return nil, err
 	}
 
+	handle := func(err error) (types.Value, error) {
+		if exc, ok := err.(lisperr.LispException); ok {
+			childEnv := env.New(e)
+			childEnv.Bind(errorVarName, exc.Value())
+			return errorHandlerBody.Eval(childEnv)
+		}
+		return nil, err
+	}
+
 	val, err := unevaluated[0].Eval(e)
-	if err == nil {
+	if err != nil {
+		if !outcome.IsCatchable(err) {
+			return nil, err
+		}
+		return handleFailingWorlds(nil, maythrow.Exceptions(err), handle)
+	}
+
+	normal, exceptions := maythrow.Split(val)
+	if len(exceptions) == 0 {
 		return val, nil
 	}
+	return handleFailingWorlds([]types.Value{normal}, exceptions, handle)
+}
+
+// handleFailingWorlds applies an exception handler to each of the possible
+// exceptions of an evaluation. The result is one of the normal values (if
+// any), or one of the values returned by the handler, or one of the
+// exceptions that were not handled.
+func handleFailingWorlds(values []types.Value, exceptions []error, handle func(error) (types.Value, error)) (types.Value, error) {
+	var unhandled []error
+	for _, exc := range exceptions {
+		val, err := handle(exc)
+		if err != nil {
+			if !outcome.IsCatchable(err) {
+				return nil, err
+			}
+			unhandled = append(unhandled, maythrow.Exceptions(err)...)
+			continue
+		}
+		values = append(values, val)
+	}
+
+	return outcome.Combine(values, unhandled)
+}
+
+type mayThrowSpecialForm struct {
+	// must is set for must-throw?, which is true only if the expression
+	// throws in every possible world.
+	must bool
+}
+
+func (i mayThrowSpecialForm) TypeName() string { return "special" }
+func (i mayThrowSpecialForm) IsPure() bool     { return true }
+func (i mayThrowSpecialForm) Hashcode() uint32 {
+	return hashcode.Hash("special:", []byte(i.String()))
+}
+func (i mayThrowSpecialForm) name() string {
+	if i.must {
+		return "must-throw?"
+	}
+	return "may-throw?"
+}
+func (i mayThrowSpecialForm) String() string                      { return specialFormString(i.name()) }
+func (i mayThrowSpecialForm) Falsey() bool                        { return false }
+func (i mayThrowSpecialForm) Eval(types.Env) (types.Value, error) { return i, nil }
+func (i mayThrowSpecialForm) Execute(e types.Env, unevaluated []types.Value) (types.Value, error) {
+	// (may-throw? expr) or (must-throw? expr)
+	if len(unevaluated) != 1 {
+		return nil, fmt.Errorf("%s: expects 1 param, got %d", i.name(), len(unevaluated))
+	}
+
+	val, err := unevaluated[0].Eval(e)
+	if err != nil {
+		if !outcome.IsCatchable(err) {
+			return nil, err
+		}
+		return boolean.True, nil
+	}
+
+	if i.must {
+		return boolean.False, nil
+	}
+	return boolean.FromBool(maythrow.Is(val)), nil
+}
+
+type catchClause struct {
+	// kind is empty for a clause that catches any kind of exception.
+	kind     string
+	variable uint32
+	body     []types.Value
+}
+
+// parseTryClause parses a (catch ...) or (finally ...) clause at the end of
+// a try form; ok is false if v is not such a clause.
+func parseTryClause(v types.Value) (name string, clause catchClause, ok bool, err error) {
+	xs, err := expr.UnwrapList(v)
+	if err != nil || len(xs) == 0 {
+		return "", catchClause{}, false, nil
+	}
+	name, err = symbol.Name(xs[0])
+	if err != nil || (name != "catch" && name != "finally") {
+		return "", catchClause{}, false, nil
+	}
+
+	if name == "finally" {
+		if len(xs) < 2 {
+			return "", catchClause{}, false, fmt.Errorf("try: empty finally clause")
+		}
+		return name, catchClause{body: xs[1:]}, true, nil
+	}
+
+	// (catch ([kind] var) body...)
+	if len(xs) < 3 {
+		return "", catchClause{}, false, fmt.Errorf("try: catch clause must be (catch ([kind] var) body...): %v", v)
+	}
+	spec, err := expr.UnwrapList(xs[1])
+	if err != nil || len(spec) < 1 || len(spec) > 2 {
+		return "", catchClause{}, false, fmt.Errorf("try: invalid catch specification %v", xs[1])
+	}
+	if len(spec) == 2 {
+		clause.kind, err = symbol.Name(spec[0])
+		if err != nil {
+			return "", catchClause{}, false, fmt.Errorf("try: invalid exception kind %v", spec[0])
+		}
+	}
+	clause.variable, err = symbol.Id(spec[len(spec)-1])
+	if err != nil {
+		return "", catchClause{}, false, fmt.Errorf("try: invalid catch variable %v", spec[len(spec)-1])
+	}
+	clause.body = xs[2:]
+	return name, clause, true, nil
+}
+
+type trySpecialForm struct{}
+
+func (i trySpecialForm) TypeName() string                    { return "special" }
+func (i trySpecialForm) IsPure() bool                        { return true }
+func (i trySpecialForm) Hashcode() uint32                    { return hashcode.Hash("special:", []byte(i.String())) }
+func (i trySpecialForm) String() string                      { return specialFormString("try") }
+func (i trySpecialForm) Falsey() bool                        { return false }
+func (i trySpecialForm) Eval(types.Env) (types.Value, error) { return i, nil }
+func (i trySpecialForm) Execute(e types.Env, unevaluated []types.Value) (types.Value, error) {
+	// (try body... (catch (kind var) handler...)... (finally cleanup...))
+	// A catch clause without a kind, (catch (var) handler...), catches any
+	// exception. Within a handler, (rethrow) rethrows the caught exception.
+	var catches []catchClause
+	var finally []types.Value
+
+	body := unevaluated
+	for len(body) > 0 {
+		name, clause, ok, err := parseTryClause(body[len(body)-1])
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		if name == "finally" {
+			if finally != nil || len(catches) > 0 {
+				return nil, fmt.Errorf("try: finally must be the last clause")
+			}
+			finally = clause.body
+		} else {
+			catches = append([]catchClause{clause}, catches...)
+		}
+		body = body[:len(body)-1]
+	}
+
+	if len(body) == 0 {
+		return nil, fmt.Errorf("try: no body")
+	}
+
+	catch := func(err error) (types.Value, error) {
+		caught, kind, ok := condition.FromError(err)
+		if !ok {
+			return nil, err
+		}
+		for _, clause := range catches {
+			if clause.kind != "" && clause.kind != kind {
+				continue
+			}
+
+			childEnv := env.New(e)
+			childEnv.Bind(clause.variable, caught)
+			childEnv.Bind(symbol.StringToIdOrPanic("rethrow"), builtinfunc.New("rethrow", true, func(xs []types.Value) (types.Value, error) {
+				if len(xs) != 0 {
+					return nil, lisperr.WrongArity{"0 params", len(xs)}
+				}
+				return nil, err
+			}))
+			return expr.Progn(childEnv, clause.body)
+		}
+		return nil, err
+	}
+
+	val, err := expr.Progn(e, body)
+	if err != nil {
+		if outcome.IsCatchable(err) {
+			val, err = handleFailingWorlds(nil, maythrow.Exceptions(err), catch)
+		}
+	} else if normal, exceptions := maythrow.Split(val); len(exceptions) > 0 {
+		val, err = handleFailingWorlds([]types.Value{normal}, exceptions, catch)
+	}
 
-	if exc, ok := err.(lisperr.LispException); ok {
-		childEnv := env.New(e)
-		childEnv.Bind(errorVarName, exc.Value())
-		return errorHandlerBody.Eval(childEnv)
+	if finally != nil {
+		if _, finallyErr := expr.Progn(e, finally); finallyErr != nil {
+			return nil, finallyErr
+		}
 	}
 
-	return nil, err
+	if err != nil {
+		return nil, err
+	}
+	return val, nil
 }
 
 type andSpecialForm struct{}
@@ -356,13 +1464,39 @@ func (i andSpecialForm) String() string                      { return specialFor
 func (i andSpecialForm) Falsey() bool                        { return false }
 func (i andSpecialForm) Eval(types.Env) (types.Value, error) { return i, nil }
 func (i andSpecialForm) Execute(e types.Env, unevaluated []types.Value) (types.Value, error) {
+	return runAnd(e, compile.InterpretedAll(unevaluated))
+}
+
+func (i andSpecialForm) Compile(c *compile.Compiler, args []types.Value) (compile.Code, error) {
+	codes, err := c.CompileAll(args)
+	if err != nil {
+		return nil, err
+	}
+	return func(e types.Env) (types.Value, error) {
+		return runAnd(e, codes)
+	}, nil
+}
+
+func (i andSpecialForm) Assemble(a *compile.Assembler, args []types.Value, tail bool) error {
+	return a.And(args)
+}
+
+func runAnd(e types.Env, codes []compile.Code) (types.Value, error) {
 	knownToMaybeBeFalse := false
+	var exceptions []error
 
-	for _, uneval := range unevaluated {
-		eval, err := uneval.Eval(e)
+	for _, code := range codes {
+		eval, err := code(e)
 		if err != nil {
+			// If an earlier argument may have ended the evaluation, this
+			// exception is only one of the possible outcomes.
+			if outcome.IsCatchable(err) && knownToMaybeBeFalse {
+				return maythrow.New(boolean.False, append(exceptions, err)), nil
+			}
 			return nil, err
 		}
+		eval, evalExceptions := maythrow.Split(eval)
+		exceptions = append(exceptions, evalExceptions...)
 		truth, err := unknown.TruthValue(eval)
 		if err != nil {
 			return nil, err
@@ -371,17 +1505,17 @@ func (i andSpecialForm) Execute(e types.Env, unevaluated []types.Value) (types.V
 		case types.True:
 			break
 		case types.False:
-			return boolean.False, nil
+			return maythrow.New(boolean.False, exceptions), nil
 		default:
 			knownToMaybeBeFalse = true
 		}
 	}
 
 	if knownToMaybeBeFalse {
-		return unknown.MaybeValue, nil
+		return maythrow.New(unknown.MaybeValue, exceptions), nil
 	}
 
-	return boolean.True, nil
+	return maythrow.New(boolean.True, exceptions), nil
 }
 
 type orSpecialForm struct{}
@@ -393,20 +1527,46 @@ func (i orSpecialForm) String() string                      { return specialForm
 func (i orSpecialForm) Falsey() bool                        { return false }
 func (i orSpecialForm) Eval(types.Env) (types.Value, error) { return i, nil }
 func (i orSpecialForm) Execute(e types.Env, unevaluated []types.Value) (types.Value, error) {
+	return runOr(e, compile.InterpretedAll(unevaluated))
+}
+
+func (i orSpecialForm) Compile(c *compile.Compiler, args []types.Value) (compile.Code, error) {
+	codes, err := c.CompileAll(args)
+	if err != nil {
+		return nil, err
+	}
+	return func(e types.Env) (types.Value, error) {
+		return runOr(e, codes)
+	}, nil
+}
+
+func (i orSpecialForm) Assemble(a *compile.Assembler, args []types.Value, tail bool) error {
+	return a.Or(args)
+}
+
+func runOr(e types.Env, codes []compile.Code) (types.Value, error) {
 	knownToMaybeBeTrue := false
+	var exceptions []error
 
-	for _, uneval := range unevaluated {
-		eval, err := uneval.Eval(e)
+	for _, code := range codes {
+		eval, err := code(e)
 		if err != nil {
+			// If an earlier argument may have ended the evaluation, this
+			// exception is only one of the possible outcomes.
+			if outcome.IsCatchable(err) && knownToMaybeBeTrue {
+				return maythrow.New(boolean.True, append(exceptions, err)), nil
+			}
 			return nil, err
 		}
+		eval, evalExceptions := maythrow.Split(eval)
+		exceptions = append(exceptions, evalExceptions...)
 		truth, err := unknown.TruthValue(eval)
 		if err != nil {
 			return nil, err
 		}
 		switch truth {
 		case types.True:
-			return boolean.True, nil
+			return maythrow.New(boolean.True, exceptions), nil
 		case types.False:
 			break
 		default:
@@ -415,10 +1575,10 @@ func (i orSpecialForm) Execute(e types.Env, unevaluated []types.Value) (types.Va
 	}
 
 	if knownToMaybeBeTrue {
-		return unknown.MaybeValue, nil
+		return maythrow.New(unknown.MaybeValue, exceptions), nil
 	}
 
-	return boolean.False, nil
+	return maythrow.New(boolean.False, exceptions), nil
 }
 
 type lambdaSpecialForm struct{}
@@ -442,13 +1602,27 @@ func (i lambdaSpecialForm) Execute(e types.Env, unevaluated []types.Value) (type
 	return funcVal, nil
 }
 
+func (i lambdaSpecialForm) Compile(c *compile.Compiler, args []types.Value) (compile.Code, error) {
+	if len(args) < 2 {
+		return nil, fmt.Errorf("lambda: too few arguments")
+	}
+	return c.Lambda("", args[0], args[1:])
+}
+
+func (i lambdaSpecialForm) Assemble(a *compile.Assembler, args []types.Value, tail bool) error {
+	if len(args) < 2 {
+		return fmt.Errorf("lambda: too few arguments")
+	}
+	return a.Lambda("", args[0], args[1:])
+}
+
 func makeFunction(namePtr *string, lexicalEnv types.Env, formalParamSpec types.Value, body []types.Value) (types.Value, error) {
 	name := ""
 	if namePtr != nil {
 		name = *namePtr
 	}
 
-	return function.New(lexicalEnv, name, formalParamSpec, body)
+	return compile.Function(lexicalEnv, name, formalParamSpec, body)
 }
 
 func BindDefaults(e types.Env) {
@@ -463,9 +1637,22 @@ func BindDefaults(e types.Env) {
 	bind("quote", &quoteSpecialForm{})
 	bind("quasiquote", &quasiquoteSpecialForm{})
 	bind("let", &letSpecialForm{})
+	bind("letrec", &letrecSpecialForm{})
+	bind("labels", &labelsSpecialForm{})
+	bind("do", &doSpecialForm{})
+	bind("match", &matchSpecialForm{})
 	bind("and", &andSpecialForm{})
 	bind("or", &orSpecialForm{})
 	bind("handle-exception", &handleExceptionSpecialForm{})
+	bind("try", &trySpecialForm{})
+	bind("may-throw?", &mayThrowSpecialForm{must: false})
+	bind("must-throw?", &mayThrowSpecialForm{must: true})
+	bind("defstruct!", &defstructSpecialForm{})
+
+	moduleLoader := module.NewLoader(e)
+	bind("module", &moduleSpecialForm{})
+	bind("import", &importSpecialForm{moduleLoader})
+	bind("require", &requireSpecialForm{moduleLoader})
 
 	bind("nil", null.Nil)
 	bind("true", boolean.True)
@@ -599,22 +1786,28 @@ func BindDefaults(e types.Env) {
 		}
 	})
 
-	Unary(e, "length", func(consish types.Value) (types.Value, error) {
-		zero := integer.FromInt64(0)
-		one := integer.FromInt64(1)
-		f := func(lastCount, _ types.Value) (types.Value, error) {
-			rv, err := numerics.BinaryPlus(lastCount, one)
-			return rv, err
-		}
-		g := func(lastCount, tail types.Value) (types.Value, error) {
-			_, ok := tail.(types.Unknown)
-			if !ok {
-				return nil, lisperr.UnexpectedValue{"valid list-tail", consish}
-			}
-			// TODO: do any sort of filtering of unknowns.
-			return numinrange.New(lastCount.(types.Numeric), nil, true, false, []string{integer.TypeName})
+	Unary(e, "length", listops.Length)
+	Values(e, "append", listops.Append)
+	Values(e, "list*", listops.ListStar)
+	Binary(e, "nth", listops.Nth)
+	Binary(e, "take", listops.Take)
+	Binary(e, "drop", listops.Drop)
+
+	Unary(e, "sorted", func(l types.Value) (types.Value, error) {
+		return listops.Sorted(numericLess, l)
+	})
+
+	Binary(e, "sort-by", func(f, l types.Value) (types.Value, error) {
+		callable, ok := f.(types.Callable)
+		if !ok {
+			return nil, lisperr.UnexpectedValue{"callable", f}
+		}
+
+		key := func(a types.Value) (types.Value, error) {
+			return callable.Call([]types.Value{a})
 		}
-		return listops.FoldLeftWithOddTail(f, g, zero, consish)
+
+		return listops.SortedBy(numericLess, key, l)
 	})
 
 	Binary(e, "apply", func(f, args types.Value) (types.Value, error) {
@@ -645,6 +1838,9 @@ func BindDefaults(e types.Env) {
 			return callable.Call([]types.Value{a})
 		}
 
+		if callable.IsPure() {
+			return listops.MapPure(cb, l)
+		}
 		return listops.Map(cb, l)
 	})
 
@@ -827,32 +2023,12 @@ func BindDefaults(e types.Env) {
 		return typed.New(typenames...), nil
 	})
 
-	parseKeywords := func(xs []types.Value) (map[string]types.Value, error) {
-		if len(xs)%2 != 0 {
-			return nil, fmt.Errorf("parsing keywords from odd-numbered arguments: %v", xs)
-		}
-
-		rv := map[string]types.Value{}
-		for i := 0; i < len(xs); i += 2 {
-			name, err := symbol.Name(xs[i])
-			if err != nil {
-				return nil, fmt.Errorf("parsing keywords: %v", err)
-			}
-
-			rv[name] = xs[i+1]
-		}
-
-		return rv, nil
-	}
-
 	Values(e, "number-in-range", func(xs []types.Value) (types.Value, error) {
-		args, err := parseKeywords(xs)
+		args, err := lambdalist.ParseKeywords(xs, "from", "above", "to", "below")
 		if err != nil {
 			return nil, err
 		}
 
-		// TODO check for unused arguments
-
 		var lowVal, highVal types.Value
 		var lowIncl, highIncl bool
 
@@ -950,16 +2126,162 @@ func BindDefaults(e types.Env) {
 		return expr.WrapList(xs), nil
 	})
 
+	// gensym counts as pure by its name, so that macros can use it, although
+	// each call returns a new symbol. Memoized functions may thus return
+	// the same symbol twice, and separate possible worlds get different ones.
+	Values(e, "gensym", func(xs []types.Value) (types.Value, error) {
+		prefix := "g"
+		switch len(xs) {
+		case 0:
+		case 1:
+			var err error
+			prefix, err = str.ToString(xs[0])
+			if err != nil {
+				return nil, lisperr.UnexpectedValue{Expectation: "string prefix", Value: xs[0]}
+			}
+		default:
+			return nil, lisperr.WrongArity{"0 or 1 params", len(xs)}
+		}
+		return symbol.Gensym(prefix), nil
+	})
+
 	// note: this is a loophole from the side effect rule.
 	Unary(e, "throw-exception", func(v types.Value) (types.Value, error) {
 		return nil, lisperr.NewException(v)
 	})
 
+	Values(e, "memoize", func(xs []types.Value) (types.Value, error) {
+		// (memoize f [:capacity n])
+		if len(xs) < 1 {
+			return nil, lisperr.WrongArity{"at least 1 param", len(xs)}
+		}
+		callable, ok := xs[0].(types.Callable)
+		if !ok {
+			return nil, lisperr.UnexpectedValue{Expectation: "callable", Value: xs[0]}
+		}
+		kws, err := lambdalist.ParseKeywords(xs[1:], "capacity")
+		if err != nil {
+			return nil, err
+		}
+		capacity := memo.DefaultCapacity
+		if v, ok := kws["capacity"]; ok {
+			n, err := integer.ToInt64(v)
+			if err != nil {
+				return nil, lisperr.UnexpectedValue{Expectation: "integer capacity", Value: v}
+			}
+			capacity = int(n)
+		}
+		return memo.New(callable, capacity)
+	})
+
+	Binary(e, "throw", func(kind, message types.Value) (types.Value, error) {
+		kindName, err := symbol.Name(kind)
+		if err != nil {
+			return nil, lisperr.UnexpectedValue{Expectation: "exception kind symbol", Value: kind}
+		}
+		s, err := str.ToString(message)
+		if err != nil {
+			return nil, lisperr.UnexpectedValue{Expectation: "message string", Value: message}
+		}
+		return nil, lisperr.NewException(condition.New(kindName, s))
+	})
+
+	Unary(e, "condition?", func(v types.Value) (types.Value, error) {
+		return boolean.FromBool(condition.Is(v)), nil
+	})
+
+	Unary(e, "condition-kind", func(v types.Value) (types.Value, error) {
+		kind, err := condition.Kind(v)
+		if err != nil {
+			return nil, err
+		}
+		return symbol.New(kind), nil
+	})
+
+	Unary(e, "condition-message", func(v types.Value) (types.Value, error) {
+		message, err := condition.Message(v)
+		if err != nil {
+			return nil, err
+		}
+		return str.New(message), nil
+	})
+
+	Unary(e, "string-length", strops.Length)
+	Values(e, "substring", func(xs []types.Value) (types.Value, error) {
+		switch len(xs) {
+		case 2:
+			return strops.Substring(xs[0], xs[1], null.Nil)
+		case 3:
+			return strops.Substring(xs[0], xs[1], xs[2])
+		default:
+			return nil, lisperr.WrongArity{"2 or 3 params", len(xs)}
+		}
+	})
+	Values(e, "string-append", strops.Append)
+	Values(e, "concat", strops.Append)
+	Values(e, "split", func(xs []types.Value) (types.Value, error) {
+		switch len(xs) {
+		case 1:
+			return strops.Split(xs[0], null.Nil)
+		case 2:
+			return strops.Split(xs[0], xs[1])
+		default:
+			return nil, lisperr.WrongArity{"1 or 2 params", len(xs)}
+		}
+	})
+	Values(e, "join", func(xs []types.Value) (types.Value, error) {
+		switch len(xs) {
+		case 1:
+			return strops.Join(xs[0], str.New(""))
+		case 2:
+			return strops.Join(xs[0], xs[1])
+		default:
+			return nil, lisperr.WrongArity{"1 or 2 params", len(xs)}
+		}
+	})
+	Unary(e, "string-upcase", strops.Upcase)
+	Unary(e, "string-downcase", strops.Downcase)
+	Unary(e, "trim", strops.Trim)
+	Binary(e, "string-index", strops.Index)
+	Unary(e, "string->list", strops.ToList)
+	Unary(e, "string->symbol", strops.ToSymbol)
+	Unary(e, "symbol->string", strops.FromSymbol)
+	Unary(e, "number->string", strops.FromNumber)
+	Unary(e, "string->number", strops.ToNumber)
+
 	Binary(e, "low-level-plus", numerics.BinaryPlus)
 	Binary(e, "low-level-minus", numerics.BinaryMinus)
 	Binary(e, "low-level-multiply", numerics.BinaryMultiply)
 	Binary(e, "low-level-divide", numerics.BinaryDivision)
 	Binary(e, "mod", numerics.Mod)
+	Binary(e, "quotient", numerics.Quotient)
+	Binary(e, "low-level-min", numerics.BinaryMin)
+	Binary(e, "low-level-max", numerics.BinaryMax)
+	Binary(e, "low-level-gcd", numerics.BinaryGcd)
+	Binary(e, "low-level-lcm", numerics.BinaryLcm)
+	Binary(e, "expt", numerics.Expt)
+	Binary(e, "low-level-bit-and", numerics.BinaryBitAnd)
+	Binary(e, "low-level-bit-or", numerics.BinaryBitOr)
+	Binary(e, "low-level-bit-xor", numerics.BinaryBitXor)
+	Binary(e, "shift-left", numerics.ShiftLeft)
+	Binary(e, "shift-right", numerics.ShiftRight)
+	Binary(e, "wrap-unsigned", numerics.WrapUnsigned)
+	Binary(e, "wrap-signed", numerics.WrapSigned)
+
+	Unary(e, "abs", numerics.Abs)
+	Unary(e, "sqr", numerics.Sqr)
+	Unary(e, "floor", numerics.Floor)
+	Unary(e, "ceiling", numerics.Ceiling)
+	Unary(e, "round", numerics.Round)
+	Unary(e, "truncate", numerics.Truncate)
+	Unary(e, "sqrt", numerics.Sqrt)
+	Unary(e, "exp", numerics.Exp)
+	Unary(e, "log", numerics.Log)
+	Unary(e, "sin", numerics.Sin)
+	Unary(e, "cos", numerics.Cos)
+	Unary(e, "atan", numerics.Atan)
+	Unary(e, "bit-not", numerics.BitNot)
+	Unary(e, "popcount", numerics.Popcount)
 
 	Binary(e, "<=", numerics.BinaryLeq)
 	Binary(e, "<", numerics.BinaryLess)
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/steinarvk/heisenlisp/builtin"
+	"github.com/steinarvk/heisenlisp/compile"
+	"github.com/steinarvk/heisenlisp/gen/parser"
+	"github.com/steinarvk/heisenlisp/pretty"
+	"github.com/steinarvk/heisenlisp/types"
+)
+
+var disasmCmd = &cobra.Command{
+	Use:   "disasm [filename.hlisp]",
+	Short: "Shows the bytecode of a file",
+	Long: `disasm compiles each top-level form of a Heisenlisp file to bytecode, as
+with --vm, and writes a listing of it to stdout, followed by those of the
+functions defined within it. The file is not run, so macros defined in it
+are not expanded.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runDisasm(args[0]); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(disasmCmd)
+}
+
+func runDisasm(filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	expressionsIntf, err := parser.Parse(filename, data)
+	if err != nil {
+		return err
+	}
+
+	root := builtin.NewRootEnv()
+
+	for i, expression := range expressionsIntf.([]interface{}) {
+		val := expression.(types.Value)
+
+		program, err := compile.Assemble(root, val)
+		if err != nil {
+			return fmt.Errorf("error compiling %s: %v", pretty.Sprint(val), err)
+		}
+
+		if i > 0 {
+			fmt.Println()
+		}
+		for _, line := range strings.Split(pretty.Sprint(val), "\n") {
+			fmt.Printf("; %s\n", line)
+		}
+		if err := compile.Disassemble(os.Stdout, program); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
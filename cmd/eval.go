@@ -7,6 +7,7 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/steinarvk/heisenlisp/builtin"
 	"github.com/steinarvk/heisenlisp/code"
+	"github.com/steinarvk/heisenlisp/pretty"
 )
 
 var evalCmd = &cobra.Command{
@@ -25,7 +26,7 @@ the result to stdout. For example:
 			if err != nil {
 				log.Fatal(err)
 			}
-			fmt.Println(value)
+			fmt.Println(pretty.Sprint(value))
 		}
 	},
 }
@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/steinarvk/heisenlisp/format"
+)
+
+var fmtCmd = &cobra.Command{
+	Use:   "fmt [file or directory...]",
+	Short: "Formats Heisenlisp source files",
+	Long: `fmt formats Heisenlisp source files in a canonical format, preserving
+comments and blank lines between forms. Directories are searched for
+.hlisp files. By default the formatted code is written to stdout.
+
+With --write, files are rewritten in place. With --check, nothing is
+written; instead the names of files that are not formatted are listed
+and the command fails if there are any.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runFmt(args); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var (
+	fmtWrite *bool
+	fmtCheck *bool
+	fmtWidth *int
+)
+
+func init() {
+	fmtWrite = fmtCmd.Flags().BoolP("write", "w", false, "write result to source files instead of stdout")
+	fmtCheck = fmtCmd.Flags().Bool("check", false, "fail if any file is not formatted, without writing anything")
+	fmtWidth = fmtCmd.Flags().Int("width", format.Width, "target line width")
+
+	RootCmd.AddCommand(fmtCmd)
+}
+
+func hlispFilenames(paths []string) ([]string, error) {
+	var rv []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			rv = append(rv, path)
+			continue
+		}
+		err = filepath.Walk(path, func(filename string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && strings.HasSuffix(filename, ".hlisp") {
+				rv = append(rv, filename)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return rv, nil
+}
+
+func runFmt(paths []string) error {
+	format.Width = *fmtWidth
+
+	filenames, err := hlispFilenames(paths)
+	if err != nil {
+		return err
+	}
+
+	var unformatted []string
+
+	for _, filename := range filenames {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+
+		formatted, err := format.Source(filename, data)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case *fmtCheck:
+			if !bytes.Equal(data, formatted) {
+				unformatted = append(unformatted, filename)
+			}
+
+		case *fmtWrite:
+			if bytes.Equal(data, formatted) {
+				continue
+			}
+			info, err := os.Stat(filename)
+			if err != nil {
+				return err
+			}
+			if err := ioutil.WriteFile(filename, formatted, info.Mode()); err != nil {
+				return err
+			}
+
+		default:
+			os.Stdout.Write(formatted)
+		}
+	}
+
+	if len(unformatted) > 0 {
+		for _, filename := range unformatted {
+			fmt.Println(filename)
+		}
+		return fmt.Errorf("%d file(s) not formatted", len(unformatted))
+	}
+
+	return nil
+}
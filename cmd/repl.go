@@ -15,6 +15,7 @@ import (
 	"github.com/steinarvk/heisenlisp/code"
 	"github.com/steinarvk/heisenlisp/expr"
 	"github.com/steinarvk/heisenlisp/gen/parser"
+	"github.com/steinarvk/heisenlisp/pretty"
 	"github.com/steinarvk/heisenlisp/types"
 )
 
@@ -36,10 +37,14 @@ var (
 	replScript        *string
 	replListenAddress *string
 	replMetrics       *bool
+	replWidth         *int
+	replMaxAnyOf      *int
 )
 
 func init() {
 	replScript = replCmd.Flags().String("script", "", "execute script from filename before reading from stdin")
+	replWidth = replCmd.Flags().Int("width", pretty.DefaultOptions.Width, "target line width when printing values")
+	replMaxAnyOf = replCmd.Flags().Int("max_anyof_elements", pretty.DefaultOptions.MaxAnyOfElements, "number of any-of elements to print before eliding (0 for all)")
 }
 
 func mainCoreREPL() error {
@@ -56,6 +61,11 @@ func mainCoreREPL() error {
 
 	root := builtin.NewRootEnv()
 
+	printer := pretty.Options{
+		Width:            *replWidth,
+		MaxAnyOfElements: *replMaxAnyOf,
+	}
+
 	if *replScript != "" {
 		_, err = code.RunFile(root, *replScript)
 		if err != nil {
@@ -104,14 +114,16 @@ func mainCoreREPL() error {
 
 			for _, expression := range expressions {
 				if *verbose {
-					wr.Write([]byte(fmt.Sprintf("%s%s %v\n", color.MagentaString(verboseString("(read) ")), color.MagentaString("==>"), expression)))
+					prefix := verboseString("(read) ") + "==> "
+					wr.Write([]byte(fmt.Sprintf("%s %s\n", color.MagentaString(strings.TrimSpace(prefix)), printer.SprintFromColumn(expression.(types.Value), len(prefix)))))
 				}
 
 				evaled, err := expression.(types.Value).Eval(root)
 				if err != nil {
 					wr.Write([]byte(fmt.Sprintf("==! eval error: %v\n", err)))
 				} else {
-					wr.Write([]byte(fmt.Sprintf("%s%s %v\n", color.YellowString(verboseString("(eval) ")), color.YellowString("==>"), evaled)))
+					prefix := verboseString("(eval) ") + "==> "
+					wr.Write([]byte(fmt.Sprintf("%s %s\n", color.YellowString(strings.TrimSpace(prefix)), printer.SprintFromColumn(evaled, len(prefix)))))
 				}
 			}
 		}
@@ -7,11 +7,17 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/cobra"
 	"github.com/steinarvk/heisenlisp/builtin"
+	"github.com/steinarvk/heisenlisp/compile"
+	"github.com/steinarvk/heisenlisp/hashcons"
+	"github.com/steinarvk/heisenlisp/memo"
+	"github.com/steinarvk/heisenlisp/module"
+	"github.com/steinarvk/heisenlisp/parallel"
 	"github.com/steinarvk/heisenlisp/tracing"
 )
 
@@ -23,6 +29,11 @@ var (
 	listenAddress       *string
 	activateMetrics     *bool
 	keepAliveAfter      *bool
+	modulePath          *string
+	memoCapacity        *int
+	useVM               *bool
+	parallelism         *int
+	hashConsing         *bool
 )
 
 func init() {
@@ -33,6 +44,11 @@ func init() {
 	listenAddress = RootCmd.PersistentFlags().String("listen_address", "127.0.0.1:6860", "http address on which to serve metrics")
 	activateMetrics = RootCmd.PersistentFlags().Bool("metrics", true, "serve Prometheus metrics")
 	keepAliveAfter = RootCmd.PersistentFlags().Bool("keep_alive", false, "keep process alive after main command terminates (to serve metrics)")
+	modulePath = RootCmd.PersistentFlags().String("module_path", "", "list of directories (separated like PATH) to search for modules, in addition to the current directory")
+	memoCapacity = RootCmd.PersistentFlags().Int("memo_capacity", memo.DefaultCapacity, "default number of results remembered by each memoized function")
+	hashConsing = RootCmd.PersistentFlags().Bool("hashcons", false, "share structurally identical conses, small integers, strings and any-of values")
+	parallelism = RootCmd.PersistentFlags().Int("parallelism", 1, "number of goroutines evaluating possible worlds of pure computations in parallel")
+	useVM = RootCmd.PersistentFlags().Bool("vm", false, "compile functions to bytecode for the VM instead of to closures")
 }
 
 const (
@@ -71,6 +87,15 @@ var RootCmd = &cobra.Command{
 		builtin.Verbose = *verbose
 		tracing.Detailed = *calltracingDetailed
 
+		memo.DefaultCapacity = *memoCapacity
+		compile.Bytecode = *useVM
+		parallel.SetParallelism(*parallelism)
+		hashcons.Enabled = *hashConsing
+
+		if *modulePath != "" {
+			module.SearchPath = append(module.SearchPath, filepath.SplitList(*modulePath)...)
+		}
+
 		if *calltracingFilename != "" {
 			var w io.Writer
 
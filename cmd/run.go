@@ -7,6 +7,7 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/steinarvk/heisenlisp/builtin"
 	"github.com/steinarvk/heisenlisp/code"
+	"github.com/steinarvk/heisenlisp/pretty"
 )
 
 var runCmd = &cobra.Command{
@@ -20,7 +21,7 @@ var runCmd = &cobra.Command{
 		if err != nil {
 			log.Fatal(err)
 		}
-		fmt.Println(value)
+		fmt.Println(pretty.Sprint(value))
 	},
 }
 
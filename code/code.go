@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 
 	"github.com/steinarvk/heisenlisp/gen/parser"
+	"github.com/steinarvk/heisenlisp/pretty"
 	"github.com/steinarvk/heisenlisp/types"
 )
 
@@ -23,7 +24,7 @@ func Run(env types.Env, name string, code []byte) (types.Value, error) {
 
 		lastResult, err = val.Eval(env)
 		if err != nil {
-			return nil, fmt.Errorf("error evaluating %v: %v", val, err)
+			return nil, fmt.Errorf("error evaluating %s: %v", pretty.Sprint(val), err)
 		}
 	}
 
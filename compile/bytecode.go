@@ -0,0 +1,453 @@
+package compile
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/steinarvk/heisenlisp/env"
+	"github.com/steinarvk/heisenlisp/lambdalist"
+	"github.com/steinarvk/heisenlisp/macroexpand"
+	"github.com/steinarvk/heisenlisp/types"
+	"github.com/steinarvk/heisenlisp/value/cons"
+	"github.com/steinarvk/heisenlisp/value/function"
+	"github.com/steinarvk/heisenlisp/value/symbol"
+)
+
+var (
+	metricAssembledPrograms = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "hlisp",
+			Name:      "assembled_programs",
+			Help:      "Bytecode programs assembled",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(metricAssembledPrograms)
+}
+
+// Op is a bytecode operation.
+type Op uint8
+
+const (
+	// OpConst pushes constant A.
+	OpConst Op = iota
+	// OpLocal pushes the variable in slot B of the frame A levels up, with
+	// name C.
+	OpLocal
+	// OpGlobal pushes the value of global cell A, with name C.
+	OpGlobal
+	// OpLookup pushes the variable with name C (and symbol id B), looked up
+	// by name.
+	OpLookup
+	// OpDrop pops a value, whose exceptions are those of the result.
+	OpDrop
+	// OpCall pops a function and A arguments and pushes the result of
+	// calling it. C is the form, evaluated as such if the function turns
+	// out to be a special form or macro.
+	OpCall
+	// OpTailCall is OpCall in tail position: the program returns the
+	// result of the call.
+	OpTailCall
+	// OpJump jumps to A.
+	OpJump
+	// OpBranch pops a condition, jumping to A if it is false. If it may be
+	// either (or may throw), it is pushed back and the jump is to B.
+	OpBranch
+	// OpIfMaybe pops a condition that may be true or false, and pushes the
+	// outcomes of running both or either of the branches at A and B, each
+	// as far as C.
+	OpIfMaybe
+	// OpAnd starts an and, ending at A.
+	OpAnd
+	// OpOr starts an or, ending at A.
+	OpOr
+	// OpTest pops an argument of the current and (or or), jumping to its
+	// end if the argument decides it.
+	OpTest
+	// OpJoin ends the current and (or or), pushing its value.
+	OpJoin
+	// OpRun pushes the value of compiled code A.
+	OpRun
+	// OpClosure pushes function A, created in the current environment.
+	OpClosure
+	// OpEnter pops the values bound by binder A, and enters a new frame in
+	// which they are bound.
+	OpEnter
+	// OpLeave leaves the current frame.
+	OpLeave
+	// OpBindRoot binds the name C to the value on top of the stack, with
+	// BindRoot.
+	OpBindRoot
+	// OpImpure fails in a pure context.
+	OpImpure
+	// OpReturn pops and returns a value.
+	OpReturn
+)
+
+var opNames = map[Op]string{
+	OpConst:    "const",
+	OpLocal:    "local",
+	OpGlobal:   "global",
+	OpLookup:   "lookup",
+	OpDrop:     "drop",
+	OpCall:     "call",
+	OpTailCall: "tailcall",
+	OpJump:     "jump",
+	OpBranch:   "branch",
+	OpIfMaybe:  "ifmaybe",
+	OpAnd:      "and",
+	OpOr:       "or",
+	OpTest:     "test",
+	OpJoin:     "join",
+	OpRun:      "run",
+	OpClosure:  "closure",
+	OpEnter:    "enter",
+	OpLeave:    "leave",
+	OpBindRoot: "bindroot",
+	OpImpure:   "impure",
+	OpReturn:   "return",
+}
+
+func (o Op) String() string {
+	if name, ok := opNames[o]; ok {
+		return name
+	}
+	return fmt.Sprintf("op%d", uint8(o))
+}
+
+// Instr is a bytecode instruction.
+type Instr struct {
+	Op      Op
+	A, B, C int
+}
+
+// Program is a compiled function body, run by the bytecode VM.
+type Program struct {
+	name   string
+	instrs []Instr
+
+	constants []types.Value
+	cells     []*env.Cell
+	codes     []Code
+	lambdas   []*lambdaTemplate
+	binders   []*binder
+}
+
+// lambdaTemplate is a function compiled to bytecode, which is created in an
+// environment by OpClosure.
+type lambdaTemplate struct {
+	name    string
+	ll      *lambdalist.LambdaList
+	body    []types.Value
+	program *Program
+	code    Code
+}
+
+func (t *lambdaTemplate) create(e types.Env) (types.Value, error) {
+	return function.NewCompiled(e, t.name, t.ll, t.body, t.code, t.program), nil
+}
+
+// binder describes a frame entered by OpEnter.
+type binder struct {
+	ids     []uint32
+	nvalues int
+	bind    func(e, frame types.Env, values []types.Value) error
+}
+
+// Assemblable is implemented by special forms that can be compiled to
+// bytecode.
+type Assemblable interface {
+	types.SpecialForm
+
+	// Assemble compiles a use of the special form with the given
+	// (unevaluated) arguments, in tail position if tail is set.
+	Assemble(a *Assembler, args []types.Value, tail bool) error
+}
+
+// Assembler compiles expressions within a function body to bytecode.
+type Assembler struct {
+	c *Compiler
+	p *Program
+}
+
+func newAssembler(c *Compiler, name string) *Assembler {
+	metricAssembledPrograms.Inc()
+	return &Assembler{c: c, p: &Program{name: name}}
+}
+
+// Compiler returns the compiler for the scope of the assembled code.
+func (a *Assembler) Compiler() *Compiler { return a.c }
+
+func (a *Assembler) emit(op Op, operands ...int) int {
+	instr := Instr{Op: op}
+	for i, x := range operands {
+		switch i {
+		case 0:
+			instr.A = x
+		case 1:
+			instr.B = x
+		case 2:
+			instr.C = x
+		}
+	}
+	a.p.instrs = append(a.p.instrs, instr)
+	return len(a.p.instrs) - 1
+}
+
+// label returns the address of the next instruction.
+func (a *Assembler) label() int { return len(a.p.instrs) }
+
+func (a *Assembler) constant(v types.Value) int {
+	a.p.constants = append(a.p.constants, v)
+	return len(a.p.constants) - 1
+}
+
+// Constant assembles code that pushes v.
+func (a *Assembler) Constant(v types.Value) {
+	a.emit(OpConst, a.constant(v))
+}
+
+// Expr assembles an expression, in tail position if tail is set.
+func (a *Assembler) Expr(v types.Value, tail bool) error {
+	metricCompiledForms.Inc()
+
+	if symbol.Is(v) {
+		if symbol.IsKeyword(v) {
+			a.Constant(v)
+			return nil
+		}
+		a.variable(v, symbol.IdOrPanic(v))
+		return nil
+	}
+
+	if !cons.IsCons(v) {
+		a.Constant(v)
+		return nil
+	}
+
+	xs, err := cons.ToProperList(v)
+	if err != nil {
+		a.run(Interpreted(v))
+		return nil
+	}
+	head, args := xs[0], xs[1:]
+
+	if specialForm, ok := a.c.specialForm(head); ok {
+		return a.specialForm(specialForm, args, tail)
+	}
+
+	if err := a.Expr(head, false); err != nil {
+		return err
+	}
+	for _, arg := range args {
+		if err := a.Expr(arg, false); err != nil {
+			return err
+		}
+	}
+	op := OpCall
+	if tail {
+		op = OpTailCall
+	}
+	a.emit(op, len(args), 0, a.constant(v))
+	return nil
+}
+
+// Body assembles a non-empty sequence of expressions, evaluated like
+// expr.Progn.
+func (a *Assembler) Body(vs []types.Value, tail bool) error {
+	if len(vs) == 0 {
+		return errors.New("no body")
+	}
+	for i, v := range vs {
+		last := i == len(vs)-1
+		if err := a.Expr(v, tail && last); err != nil {
+			return err
+		}
+		if !last {
+			a.emit(OpDrop)
+		}
+	}
+	return nil
+}
+
+func (a *Assembler) variable(v types.Value, id uint32) {
+	name := a.constant(v)
+
+	if depth, slot, ok := a.c.resolve(id); ok {
+		a.emit(OpLocal, depth, slot, name)
+		return
+	}
+
+	if cell, ok := env.GlobalCell(a.c.env, id); ok {
+		a.p.cells = append(a.p.cells, cell)
+		a.emit(OpGlobal, len(a.p.cells)-1, 0, name)
+		return
+	}
+
+	a.emit(OpLookup, 0, int(id), name)
+}
+
+func (a *Assembler) run(code Code) {
+	a.p.codes = append(a.p.codes, code)
+	a.emit(OpRun, len(a.p.codes)-1)
+}
+
+func (a *Assembler) specialForm(specialForm types.SpecialForm, args []types.Value, tail bool) error {
+	if assemblable, ok := specialForm.(Assemblable); ok {
+		// As with Compile, a form that cannot be assembled is left to the
+		// closure compiler (and perhaps the interpreter). Anything emitted
+		// before the failure is discarded.
+		start := len(a.p.instrs)
+		if !specialForm.IsPure() {
+			a.emit(OpImpure)
+		}
+		if err := assemblable.Assemble(a, args, tail); err == nil {
+			return nil
+		}
+		a.p.instrs = a.p.instrs[:start]
+	}
+
+	code, err := a.c.compileSpecialForm(specialForm, args)
+	if err != nil {
+		return err
+	}
+	a.run(code)
+	return nil
+}
+
+// If assembles an if with the given condition and branches. If the
+// condition is maybe, both branches are run, as possible worlds.
+func (a *Assembler) If(condition, thenClause, elseClause types.Value, tail bool) error {
+	if err := a.Expr(condition, false); err != nil {
+		return err
+	}
+	branch := a.emit(OpBranch)
+
+	thenStart := a.label()
+	if err := a.Expr(thenClause, tail); err != nil {
+		return err
+	}
+	thenJump := a.emit(OpJump)
+
+	elseStart := a.label()
+	a.p.instrs[branch].A = elseStart
+	if err := a.Expr(elseClause, tail); err != nil {
+		return err
+	}
+	elseJump := a.emit(OpJump)
+
+	// The same code is run for each world, ending where the branches
+	// rejoin.
+	a.p.instrs[branch].B = a.label()
+	ifMaybe := a.emit(OpIfMaybe, thenStart, elseStart)
+
+	end := a.label()
+	a.p.instrs[thenJump].A = end
+	a.p.instrs[elseJump].A = end
+	a.p.instrs[ifMaybe].C = end
+	return nil
+}
+
+// And assembles an and of the given arguments.
+func (a *Assembler) And(args []types.Value) error {
+	return a.junction(OpAnd, args)
+}
+
+// Or assembles an or of the given arguments.
+func (a *Assembler) Or(args []types.Value) error {
+	return a.junction(OpOr, args)
+}
+
+func (a *Assembler) junction(op Op, args []types.Value) error {
+	start := a.emit(op)
+	for _, arg := range args {
+		if err := a.Expr(arg, false); err != nil {
+			return err
+		}
+		a.emit(OpTest)
+	}
+	a.emit(OpJoin)
+	a.p.instrs[start].A = a.label()
+	return nil
+}
+
+// Lambda assembles the creation of a function with the given parameters and
+// body.
+func (a *Assembler) Lambda(name string, formalParams types.Value, body []types.Value) error {
+	t, err := a.c.assembleLambda(name, formalParams, body)
+	if err != nil {
+		return err
+	}
+	a.p.lambdas = append(a.p.lambdas, t)
+	a.emit(OpClosure, len(a.p.lambdas)-1)
+	return nil
+}
+
+// Let assembles the evaluation of the given values, followed by the body in
+// a new frame binding the given names. The values are bound in the frame by
+// bind.
+func (a *Assembler) Let(ids []uint32, values []types.Value, bind func(e, frame types.Env, values []types.Value) error, body []types.Value, tail bool) error {
+	for _, v := range values {
+		if err := a.Expr(v, false); err != nil {
+			return err
+		}
+	}
+	a.p.binders = append(a.p.binders, &binder{ids: ids, nvalues: len(values), bind: bind})
+	a.emit(OpEnter, len(a.p.binders)-1)
+
+	inner := &Assembler{c: a.c.Binding(ids...), p: a.p}
+	if err := inner.Body(body, tail); err != nil {
+		return err
+	}
+	a.emit(OpLeave)
+	return nil
+}
+
+// BindRoot assembles code binding a name with BindRoot to the value on top
+// of the stack (which is left there).
+func (a *Assembler) BindRoot(name types.Value) {
+	a.emit(OpBindRoot, 0, 0, a.constant(name))
+}
+
+func (c *Compiler) assembleLambda(name string, formalParams types.Value, body []types.Value) (*lambdaTemplate, error) {
+	ll, err := lambdalist.Parse(formalParams)
+	if err != nil {
+		return nil, fmt.Errorf("invalid lambda list: %v", err)
+	}
+
+	a := newAssembler(c.Binding(ll.Variables()...), name)
+	if err := a.Body(body, true); err != nil {
+		return nil, err
+	}
+	a.emit(OpReturn)
+
+	program := a.p
+	return &lambdaTemplate{
+		name:    name,
+		ll:      ll,
+		body:    body,
+		program: program,
+		code: func(e types.Env) (types.Value, error) {
+			return run(program, e, 0, -1)
+		},
+	}, nil
+}
+
+// Assemble compiles a top-level form to bytecode, as the body of a function
+// without parameters defined in e.
+func Assemble(e types.Env, v types.Value) (*Program, error) {
+	expanded, err := macroexpand.Macroexpand(e, v)
+	if err != nil {
+		return nil, fmt.Errorf("error macroexpanding: %v", err)
+	}
+
+	a := newAssembler(New(e), "toplevel")
+	if err := a.Expr(expanded, true); err != nil {
+		return nil, err
+	}
+	a.emit(OpReturn)
+	return a.p, nil
+}
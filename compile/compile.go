@@ -0,0 +1,393 @@
+// Package compile turns expressions into trees of Go closures, so that
+// function bodies are not re-interpreted on every call.
+//
+// Compilation happens when a function is created, after its body has been
+// macroexpanded. Special forms are resolved at that point, in the
+// environment in which the function is defined (just as macros are), unless
+// the name is bound locally by the function's parameters or a let. Special
+// forms implementing Compilable are compiled; others are executed as usual,
+// with their arguments uncompiled. Heads of calls that are not special forms
+// are looked up at every call, so that redefining a function (e.g. with
+// set!) takes effect.
+//
+// Variables are resolved lexically. A variable bound by the parameters of a
+// function or by a let is found by its address (depth, slot) in the frames
+// of the enclosing scopes. A global variable is found through the cell
+// holding its binding, if the function is defined in a global environment,
+// and otherwise by looking it up by name.
+//
+// Optionally (see Bytecode), function bodies are instead compiled to
+// programs for a stack-based virtual machine, with opcodes for the forms
+// that determine control flow: calls (including tail calls, which do not
+// grow the stack), jumps, and the branches of if, and, and or, whose
+// condition may be true, false or maybe. Special forms implementing
+// Assemblable are compiled to bytecode; others are compiled to closures as
+// usual, and run by the VM. An error is reported in the context of the
+// function that was called and of the last one entered by a tail call, but
+// not of those in between, whose frames the tail calls replaced.
+package compile
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/steinarvk/heisenlisp/env"
+	"github.com/steinarvk/heisenlisp/lambdalist"
+	"github.com/steinarvk/heisenlisp/lisperr"
+	"github.com/steinarvk/heisenlisp/macroexpand"
+	"github.com/steinarvk/heisenlisp/types"
+	"github.com/steinarvk/heisenlisp/value/cons"
+	"github.com/steinarvk/heisenlisp/value/function"
+	"github.com/steinarvk/heisenlisp/value/symbol"
+	"github.com/steinarvk/heisenlisp/value/unknowns/maythrow"
+)
+
+var (
+	metricCompiledForms = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "hlisp",
+			Name:      "compiled_forms",
+			Help:      "Forms compiled to closures",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(metricCompiledForms)
+}
+
+// Bytecode selects the bytecode VM, rather than closures, for function
+// bodies compiled from now on.
+var Bytecode = false
+
+// Code is a compiled expression, evaluated in an environment.
+type Code = function.Code
+
+// Compilable is implemented by special forms that can be compiled.
+type Compilable interface {
+	types.SpecialForm
+
+	// Compile compiles a use of the special form with the given (unevaluated)
+	// arguments.
+	Compile(c *Compiler, args []types.Value) (Code, error)
+}
+
+// Compiler compiles expressions within a function body.
+type Compiler struct {
+	// env is the environment in which the function is defined, used to
+	// resolve special forms and global variables.
+	env types.Env
+
+	// scope holds the names bound within the function so far, which may
+	// shadow special forms.
+	scope *scope
+}
+
+// scope describes the frame created for the names bound by a function's
+// parameters or a let, nested within the frames of the enclosing scopes.
+type scope struct {
+	ids    []uint32
+	parent *scope
+}
+
+func New(e types.Env) *Compiler {
+	return &Compiler{env: e}
+}
+
+// Env returns the environment in which the compiled code is defined.
+func (c *Compiler) Env() types.Env { return c.env }
+
+// Binding returns a compiler for code in the scope of the given names. Such
+// code must be run in a frame created by env.NewFrame with the same names.
+func (c *Compiler) Binding(ids ...uint32) *Compiler {
+	return &Compiler{env: c.env, scope: &scope{ids: ids, parent: c.scope}}
+}
+
+// resolve returns the lexical address of a name bound within the function:
+// the number of frames to go up, and the slot within that frame.
+func (c *Compiler) resolve(id uint32) (int, int, bool) {
+	depth := 0
+	for s := c.scope; s != nil; s = s.parent {
+		for slot, x := range s.ids {
+			if x == id {
+				return depth, slot, true
+			}
+		}
+		depth++
+	}
+	return 0, 0, false
+}
+
+// variable compiles a reference to a variable.
+func (c *Compiler) variable(v types.Value, id uint32) Code {
+	lookup := func(e types.Env) (types.Value, error) {
+		val, ok := e.Lookup(id)
+		if !ok {
+			return nil, lisperr.UnboundVariable(v.String())
+		}
+		return val, nil
+	}
+
+	if depth, slot, ok := c.resolve(id); ok {
+		return func(e types.Env) (types.Value, error) {
+			frame := e.(*env.Frame)
+			for i := 0; i < depth; i++ {
+				frame = frame.Parent().(*env.Frame)
+			}
+			if val := frame.Slot(slot); val != nil {
+				return val, nil
+			}
+			return lookup(e)
+		}
+	}
+
+	if cell, ok := env.GlobalCell(c.env, id); ok {
+		return func(e types.Env) (types.Value, error) {
+			if val, ok := cell.Value(); ok {
+				return val, nil
+			}
+			// Not (yet) a global definition, but possibly a builtin.
+			return lookup(e)
+		}
+	}
+
+	return lookup
+}
+
+// Constant returns code that evaluates to v.
+func Constant(v types.Value) Code {
+	return func(types.Env) (types.Value, error) { return v, nil }
+}
+
+// Interpreted returns code that evaluates v without compiling it.
+func Interpreted(v types.Value) Code {
+	return v.Eval
+}
+
+// InterpretedAll returns code that evaluates each of vs without compiling
+// them.
+func InterpretedAll(vs []types.Value) []Code {
+	rv := make([]Code, len(vs))
+	for i, v := range vs {
+		rv[i] = Interpreted(v)
+	}
+	return rv
+}
+
+// Compile compiles an expression.
+func (c *Compiler) Compile(v types.Value) (Code, error) {
+	metricCompiledForms.Inc()
+
+	if symbol.Is(v) {
+		if symbol.IsKeyword(v) {
+			return Constant(v), nil
+		}
+		return c.variable(v, symbol.IdOrPanic(v)), nil
+	}
+
+	if !cons.IsCons(v) {
+		return Constant(v), nil
+	}
+
+	xs, err := cons.ToProperList(v)
+	if err != nil {
+		return Interpreted(v), nil
+	}
+	head, args := xs[0], xs[1:]
+
+	if specialForm, ok := c.specialForm(head); ok {
+		return c.compileSpecialForm(specialForm, args)
+	}
+
+	return c.compileCall(v, head, args)
+}
+
+// specialForm returns the special form named by the head of a form, unless
+// the name is bound within the function. (In the expansion of a hygienic
+// macro, the head may be the special form itself.)
+func (c *Compiler) specialForm(head types.Value) (types.SpecialForm, bool) {
+	if specialForm, ok := head.(types.SpecialForm); ok {
+		return specialForm, true
+	}
+	id, err := symbol.Id(head)
+	if err != nil {
+		return nil, false
+	}
+	if _, _, local := c.resolve(id); local {
+		return nil, false
+	}
+	headVal, ok := c.env.Lookup(id)
+	if !ok {
+		return nil, false
+	}
+	specialForm, ok := headVal.(types.SpecialForm)
+	return specialForm, ok
+}
+
+// CompileAll compiles each of a list of expressions.
+func (c *Compiler) CompileAll(vs []types.Value) ([]Code, error) {
+	var rv []Code
+	for _, v := range vs {
+		code, err := c.Compile(v)
+		if err != nil {
+			return nil, err
+		}
+		rv = append(rv, code)
+	}
+	return rv, nil
+}
+
+// CompileBody compiles a non-empty sequence of expressions, evaluated like
+// expr.Progn.
+func (c *Compiler) CompileBody(vs []types.Value) (Code, error) {
+	if len(vs) == 0 {
+		return nil, errors.New("no body")
+	}
+	codes, err := c.CompileAll(vs)
+	if err != nil {
+		return nil, err
+	}
+	if len(codes) == 1 {
+		return codes[0], nil
+	}
+	return func(e types.Env) (types.Value, error) {
+		return Progn(e, codes)
+	}, nil
+}
+
+// Progn runs compiled code in sequence, like expr.Progn.
+func Progn(e types.Env, codes []Code) (types.Value, error) {
+	var exceptions []error
+	var result types.Value
+	for _, code := range codes {
+		val, err := code(e)
+		if err != nil {
+			return nil, err
+		}
+		var valExceptions []error
+		result, valExceptions = maythrow.Split(val)
+		exceptions = append(exceptions, valExceptions...)
+	}
+	return maythrow.New(result, exceptions), nil
+}
+
+func (c *Compiler) compileSpecialForm(specialForm types.SpecialForm, args []types.Value) (Code, error) {
+	pure := specialForm.IsPure()
+
+	var code Code
+	if compilable, ok := specialForm.(Compilable); ok {
+		// A form that cannot be compiled (e.g. because it is malformed) is
+		// left to the interpreter, so that any error happens only if it
+		// is evaluated.
+		code, _ = compilable.Compile(c, args)
+	}
+	if code == nil {
+		code = func(e types.Env) (types.Value, error) {
+			return specialForm.Execute(e, args)
+		}
+	}
+
+	if pure {
+		return code, nil
+	}
+	return func(e types.Env) (types.Value, error) {
+		if e.IsInPureContext() {
+			return nil, errors.New("impure call in pure context")
+		}
+		return code(e)
+	}, nil
+}
+
+func (c *Compiler) compileCall(form, head types.Value, args []types.Value) (Code, error) {
+	headCode, err := c.Compile(head)
+	if err != nil {
+		return nil, err
+	}
+
+	argCodes, err := c.CompileAll(args)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(e types.Env) (types.Value, error) {
+		funcVal, err := headCode(e)
+		if err != nil {
+			return nil, err
+		}
+
+		callable, ok := funcVal.(types.Callable)
+		if !ok {
+			// Special forms and macros that were not known at compile
+			// time (e.g. bound to a local variable) are handled by the
+			// interpreter.
+			if _, ok := funcVal.(types.SpecialForm); ok {
+				return form.Eval(e)
+			}
+			if _, ok := funcVal.(types.Macro); ok {
+				return form.Eval(e)
+			}
+			return nil, fmt.Errorf("%q (%v) is not callable", head, funcVal)
+		}
+
+		if !callable.IsPure() && e.IsInPureContext() {
+			return nil, errors.New("impure call in pure context")
+		}
+
+		var exceptions []error
+		params := make([]types.Value, len(argCodes))
+		for i, argCode := range argCodes {
+			evaled, err := argCode(e)
+			if err != nil {
+				return nil, err
+			}
+			var paramExceptions []error
+			params[i], paramExceptions = maythrow.Split(evaled)
+			exceptions = append(exceptions, paramExceptions...)
+		}
+
+		return cons.Call(callable, params, exceptions)
+	}, nil
+}
+
+// Lambda compiles a function with the given parameters and body; the
+// returned function creates it in an environment. If Bytecode is set, the
+// body is compiled to bytecode.
+func (c *Compiler) Lambda(name string, formalParams types.Value, body []types.Value) (func(types.Env) (types.Value, error), error) {
+	if Bytecode {
+		t, err := c.assembleLambda(name, formalParams, body)
+		if err != nil {
+			return nil, err
+		}
+		return t.create, nil
+	}
+
+	ll, err := lambdalist.Parse(formalParams)
+	if err != nil {
+		return nil, fmt.Errorf("invalid lambda list: %v", err)
+	}
+
+	code, err := c.Binding(ll.Variables()...).CompileBody(body)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(e types.Env) (types.Value, error) {
+		return function.NewCompiled(e, name, ll, body, code, nil), nil
+	}, nil
+}
+
+// Function creates a function, macroexpanding and compiling its body.
+func Function(e types.Env, name string, formalParams types.Value, body []types.Value) (types.Value, error) {
+	expandedBody, err := macroexpand.MacroexpandMultiple(e, body)
+	if err != nil {
+		return nil, fmt.Errorf("error macroexpanding function body: %v", err)
+	}
+
+	makeFunction, err := New(e).Lambda(name, formalParams, expandedBody)
+	if err != nil {
+		return nil, err
+	}
+
+	return makeFunction(e)
+}
@@ -0,0 +1,81 @@
+package compile
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/steinarvk/heisenlisp/value/symbol"
+)
+
+// Disassemble writes a readable listing of a program, followed by those of
+// the functions within it.
+func Disassemble(w io.Writer, p *Program) error {
+	name := p.name
+	if name == "" {
+		name = "(anonymous)"
+	}
+	return disassemble(w, p, name)
+}
+
+func disassemble(w io.Writer, p *Program, title string) error {
+	if _, err := fmt.Fprintf(w, "%s:\n", title); err != nil {
+		return err
+	}
+
+	for pc, instr := range p.instrs {
+		line := fmt.Sprintf("  %4d  %-9s %s", pc, instr.Op, p.operands(instr))
+		if _, err := fmt.Fprintln(w, strings.TrimRight(line, " ")); err != nil {
+			return err
+		}
+	}
+
+	for i, t := range p.lambdas {
+		if _, err := fmt.Fprintln(w); err != nil {
+			return err
+		}
+		name := t.name
+		if name == "" {
+			name = fmt.Sprintf("lambda %d", i)
+		}
+		if err := disassemble(w, t.program, fmt.Sprintf("%s / %s", title, name)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *Program) operands(instr Instr) string {
+	switch instr.Op {
+	case OpConst:
+		return p.constants[instr.A].String()
+	case OpLocal:
+		return fmt.Sprintf("%d %d  ; %v", instr.A, instr.B, p.constants[instr.C])
+	case OpGlobal, OpLookup, OpBindRoot:
+		return p.constants[instr.C].String()
+	case OpCall, OpTailCall:
+		return fmt.Sprintf("%d", instr.A)
+	case OpJump, OpAnd, OpOr:
+		return fmt.Sprintf("-> %d", instr.A)
+	case OpBranch:
+		return fmt.Sprintf("-> %d, maybe -> %d", instr.A, instr.B)
+	case OpIfMaybe:
+		return fmt.Sprintf("%d, %d -> %d", instr.A, instr.B, instr.C)
+	case OpRun:
+		return fmt.Sprintf("%d", instr.A)
+	case OpClosure:
+		t := p.lambdas[instr.A]
+		if t.name == "" {
+			return fmt.Sprintf("lambda %d", instr.A)
+		}
+		return t.name
+	case OpEnter:
+		var names []string
+		for _, id := range p.binders[instr.A].ids {
+			names = append(names, symbol.FromId(id).String())
+		}
+		return strings.Join(names, " ")
+	}
+	return ""
+}
@@ -0,0 +1,388 @@
+package compile
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/steinarvk/heisenlisp/env"
+	"github.com/steinarvk/heisenlisp/lisperr"
+	"github.com/steinarvk/heisenlisp/outcome"
+	"github.com/steinarvk/heisenlisp/parallel"
+	"github.com/steinarvk/heisenlisp/tracing"
+	"github.com/steinarvk/heisenlisp/types"
+	"github.com/steinarvk/heisenlisp/unknown"
+	"github.com/steinarvk/heisenlisp/value/boolean"
+	"github.com/steinarvk/heisenlisp/value/cons"
+	"github.com/steinarvk/heisenlisp/value/function"
+	"github.com/steinarvk/heisenlisp/value/symbol"
+	"github.com/steinarvk/heisenlisp/value/unknowns/maythrow"
+)
+
+var (
+	metricTailCalls = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "hlisp",
+			Name:      "vm_tail_calls",
+			Help:      "Tail calls made by the bytecode VM without growing the stack",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(metricTailCalls)
+}
+
+// junction is an and (or or) being evaluated.
+type junction struct {
+	or bool
+	// maybe is set once an argument was maybe true (or false), so that the
+	// result is maybe unless it is decided by a later argument.
+	maybe      bool
+	exceptions []error
+
+	// env and depth are the environment and stack depth at the start, and
+	// end the address after it.
+	env   types.Env
+	depth int
+	end   int
+}
+
+// decided returns the value of a junction decided by one of its arguments.
+func (j *junction) decided() types.Value {
+	return maythrow.New(boolean.FromBool(j.or), j.exceptions)
+}
+
+// run runs a program in an environment, from pc until it returns or (if
+// stop is not negative) reaches stop.
+func run(p *Program, e types.Env, pc, stop int) (types.Value, error) {
+	stack := make([]types.Value, 0, 8)
+	var junctions []*junction
+	var exceptions []error
+
+	push := func(v types.Value) { stack = append(stack, v) }
+	pop := func() types.Value {
+		v := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return v
+	}
+
+	// context is the error context of the function last entered by a tail
+	// call, which errors are wrapped with as when calling it. (Those of the
+	// functions it replaced on the stack are lost.)
+	var context string
+
+	for {
+		if pc == stop {
+			return maythrow.New(pop(), exceptions), nil
+		}
+		instr := p.instrs[pc]
+		pc++
+
+		var err error
+
+		switch instr.Op {
+		case OpConst:
+			push(p.constants[instr.A])
+
+		case OpLocal:
+			frame := e.(*env.Frame)
+			for i := 0; i < instr.A; i++ {
+				frame = frame.Parent().(*env.Frame)
+			}
+			if val := frame.Slot(instr.B); val != nil {
+				push(val)
+				break
+			}
+			var val types.Value
+			val, err = lookup(e, p.constants[instr.C])
+			if err == nil {
+				push(val)
+			}
+
+		case OpGlobal:
+			if val, ok := p.cells[instr.A].Value(); ok {
+				push(val)
+				break
+			}
+			// Not (yet) a global definition, but possibly a builtin.
+			var val types.Value
+			val, err = lookup(e, p.constants[instr.C])
+			if err == nil {
+				push(val)
+			}
+
+		case OpLookup:
+			if val, ok := e.Lookup(uint32(instr.B)); ok {
+				push(val)
+				break
+			}
+			err = lisperr.UnboundVariable(p.constants[instr.C].String())
+
+		case OpDrop:
+			_, valExceptions := maythrow.Split(pop())
+			exceptions = append(exceptions, valExceptions...)
+
+		case OpCall, OpTailCall:
+			params := make([]types.Value, instr.A)
+			copy(params, stack[len(stack)-instr.A:])
+			stack = stack[:len(stack)-instr.A]
+			funcVal := pop()
+			form := p.constants[instr.C]
+
+			callable, ok := funcVal.(types.Callable)
+			if !ok {
+				var val types.Value
+				val, err = callNoncallable(e, form, funcVal)
+				if err == nil {
+					push(val)
+				}
+				break
+			}
+
+			if !callable.IsPure() && e.IsInPureContext() {
+				err = errors.New("impure call in pure context")
+				break
+			}
+
+			var paramExceptions []error
+			for i, param := range params {
+				var more []error
+				params[i], more = maythrow.Split(param)
+				paramExceptions = append(paramExceptions, more...)
+			}
+
+			if instr.Op == OpTailCall && !tracing.Enabled {
+				var calleeEnv types.Env
+				var program interface{}
+				calleeEnv, program, err = function.Enter(callable, params)
+				if err != nil {
+					break
+				}
+				if program != nil {
+					// The exceptions of the arguments are those of the
+					// result, as with cons.Call.
+					metricTailCalls.Inc()
+					exceptions = append(exceptions, paramExceptions...)
+					p, e, pc, stop = program.(*Program), calleeEnv, 0, -1
+					stack = stack[:0]
+					context = function.ErrorContext(callable)
+					break
+				}
+			}
+
+			var val types.Value
+			val, err = cons.Call(callable, params, paramExceptions)
+			if err == nil {
+				push(val)
+			}
+
+		case OpJump:
+			pc = instr.A
+
+		case OpBranch:
+			cond := pop()
+			if maythrow.Is(cond) {
+				push(cond)
+				pc = instr.B
+				break
+			}
+			var tv types.TernaryTruthValue
+			tv, err = unknown.TruthValue(cond)
+			if err != nil {
+				break
+			}
+			switch tv {
+			case types.True:
+			case types.False:
+				pc = instr.A
+			default:
+				push(cond)
+				pc = instr.B
+			}
+
+		case OpIfMaybe:
+			var val types.Value
+			val, err = ifMaybe(p, e, pop(), instr.A, instr.B, instr.C)
+			if err == nil {
+				push(val)
+			}
+
+		case OpAnd, OpOr:
+			junctions = append(junctions, &junction{
+				or:    instr.Op == OpOr,
+				env:   e,
+				depth: len(stack),
+				end:   instr.A,
+			})
+
+		case OpTest:
+			j := junctions[len(junctions)-1]
+			val, valExceptions := maythrow.Split(pop())
+			j.exceptions = append(j.exceptions, valExceptions...)
+
+			var tv types.TernaryTruthValue
+			tv, err = unknown.TruthValue(val)
+			if err != nil {
+				break
+			}
+			if tv == types.Maybe {
+				j.maybe = true
+			} else if (tv == types.True) == j.or {
+				junctions = junctions[:len(junctions)-1]
+				push(j.decided())
+				pc = j.end
+			}
+
+		case OpJoin:
+			j := junctions[len(junctions)-1]
+			junctions = junctions[:len(junctions)-1]
+			var val types.Value = boolean.FromBool(!j.or)
+			if j.maybe {
+				val = unknown.MaybeValue
+			}
+			push(maythrow.New(val, j.exceptions))
+
+		case OpRun:
+			var val types.Value
+			val, err = p.codes[instr.A](e)
+			if err == nil {
+				push(val)
+			}
+
+		case OpClosure:
+			var val types.Value
+			val, err = p.lambdas[instr.A].create(e)
+			if err == nil {
+				push(val)
+			}
+
+		case OpEnter:
+			b := p.binders[instr.A]
+			values := make([]types.Value, b.nvalues)
+			copy(values, stack[len(stack)-b.nvalues:])
+			stack = stack[:len(stack)-b.nvalues]
+
+			frame := env.NewFrame(e, b.ids)
+			err = b.bind(e, frame, values)
+			if err == nil {
+				e = frame
+			}
+
+		case OpLeave:
+			e = e.(*env.Frame).Parent()
+
+		case OpBindRoot:
+			e.BindRoot(symbol.IdOrPanic(p.constants[instr.C]), stack[len(stack)-1])
+
+		case OpImpure:
+			if e.IsInPureContext() {
+				err = errors.New("impure call in pure context")
+			}
+
+		case OpReturn:
+			return maythrow.New(pop(), exceptions), nil
+
+		default:
+			return nil, fmt.Errorf("invalid bytecode operation %v", instr.Op)
+		}
+
+		if err == nil {
+			continue
+		}
+
+		// If an and (or or) may already have been decided by an earlier
+		// argument, an exception is only one of its possible outcomes.
+		for err != nil && len(junctions) > 0 {
+			j := junctions[len(junctions)-1]
+			junctions = junctions[:len(junctions)-1]
+			if j.maybe && outcome.IsCatchable(err) {
+				j.exceptions = append(j.exceptions, err)
+				stack = stack[:j.depth]
+				e = j.env
+				push(j.decided())
+				pc = j.end
+				err = nil
+			}
+		}
+		if err != nil {
+			if context != "" {
+				err = lisperr.Wrap(context, err)
+			}
+			return nil, err
+		}
+	}
+}
+
+func lookup(e types.Env, name types.Value) (types.Value, error) {
+	val, ok := e.Lookup(symbol.IdOrPanic(name))
+	if !ok {
+		return nil, lisperr.UnboundVariable(name.String())
+	}
+	return val, nil
+}
+
+// callNoncallable handles a call of a value that is not callable. Special
+// forms and macros that were not known at compile time (e.g. bound to a
+// local variable) are handled by the interpreter.
+func callNoncallable(e types.Env, form, funcVal types.Value) (types.Value, error) {
+	if _, ok := funcVal.(types.SpecialForm); ok {
+		return form.Eval(e)
+	}
+	if _, ok := funcVal.(types.Macro); ok {
+		return form.Eval(e)
+	}
+	head, _, _ := cons.Decompose(form)
+	return nil, fmt.Errorf("%q (%v) is not callable", head, funcVal)
+}
+
+// ifMaybe runs the branches of an if whose condition may be either true or
+// false, or may throw, as possible worlds. The branches start at thenStart
+// and elseStart, and end at end.
+func ifMaybe(p *Program, e types.Env, cond types.Value, thenStart, elseStart, end int) (types.Value, error) {
+	cond, exceptions := maythrow.Split(cond)
+
+	tv, err := unknown.TruthValue(cond)
+	if err != nil {
+		return nil, err
+	}
+
+	var starts []int
+	switch tv {
+	case types.Maybe:
+		starts = []int{thenStart, elseStart}
+	case types.True:
+		starts = []int{thenStart}
+	case types.False:
+		starts = []int{elseStart}
+	}
+
+	// As with the interpreter, the branches are evaluated in parallel in a
+	// pure context.
+	results := make([]types.Value, len(starts))
+	errs := make([]error, len(starts))
+	evaluated := e.IsInPureContext()
+	if evaluated {
+		parallel.Run(len(starts), func(i int) {
+			results[i], errs[i] = run(p, e, starts[i], end)
+		})
+	}
+
+	var values []types.Value
+	for i, start := range starts {
+		val, err := results[i], errs[i]
+		if !evaluated {
+			val, err = run(p, e, start, end)
+		}
+		if err != nil {
+			if !outcome.IsCatchable(err) {
+				return nil, err
+			}
+			exceptions = append(exceptions, maythrow.Exceptions(err)...)
+			continue
+		}
+		values = append(values, val)
+	}
+
+	return outcome.Combine(values, exceptions)
+}
@@ -36,7 +36,16 @@ type env struct {
 
 	bindings map[uint32]types.Value
 
+	// cells holds the bindings of a global environment (the root, or the
+	// top-level environment of a module), so that compiled code can refer
+	// to them directly.
+	cells map[uint32]*Cell
+
 	pureContext bool
+
+	// moduleRoot is set for the top-level environment of a module, which
+	// receives its global definitions instead of the real root.
+	moduleRoot bool
 }
 
 func New(parent types.Env) types.Env {
@@ -50,6 +59,55 @@ func New(parent types.Env) types.Env {
 	return rv
 }
 
+// NewModule creates the top-level environment of a module. Definitions made
+// with BindRoot in the module end up here, not in parent.
+func NewModule(parent types.Env) types.Env {
+	rv := New(parent).(*env)
+	rv.moduleRoot = true
+	return rv
+}
+
+// Cell holds the value of a global binding. Rebinding the name (e.g. with
+// set! or defun!) updates the cell.
+type Cell struct {
+	value types.Value
+}
+
+// Value returns the value in the cell, if the name is bound.
+func (c *Cell) Value() (types.Value, bool) {
+	if c == nil {
+		return nil, false
+	}
+	return c.value, c.value != nil
+}
+
+// GlobalCell returns the cell for a name in a global environment: the root,
+// or the top-level environment of a module. The cell is created unbound if
+// the name is not bound (yet). Other environments have no cells.
+func GlobalCell(e types.Env, k uint32) (*Cell, bool) {
+	ge, ok := e.(*env)
+	if !ok || !ge.isGlobal() {
+		return nil, false
+	}
+	return ge.cell(k), true
+}
+
+func (e *env) isGlobal() bool {
+	return e.parent == nil || e.moduleRoot
+}
+
+func (e *env) cell(k uint32) *Cell {
+	if e.cells == nil {
+		e.cells = map[uint32]*Cell{}
+	}
+	c, ok := e.cells[k]
+	if !ok {
+		c = &Cell{}
+		e.cells[k] = c
+	}
+	return c
+}
+
 func (e *env) MarkPure() {
 	e.pureContext = true
 }
@@ -60,6 +118,10 @@ func (e *env) IsInPureContext() bool {
 
 func (e *env) Bind(k uint32, v types.Value) {
 	metricEnvValueBinds.Inc()
+	if e.isGlobal() {
+		e.cell(k).value = v
+		return
+	}
 	if e.bindings == nil {
 		if e.singleBinding == 0 {
 			e.singleBinding = k
@@ -77,7 +139,7 @@ func (e *env) Bind(k uint32, v types.Value) {
 }
 
 func (e *env) BindRoot(k uint32, v types.Value) {
-	if e.parent == nil {
+	if e.parent == nil || e.moduleRoot {
 		e.Bind(k, v)
 		return
 	}
@@ -85,6 +147,11 @@ func (e *env) BindRoot(k uint32, v types.Value) {
 }
 
 func (e *env) Lookup(k uint32) (types.Value, bool) {
+	if e.cells != nil {
+		if rv, ok := e.cells[k].Value(); ok {
+			return rv, true
+		}
+	}
 	if e.singleBinding == k {
 		return e.singleBindingValue, true
 	}
@@ -0,0 +1,88 @@
+package env
+
+import (
+	"github.com/steinarvk/heisenlisp/types"
+)
+
+// Frame is an environment holding the variables bound by a function call or
+// a let, in slots known in advance. Compiled code refers to a variable by
+// the number of frames to go up and its slot in that frame, rather than
+// looking it up by name.
+type Frame struct {
+	parent types.Env
+	ids    []uint32
+	values []types.Value
+
+	// more holds any names bound that do not have a slot.
+	more map[uint32]types.Value
+
+	pureContext bool
+}
+
+var _ types.Env = &Frame{}
+
+// NewFrame creates a frame with a slot for each of the given names, which
+// are initially unbound.
+func NewFrame(parent types.Env, ids []uint32) *Frame {
+	metricNewEnvironments.Inc()
+	rv := &Frame{
+		parent: parent,
+		ids:    ids,
+		values: make([]types.Value, len(ids)),
+	}
+	if parent != nil && parent.IsInPureContext() {
+		rv.pureContext = true
+	}
+	return rv
+}
+
+// Parent returns the environment enclosing the frame.
+func (f *Frame) Parent() types.Env { return f.parent }
+
+// Slot returns the value in a slot, or nil if it is not bound.
+func (f *Frame) Slot(i int) types.Value { return f.values[i] }
+
+func (f *Frame) MarkPure() {
+	f.pureContext = true
+}
+
+func (f *Frame) IsInPureContext() bool {
+	return f.pureContext
+}
+
+func (f *Frame) Bind(k uint32, v types.Value) {
+	metricEnvValueBinds.Inc()
+	for i, id := range f.ids {
+		if id == k {
+			f.values[i] = v
+			return
+		}
+	}
+	if f.more == nil {
+		f.more = map[uint32]types.Value{}
+	}
+	f.more[k] = v
+}
+
+func (f *Frame) BindRoot(k uint32, v types.Value) {
+	if f.parent == nil {
+		f.Bind(k, v)
+		return
+	}
+	f.parent.BindRoot(k, v)
+}
+
+func (f *Frame) Lookup(k uint32) (types.Value, bool) {
+	for i, id := range f.ids {
+		if id == k && f.values[i] != nil {
+			return f.values[i], true
+		}
+	}
+	if rv, ok := f.more[k]; ok {
+		return rv, true
+	}
+	if f.parent == nil {
+		return nil, false
+	}
+	return f.parent.Lookup(k)
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/steinarvk/heisenlisp/unknown/intersection"
 	"github.com/steinarvk/heisenlisp/value/cons"
 	"github.com/steinarvk/heisenlisp/value/real"
+	"github.com/steinarvk/heisenlisp/value/record"
 )
 
 func AtomEquals(a, b types.Value) bool {
@@ -43,6 +44,10 @@ func Equals(a, b types.Value) (types.TernaryTruthValue, error) {
 	}
 
 	if cons.IsCons(a) && cons.IsCons(b) {
+		if cons.Same(a, b) {
+			return types.True, nil
+		}
+
 		acar, acdr, _ := cons.Decompose(a)
 		bcar, bcdr, _ := cons.Decompose(b)
 
@@ -63,6 +68,27 @@ func Equals(a, b types.Value) (types.TernaryTruthValue, error) {
 		return ternaryAnd(tv1, tv2), nil
 	}
 
+	aType, aValues, aIsRecord := record.Decompose(a)
+	bType, bValues, bIsRecord := record.Decompose(b)
+	if aIsRecord && bIsRecord {
+		if aType != bType {
+			return types.False, nil
+		}
+
+		rv := types.True
+		for i := range aValues {
+			tv, err := Equals(aValues[i], bValues[i])
+			if err != nil {
+				return types.InvalidTernary, err
+			}
+			rv = ternaryAnd(rv, tv)
+			if rv == types.False {
+				break
+			}
+		}
+		return rv, nil
+	}
+
 	_, aIsUnk := a.(types.Unknown)
 	_, bIsUnk := b.(types.Unknown)
 	if aIsUnk || bIsUnk {
@@ -3,6 +3,7 @@ package expr
 import (
 	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/steinarvk/heisenlisp/types"
 	"github.com/steinarvk/heisenlisp/value/cons"
@@ -10,6 +11,7 @@ import (
 	"github.com/steinarvk/heisenlisp/value/null"
 	"github.com/steinarvk/heisenlisp/value/str"
 	"github.com/steinarvk/heisenlisp/value/symbol"
+	"github.com/steinarvk/heisenlisp/value/unknowns/maythrow"
 )
 
 func IsNil(v types.Value) bool {
@@ -44,22 +46,78 @@ func IsWrappedInUnary(name string, v types.Value) (types.Value, bool) {
 	return secondCar, got == name
 }
 
+// ExpandQuasiQuote expands a quasiquote template. Symbols in the template
+// (outside of unquoted expressions) whose names end with
+// symbol.AutoGensymSuffix are replaced by fresh uninterned symbols, the same
+// one for each occurrence of a name within the template. Macros can use this
+// for bindings that must not collide with the user's variables. Templates in
+// the body of a macro are also hygienic (see MarkMacroExpansion).
 func ExpandQuasiQuote(e types.Env, mc types.Value) (types.Value, error) {
+	t := &template{
+		env:      e,
+		hygienic: isMacroExpansion(e),
+		gensyms:  map[string]types.Value{},
+	}
+	return t.expand(mc, false, nil)
+}
+
+type template struct {
+	env      types.Env
+	hygienic bool
+
+	// gensyms holds the symbols that auto-gensym symbols are replaced by.
+	gensyms map[string]types.Value
+}
+
+func (t *template) symbol(v types.Value, quoted bool, s scope) types.Value {
+	name, err := symbol.Name(v)
+	if err != nil || symbol.IsUninterned(v) {
+		return v
+	}
+	if rv, ok := s[name]; ok && !quoted {
+		return rv
+	}
+	if len(name) <= len(symbol.AutoGensymSuffix) || !strings.HasSuffix(name, symbol.AutoGensymSuffix) {
+		return v
+	}
+	rv, ok := t.gensyms[name]
+	if !ok {
+		rv = symbol.Gensym(strings.TrimSuffix(name, symbol.AutoGensymSuffix))
+		t.gensyms[name] = rv
+	}
+	return rv
+}
+
+func (t *template) expand(mc types.Value, quoted bool, s scope) (types.Value, error) {
+	car, _, ok := cons.Decompose(mc)
+	if !ok {
+		return t.symbol(mc, quoted, s), nil
+	}
+	if name, err := symbol.Name(car); err == nil && name == "quote" {
+		quoted = true
+	}
+	return t.expandList(mc, quoted, 0, t.layout(mc, quoted, s))
+}
+
+// expandList expands the elements of a list in a template from the i'th on,
+// with the given layout.
+func (t *template) expandList(mc types.Value, quoted bool, i int, l layout) (types.Value, error) {
+	s, sub := l(i)
 	car, cdr, ok := cons.Decompose(mc)
 	if !ok {
-		return mc, nil
+		return t.symbol(mc, quoted, s), nil
 	}
 
 	var carItems []types.Value
 
 	if w, ok := IsWrappedInUnary("unquote", car); ok {
-		newCar, err := w.Eval(e)
+		newCar, err := w.Eval(t.env)
 		if err != nil {
 			return nil, err
 		}
 		carItems = append(carItems, newCar)
 	} else if w, ok := IsWrappedInUnary("unquote-splicing", car); ok {
-		listToBeSpliced, err := w.Eval(e)
+		listToBeSpliced, err := w.Eval(t.env)
 		if err != nil {
 			return nil, err
 		}
@@ -72,15 +130,23 @@ func ExpandQuasiQuote(e types.Env, mc types.Value) (types.Value, error) {
 		for _, elt := range elements {
 			carItems = append(carItems, elt)
 		}
+	} else if operator, ok := t.operator(car, i == 0, quoted, s); ok {
+		carItems = append(carItems, operator)
+	} else if sub != nil && cons.IsCons(car) {
+		newCar, err := t.expandList(car, quoted, 0, sub)
+		if err != nil {
+			return nil, err
+		}
+		carItems = append(carItems, newCar)
 	} else {
-		newCar, err := ExpandQuasiQuote(e, car)
+		newCar, err := t.expand(car, quoted, s)
 		if err != nil {
 			return nil, err
 		}
 		carItems = append(carItems, newCar)
 	}
 
-	newCdr, err := ExpandQuasiQuote(e, cdr)
+	newCdr, err := t.expandList(cdr, quoted, i+1, l)
 	if err != nil {
 		return nil, err
 	}
@@ -127,15 +193,19 @@ func Progn(e types.Env, vs []types.Value) (types.Value, error) {
 	if len(vs) == 0 {
 		return nil, errors.New("no body")
 	}
+	// If an earlier form may have thrown, so may the whole.
+	var exceptions []error
 	var result types.Value
-	var err error
 	for _, v := range vs {
-		result, err = v.Eval(e)
+		val, err := v.Eval(e)
 		if err != nil {
 			return nil, err
 		}
+		var valExceptions []error
+		result, valExceptions = maythrow.Split(val)
+		exceptions = append(exceptions, valExceptions...)
 	}
-	return result, nil
+	return maythrow.New(result, exceptions), nil
 }
 
 func ToSymbol(s string) types.Value {
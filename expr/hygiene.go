@@ -0,0 +1,373 @@
+package expr
+
+import (
+	"strings"
+
+	"github.com/steinarvk/heisenlisp/types"
+	"github.com/steinarvk/heisenlisp/value/symbol"
+)
+
+// Quasiquote templates in the body of a macro are expanded hygienically:
+//
+//   - Variables that a template binds itself (symbols written in the binding
+//     positions of let, let*, letrec, lambda, labels, letfunc, letfunc*, do
+//     or a catch clause) are renamed to fresh uninterned symbols within
+//     their scope, so that they cannot capture the user's variables.
+//   - Symbols that a template uses as the head of a form, naming a special
+//     form or a macro where the macro is defined, are replaced by that
+//     special form or macro, so that the user's variables cannot capture
+//     them.
+//
+// Symbols inserted by unquoting are left alone, as are quoted parts of a
+// template. Templates elsewhere, such as in a function that a macro calls to
+// build its expansion, are not hygienic.
+
+// macroExpansion is bound in the environments in which macros are expanded.
+var macroExpansion = symbol.IdOrPanic(symbol.Gensym("macro-expansion"))
+
+// MarkMacroExpansion marks an environment as one in which the body of a
+// macro is evaluated, making quasiquote templates there hygienic.
+func MarkMacroExpansion(e types.Env) {
+	e.Bind(macroExpansion, symbol.FromId(macroExpansion))
+}
+
+func isMacroExpansion(e types.Env) bool {
+	_, ok := e.Lookup(macroExpansion)
+	return ok
+}
+
+func isUnquoted(v types.Value) bool {
+	if _, ok := IsWrappedInUnary("unquote", v); ok {
+		return true
+	}
+	_, ok := IsWrappedInUnary("unquote-splicing", v)
+	return ok
+}
+
+// literalList returns the elements of a list written in a template.
+func literalList(v types.Value) []types.Value {
+	if isUnquoted(v) {
+		return nil
+	}
+	xs, err := UnwrapList(v)
+	if err != nil {
+		return nil
+	}
+	return xs
+}
+
+// bindingNames returns the names bound by a list of bindings, such as
+// ((name value)...).
+func bindingNames(v types.Value) []types.Value {
+	var rv []types.Value
+	for _, binding := range literalList(v) {
+		if xs := literalList(binding); len(xs) > 0 {
+			rv = append(rv, xs[0])
+		}
+	}
+	return rv
+}
+
+// lambdaListNames returns the names bound by a lambda list, except keyword
+// parameters (whose names are used by callers) and patterns.
+func lambdaListNames(v types.Value) []types.Value {
+	var rv []types.Value
+	optional := false
+	for _, x := range literalList(v) {
+		if name, err := symbol.Name(x); err == nil {
+			switch {
+			case name == "&key":
+				return rv
+			case name == "&optional":
+				optional = true
+			case !strings.HasPrefix(name, "&"):
+				rv = append(rv, x)
+			}
+			continue
+		}
+		if xs := literalList(x); optional && len(xs) > 0 {
+			rv = append(rv, xs[0])
+		}
+	}
+	return rv
+}
+
+// A scope maps the names of the variables that a template binds, around some
+// part of it, to the symbols they are renamed to.
+type scope map[string]types.Value
+
+// extend returns a scope with fresh symbols for some more names.
+func (s scope) extend(names []types.Value) scope {
+	var rv scope
+	for _, x := range names {
+		name, err := symbol.Name(x)
+		if err != nil || symbol.IsKeyword(x) || symbol.IsUninterned(x) || strings.HasSuffix(name, symbol.AutoGensymSuffix) {
+			continue
+		}
+		if rv == nil {
+			rv = scope{}
+			for k, v := range s {
+				rv[k] = v
+			}
+		}
+		rv[name] = symbol.Gensym(name)
+	}
+	if rv == nil {
+		return s
+	}
+	return rv
+}
+
+// A layout gives the scope of each element of a list in a template, and,
+// for a list that is not a form of its own (such as a list of bindings), the
+// layout of the element. A nil layout means the element is laid out as a
+// form.
+type layout func(i int) (scope, layout)
+
+func uniform(s scope) layout {
+	return func(int) (scope, layout) { return s, nil }
+}
+
+// around lays out a form with its head in one scope and the rest in another.
+func around(outer, inner scope) layout {
+	return func(i int) (scope, layout) {
+		if i == 0 {
+			return outer, nil
+		}
+		return inner, nil
+	}
+}
+
+// binding lays out a binding (name value...), with the name in one scope and
+// the rest in another.
+func binding(name, value scope) layout {
+	return around(name, value)
+}
+
+// function lays out a binding (name (params) body...) of labels, with the
+// name in a scope and the rest in that scope and the parameters.
+func function(s scope, v types.Value) layout {
+	var params []types.Value
+	if xs := literalList(v); len(xs) > 1 {
+		params = lambdaListNames(xs[1])
+	}
+	return around(s, s.extend(params))
+}
+
+// sequentialScopes returns the scopes before and after each of a list of
+// bindings that are made one after another, as in let*.
+func sequentialScopes(s scope, bindings []types.Value) []scope {
+	rv := []scope{s}
+	for _, x := range bindings {
+		rv = append(rv, rv[len(rv)-1].extend(bindingNames(WrapList([]types.Value{x}))))
+	}
+	return rv
+}
+
+func letLayout(xs []types.Value, s scope) layout {
+	bindings, named := 1, false
+	if len(xs) > 2 && symbol.Is(xs[1]) {
+		bindings, named = 2, true
+	}
+	if len(xs) <= bindings {
+		return uniform(s)
+	}
+	names := bindingNames(xs[bindings])
+	if named {
+		names = append(names, xs[1])
+	}
+	inner := s.extend(names)
+	return func(i int) (scope, layout) {
+		switch {
+		case i == bindings:
+			return s, func(int) (scope, layout) { return s, binding(inner, s) }
+		case i == 1 && named:
+			return inner, nil
+		case i < bindings:
+			return s, nil
+		}
+		return inner, nil
+	}
+}
+
+func letStarLayout(xs []types.Value, s scope) layout {
+	if len(xs) < 2 {
+		return uniform(s)
+	}
+	bindings := literalList(xs[1])
+	scopes := sequentialScopes(s, bindings)
+	body := scopes[len(scopes)-1]
+	return func(i int) (scope, layout) {
+		switch i {
+		case 0:
+			return s, nil
+		case 1:
+			return s, func(k int) (scope, layout) {
+				if k >= len(bindings) {
+					return body, nil
+				}
+				return scopes[k], binding(scopes[k+1], scopes[k])
+			}
+		}
+		return body, nil
+	}
+}
+
+// recursiveLayout lays out forms like letrec whose bindings are in scope
+// throughout.
+func recursiveLayout(xs []types.Value, s scope) layout {
+	if len(xs) < 2 {
+		return uniform(s)
+	}
+	return around(s, s.extend(bindingNames(xs[1])))
+}
+
+func lambdaLayout(xs []types.Value, s scope) layout {
+	if len(xs) < 2 {
+		return uniform(s)
+	}
+	return around(s, s.extend(lambdaListNames(xs[1])))
+}
+
+func labelsLayout(xs []types.Value, s scope) layout {
+	if len(xs) < 2 {
+		return uniform(s)
+	}
+	bindings := literalList(xs[1])
+	inner := s.extend(bindingNames(xs[1]))
+	return func(i int) (scope, layout) {
+		switch i {
+		case 0:
+			return s, nil
+		case 1:
+			return inner, func(k int) (scope, layout) {
+				if k >= len(bindings) {
+					return inner, nil
+				}
+				return inner, function(inner, bindings[k])
+			}
+		}
+		return inner, nil
+	}
+}
+
+func letfuncStarLayout(xs []types.Value, s scope) layout {
+	if len(xs) < 2 {
+		return uniform(s)
+	}
+	bindings := literalList(xs[1])
+	scopes := sequentialScopes(s, bindings)
+	body := scopes[len(scopes)-1]
+	return func(i int) (scope, layout) {
+		switch i {
+		case 0:
+			return s, nil
+		case 1:
+			return s, func(k int) (scope, layout) {
+				if k >= len(bindings) {
+					return body, nil
+				}
+				return scopes[k+1], function(scopes[k+1], bindings[k])
+			}
+		}
+		return body, nil
+	}
+}
+
+func doLayout(xs []types.Value, s scope) layout {
+	if len(xs) < 2 {
+		return uniform(s)
+	}
+	inner := s.extend(bindingNames(xs[1]))
+	// The steps of (var init step) are in the scope of the variables, but
+	// not the initial values.
+	step := func(j int) (scope, layout) {
+		if j == 1 {
+			return s, nil
+		}
+		return inner, nil
+	}
+	return func(i int) (scope, layout) {
+		switch i {
+		case 0:
+			return s, nil
+		case 1:
+			return s, func(int) (scope, layout) { return s, step }
+		}
+		return inner, nil
+	}
+}
+
+// tryLayout lays out a try, with the variable of each catch clause in scope
+// within the clause.
+func tryLayout(xs []types.Value, s scope) layout {
+	return func(i int) (scope, layout) {
+		if i == 0 || i >= len(xs) {
+			return s, nil
+		}
+		clause := literalList(xs[i])
+		if len(clause) < 2 {
+			return s, nil
+		}
+		if name, err := symbol.Name(clause[0]); err != nil || name != "catch" {
+			return s, nil
+		}
+		spec := literalList(clause[1])
+		if len(spec) == 0 {
+			return s, nil
+		}
+		return s.extend(spec[len(spec)-1:]), nil
+	}
+}
+
+// bindingForms gives the layouts of the forms that bind variables, given the
+// elements of a form and the scope around it.
+var bindingForms = map[string]func(xs []types.Value, s scope) layout{
+	"let":      letLayout,
+	"let*":     letStarLayout,
+	"letrec":   recursiveLayout,
+	"lambda":   lambdaLayout,
+	"labels":   labelsLayout,
+	"letfunc":  labelsLayout,
+	"letfunc*": letfuncStarLayout,
+	"do":       doLayout,
+	"try":      tryLayout,
+}
+
+// layout returns the layout of a list in a template, in a scope.
+func (t *template) layout(v types.Value, quoted bool, s scope) layout {
+	xs := literalList(v)
+	if !t.hygienic || quoted || len(xs) == 0 {
+		return uniform(s)
+	}
+	name, err := symbol.Name(xs[0])
+	if err != nil || s[name] != nil || bindingForms[name] == nil {
+		return uniform(s)
+	}
+	return bindingForms[name](xs, s)
+}
+
+// operator returns what a symbol at the head of a form (outside quoted parts)
+// in a hygienic template refers to: the special form or macro it names where
+// the macro is defined, if any.
+func (t *template) operator(v types.Value, head, quoted bool, s scope) (types.Value, bool) {
+	if !t.hygienic || !head || quoted {
+		return nil, false
+	}
+	name, err := symbol.Name(v)
+	if err != nil || name == "quote" || name == "quasiquote" {
+		return nil, false
+	}
+	if _, ok := s[name]; ok {
+		return nil, false
+	}
+	val, ok := t.env.Lookup(symbol.IdOrPanic(v))
+	if !ok {
+		return nil, false
+	}
+	switch val.(type) {
+	case types.SpecialForm, types.Macro:
+		return val, true
+	}
+	return nil, false
+}
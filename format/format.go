@@ -0,0 +1,338 @@
+// Package format lays out Heisenlisp source code in a canonical format,
+// preserving comments and blank lines between forms.
+//
+// The layout follows the same rules as the pretty-printer: forms that fit
+// within the width are kept on one line (unless they were already broken
+// over several lines), special forms with a body (let,
+// defun! and so on) indent the body by two spaces, and other forms align
+// their arguments under the first argument.
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/steinarvk/heisenlisp/gen/parser"
+	"github.com/steinarvk/heisenlisp/pretty"
+	"github.com/steinarvk/heisenlisp/reader"
+	"github.com/steinarvk/heisenlisp/types"
+)
+
+// Width is the target line width of formatted code.
+var Width = 80
+
+// Source formats a Heisenlisp source file. The name is used in error
+// messages only.
+func Source(name string, src []byte) ([]byte, error) {
+	nodes, err := reader.Read(name, src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &printer{width: Width}
+	p.layoutTopLevel(nodes)
+	formatted := []byte(p.buf.String())
+
+	if err := checkEquivalent(name, src, formatted); err != nil {
+		return nil, err
+	}
+
+	return formatted, nil
+}
+
+func parseAll(name string, src []byte) ([]string, error) {
+	if len(bytes.TrimSpace(src)) == 0 {
+		return nil, nil
+	}
+	rv, err := parser.Parse(name, src)
+	if err != nil {
+		return nil, err
+	}
+	var exprs []string
+	for _, x := range rv.([]interface{}) {
+		exprs = append(exprs, x.(types.Value).String())
+	}
+	return exprs, nil
+}
+
+// checkEquivalent guards against formatter bugs by checking that the
+// formatted code parses to the same expressions as the original.
+func checkEquivalent(name string, original, formatted []byte) error {
+	before, err := parseAll(name, original)
+	if err != nil {
+		return err
+	}
+	after, err := parseAll(name, formatted)
+	if err != nil {
+		return fmt.Errorf("%s: formatted code does not parse: %v", name, err)
+	}
+	if len(before) != len(after) {
+		return fmt.Errorf("%s: formatting changed the number of expressions (%d to %d)", name, len(before), len(after))
+	}
+	for i := range before {
+		if before[i] != after[i] {
+			return fmt.Errorf("%s: formatting changed expression %s to %s", name, before[i], after[i])
+		}
+	}
+	return nil
+}
+
+type printer struct {
+	width  int
+	buf    strings.Builder
+	column int
+}
+
+func (p *printer) write(s string) {
+	p.buf.WriteString(s)
+	p.column += len(s)
+}
+
+func (p *printer) newline(column int, blankLine bool) {
+	p.buf.WriteString("\n")
+	if blankLine {
+		p.buf.WriteString("\n")
+	}
+	p.buf.WriteString(strings.Repeat(" ", column))
+	p.column = column
+}
+
+func (p *printer) fits(s string, column int) bool {
+	return column+len(s) <= p.width
+}
+
+// flat returns the single-line representation of n, if it has one. Nodes
+// containing comments or blank lines cannot be printed on a single line, and
+// lists that the author broke over several lines are kept that way.
+func flat(n *reader.Node) (string, bool) {
+	switch n.Kind {
+	case reader.Comment:
+		return "", false
+
+	case reader.Quote:
+		s, ok := flat(n.Children[0])
+		return n.Text + s, ok
+
+	case reader.List:
+		if n.IsMultiline() {
+			return "", false
+		}
+		var parts []string
+		for i, child := range n.Children {
+			if i > 0 && child.BlankLineBefore {
+				return "", false
+			}
+			s, ok := flat(child)
+			if !ok {
+				return "", false
+			}
+			parts = append(parts, s)
+		}
+		return "(" + strings.Join(parts, " ") + ")", true
+	}
+
+	return n.Text, true
+}
+
+// headSymbol returns the name of the symbol at the head of a list, if any.
+func headSymbol(n *reader.Node) (string, bool) {
+	if n.Kind != reader.List || len(n.Children) == 0 {
+		return "", false
+	}
+	head := n.Children[0]
+	if head.Kind != reader.Atom {
+		return "", false
+	}
+	c := head.Text[0]
+	if c == '"' || c == '#' || (c >= '0' && c <= '9') {
+		return "", false
+	}
+	if c == '-' && len(head.Text) > 1 && head.Text[1] >= '0' && head.Text[1] <= '9' {
+		return "", false
+	}
+	return head.Text, true
+}
+
+func (p *printer) layoutTopLevel(nodes []*reader.Node) {
+	for i, n := range nodes {
+		if i > 0 {
+			if n.IsComment() && n.SameLine {
+				p.write(" " + n.Text)
+				continue
+			}
+			p.newline(0, n.BlankLineBefore)
+		}
+		p.layout(n)
+	}
+	if len(nodes) > 0 {
+		p.buf.WriteString("\n")
+	}
+}
+
+// layout writes n starting at the current column.
+func (p *printer) layout(n *reader.Node) {
+	column := p.column
+
+	if s, ok := flat(n); ok && p.fits(s, column) {
+		p.write(s)
+		return
+	}
+
+	switch n.Kind {
+	case reader.Atom, reader.Comment:
+		p.write(n.Text)
+
+	case reader.Quote:
+		p.write(n.Text)
+		p.layout(n.Children[0])
+
+	case reader.List:
+		p.write("(")
+		if name, ok := headSymbol(n); ok {
+			p.write(name)
+			if distinguished, ok := pretty.BodyFormArguments(name); ok {
+				p.layoutBodyForm(n.Children[1:], column, distinguished, pretty.BindsFunctions(name))
+			} else {
+				p.layoutCall(n.Children[1:], column, column+len(name)+2)
+			}
+		} else {
+			p.layoutData(n.Children, column+1)
+		}
+		p.write(")")
+	}
+}
+
+// layoutBodyForm lays out the arguments of a form such as
+// (let (bindings) body...), keeping the distinguished arguments on the
+// first line and indenting the body by two. A distinguished argument after
+// one that takes several lines goes on a line of its own, lined up with the
+// first. If functions is set, the bindings define functions (as in labels).
+func (p *printer) layoutBodyForm(args []*reader.Node, column int, distinguished int, functions bool) {
+	aligned := p.column + 1
+	multiline := false
+	for i := 0; i < distinguished && len(args) > 0; i++ {
+		if args[0].IsComment() || args[0].BlankLineBefore {
+			break
+		}
+		if multiline {
+			p.newline(aligned, false)
+		} else {
+			p.write(" ")
+		}
+		start := p.buf.Len()
+		p.layoutBindingsOrValue(args[0], i == 0 && functions)
+		multiline = strings.Contains(p.buf.String()[start:], "\n")
+		args = args[1:]
+	}
+	p.layoutLines(args, column+2, false)
+}
+
+// layoutBindingsOrValue lays out a list of lists, such as a let binding
+// list, with one element per line so that the elements line up. Bindings
+// of functions are laid out like defun!s.
+func (p *printer) layoutBindingsOrValue(n *reader.Node, functions bool) {
+	if s, ok := flat(n); (ok && p.fits(s, p.column)) || n.Kind != reader.List {
+		p.layout(n)
+		return
+	}
+	if _, ok := headSymbol(n); ok {
+		p.layout(n)
+		return
+	}
+	column := p.column
+	p.write("(")
+	if functions {
+		p.layoutLinesWith(n.Children, column+1, true, p.layoutFunctionBinding)
+	} else {
+		p.layoutLines(n.Children, column+1, true)
+	}
+	p.write(")")
+}
+
+// layoutFunctionBinding lays out a binding (name (params) body...) of a
+// form such as labels like a defun!.
+func (p *printer) layoutFunctionBinding(n *reader.Node) {
+	column := p.column
+	name, ok := headSymbol(n)
+	if s, fits := flat(n); (fits && p.fits(s, column)) || !ok || len(n.Children) < 3 {
+		p.layout(n)
+		return
+	}
+	p.write("(")
+	p.write(name)
+	p.layoutBodyForm(n.Children[1:], column, 1, false)
+	p.write(")")
+}
+
+// layoutCall lays out the arguments of a function call or a form like if,
+// aligning the arguments under the first one.
+func (p *printer) layoutCall(args []*reader.Node, column, argColumn int) {
+	if len(args) == 0 {
+		return
+	}
+	first, ok := flat(args[0])
+	if args[0].IsComment() || args[0].BlankLineBefore || !ok || !p.fits(first, argColumn) {
+		// The head is too wide to align the arguments after it.
+		if argColumn > column+2 {
+			argColumn = column + 2
+			p.layoutLines(args, argColumn, false)
+			return
+		}
+	}
+	p.write(" ")
+	p.layoutLines(args, argColumn, true)
+}
+
+// layoutData lays out a list that is not a form, filling lines if it
+// consists of simple atoms and one element per line otherwise.
+func (p *printer) layoutData(xs []*reader.Node, column int) {
+	simple := true
+	for i, x := range xs {
+		if x.Kind != reader.Atom || (i > 0 && x.BlankLineBefore) {
+			simple = false
+		}
+	}
+	if !simple {
+		p.layoutLines(xs, column, true)
+		return
+	}
+	for i, x := range xs {
+		if i > 0 {
+			if p.fits(" "+x.Text, p.column) {
+				p.write(" ")
+			} else {
+				p.newline(column, false)
+			}
+		}
+		p.write(x.Text)
+	}
+}
+
+// layoutLines lays out nodes one per line at the given column. If
+// firstInline is set, the first node continues the current line.
+// Comments that were on the same line as the previous token stay there.
+func (p *printer) layoutLines(xs []*reader.Node, column int, firstInline bool) {
+	p.layoutLinesWith(xs, column, firstInline, p.layout)
+}
+
+// layoutLinesWith is layoutLines, laying out each node with layout.
+func (p *printer) layoutLinesWith(xs []*reader.Node, column int, firstInline bool, layout func(*reader.Node)) {
+	mustBreak := false
+	for i, x := range xs {
+		switch {
+		case x.IsComment() && x.SameLine && !(i == 0 && firstInline):
+			p.write(" " + x.Text)
+			mustBreak = true
+			continue
+		case i == 0 && firstInline:
+		default:
+			p.newline(column, x.BlankLineBefore)
+		}
+		layout(x)
+		mustBreak = x.IsComment()
+	}
+	if mustBreak {
+		p.newline(column, false)
+	}
+}
@@ -0,0 +1,64 @@
+package format
+
+import (
+	"testing"
+)
+
+func TestSource(t *testing.T) {
+	testcases := []struct {
+		input string
+		want  string
+	}{
+		{"(+   1\t2)", "(+ 1 2)\n"},
+		{"(defun! f (x) (+ x 1))\n\n\n\n(f 2)", "(defun! f (x) (+ x 1))\n\n(f 2)\n"},
+		{"(defun! f (x)\n(+ x 1))", "(defun! f (x)\n  (+ x 1))\n"},
+		{"(if (= x 1)\n  2 3)", "(if (= x 1)\n    2\n    3)\n"},
+		{";; leading\n(foo) ;; trailing\n;; last", ";; leading\n(foo) ;; trailing\n;; last\n"},
+		{"(let ((x 1) ;; one\n (y 2))\n x)", "(let ((x 1) ;; one\n      (y 2))\n  x)\n"},
+		{"(foo a ;; about a\n)", "(foo a ;; about a\n     )\n"},
+		{"(progn\n  (a)\n\n  (b))", "(progn (a)\n\n       (b))\n"},
+		{"(quote   (a b))", "(quote (a b))\n"},
+		{"'(  a  b)", "'(a b)\n"},
+		{"`(a ,b ,@ c)", "`(a ,b ,@c)\n"},
+		{"(labels ((f (x)\n(g x x x x x x x x x x x x x x x x x x x x x x x x x x x x x x))) (f 1))",
+			"(labels ((f (x)\n           (g x x x x x x x x x x x x x x x x x x x x x x x x x x x x x x)))\n  (f 1))\n"},
+		{"(do ((iiiiiiii 0 (+ iiiiiiii 1)) (jjjjjjjj 0 (+ jjjjjjjj 1)) (kkkkkkkk 0 (+ kkkkkkkk 1))) ((= iiiiiiii 10000000) (list iiiiiiii jjjjjjjj kkkkkkkk)) body)",
+			"(do ((iiiiiiii 0 (+ iiiiiiii 1))\n     (jjjjjjjj 0 (+ jjjjjjjj 1))\n     (kkkkkkkk 0 (+ kkkkkkkk 1)))\n    ((= iiiiiiii 10000000) (list iiiiiiii jjjjjjjj kkkkkkkk))\n  body)\n"},
+		{"", ""},
+	}
+
+	for _, testcase := range testcases {
+		got, err := Source("<testcase>", []byte(testcase.input))
+		if err != nil {
+			t.Errorf("Source(%q) = err: %v", testcase.input, err)
+			continue
+		}
+		if string(got) != testcase.want {
+			t.Errorf("Source(%q) = %q want %q", testcase.input, string(got), testcase.want)
+		}
+
+		again, err := Source("<testcase>", got)
+		if err != nil {
+			t.Errorf("Source(%q) = err: %v", string(got), err)
+			continue
+		}
+		if string(again) != string(got) {
+			t.Errorf("Source is not idempotent on %q: %q", string(got), string(again))
+		}
+	}
+}
+
+func TestSourceErrors(t *testing.T) {
+	testcases := []string{
+		"(foo",
+		"foo)",
+		"\"unterminated",
+		"(foo ')",
+	}
+
+	for _, testcase := range testcases {
+		if got, err := Source("<testcase>", []byte(testcase)); err == nil {
+			t.Errorf("Source(%q) = %q want error", testcase, string(got))
+		}
+	}
+}
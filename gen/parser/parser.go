@@ -316,8 +316,8 @@ var g = &grammar {
 	exprs: []interface{}{
 &charClassMatcher{
 	pos: position{line: 62, col: 15, offset: 1088},
-	val: "[a-zA-Z?!+/*.=_&<>-]",
-	chars: []rune{'?','!','+','/','*','.','=','_','&','<','>','-',},
+	val: "[a-zA-Z?!+/*.=_&<>:-]",
+	chars: []rune{'?','!','+','/','*','.','=','_','&','<','>',':','-',},
 	ranges: []rune{'a','z','A','Z',},
 	ignoreCase: false,
 	inverted: false,
@@ -326,8 +326,8 @@ var g = &grammar {
 	pos: position{line: 62, col: 36, offset: 1109},
 	expr: &charClassMatcher{
 	pos: position{line: 62, col: 36, offset: 1109},
-	val: "[a-zA-Z0-9?!+/*.=&<>-]",
-	chars: []rune{'?','!','+','/','*','.','=','&','<','>','-',},
+	val: "[a-zA-Z0-9?!+/*.=&<>#-]",
+	chars: []rune{'?','!','+','/','*','.','=','&','<','>','#','-',},
 	ranges: []rune{'a','z','A','Z','0','9',},
 	ignoreCase: false,
 	inverted: false,
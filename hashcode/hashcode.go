@@ -18,3 +18,9 @@ func Hash(x string, data ...[]byte) uint32 {
 	}
 	return rv.Sum32()
 }
+
+// Bytes encodes a hashcode, so that the hashcodes of the parts of a value
+// can be hashed together.
+func Bytes(h uint32) []byte {
+	return []byte{byte(h), byte(h >> 8), byte(h >> 16), byte(h >> 24)}
+}
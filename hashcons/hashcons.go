@@ -0,0 +1,119 @@
+// Package hashcons implements optional hash-consing of immutable values.
+//
+// With hash-consing enabled, constructing a cons, small integer, string or
+// any-of that is structurally identical to one constructed earlier returns
+// the earlier value instead of a new one. Since the parts of a hash-consed
+// value are themselves hash-consed, values can be matched shallowly, and
+// equal values are usually the same object, which saves memory for the
+// large, repetitive lists built by uncertain list operations and makes
+// comparing them cheap.
+//
+// Sharing is best-effort: the tables are bounded, and a value may be
+// constructed anew once it has been forgotten. Nothing may depend on equal
+// values being the same object, only on the same object being equal.
+package hashcons
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/steinarvk/heisenlisp/types"
+)
+
+// Enabled makes values hash-consed. It must not be changed while anything
+// is being evaluated.
+var Enabled = false
+
+// Capacity is the number of values remembered by each table, after which
+// it is emptied and starts afresh.
+var Capacity = 1 << 20
+
+var (
+	metricHashconsShared = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "hlisp",
+			Name:      "hashcons_shared",
+			Help:      "Values shared instead of constructed anew by hash-consing",
+		},
+	)
+
+	metricHashconsResets = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "hlisp",
+			Name:      "hashcons_resets",
+			Help:      "Hash-consing tables emptied for reaching their capacity",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(metricHashconsShared)
+	prometheus.MustRegister(metricHashconsResets)
+}
+
+// Table remembers values by hashcode. It is safe for concurrent use.
+type Table struct {
+	mu sync.Mutex
+	m  map[uint32][]types.Value
+	n  int
+}
+
+func NewTable() *Table {
+	return &Table{m: map[uint32][]types.Value{}}
+}
+
+// Intern returns a remembered value with the hashcode h for which match is
+// true, or else remembers and returns the value returned by create.
+func (t *Table) Intern(h uint32, match func(types.Value) bool, create func() types.Value) types.Value {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, v := range t.m[h] {
+		if match(v) {
+			metricHashconsShared.Inc()
+			return v
+		}
+	}
+
+	if t.n >= Capacity {
+		metricHashconsResets.Inc()
+		t.m = map[uint32][]types.Value{}
+		t.n = 0
+	}
+
+	v := create()
+	t.m[h] = append(t.m[h], v)
+	t.n++
+	return v
+}
+
+// Identical checks whether two values are interchangeable as parts of a
+// hash-consed value: equal atoms of the same type and hashcode (which tells
+// e.g. 0.0 from -0.0), or the same object.
+func Identical(a, b types.Value) bool {
+	aAtom, aok := a.(types.Atom)
+	bAtom, bok := b.(types.Atom)
+	if aok || bok {
+		return aok && bok && a.TypeName() == b.TypeName() && a.Hashcode() == b.Hashcode() && aAtom.AtomEquals(bAtom)
+	}
+
+	// Only pointers are compared, as comparing other values (e.g. structs
+	// with func fields) may panic.
+	t := reflect.TypeOf(a)
+	return t.Kind() == reflect.Ptr && t == reflect.TypeOf(b) && a == b
+}
+
+// IdenticalSlices checks whether two slices have identical values in the
+// same order.
+func IdenticalSlices(a, b []types.Value) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !Identical(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,68 @@
+package hashcons_test
+
+import (
+	"testing"
+
+	"github.com/steinarvk/heisenlisp/hashcons"
+	"github.com/steinarvk/heisenlisp/types"
+	"github.com/steinarvk/heisenlisp/value/cons"
+	"github.com/steinarvk/heisenlisp/value/integer"
+	"github.com/steinarvk/heisenlisp/value/real"
+	"github.com/steinarvk/heisenlisp/value/str"
+	"github.com/steinarvk/heisenlisp/value/unknowns/anyof"
+)
+
+func list(xs ...types.Value) types.Value {
+	return cons.NewChain(xs, nil)
+}
+
+func TestSharing(t *testing.T) {
+	hashcons.Enabled = true
+	defer func() { hashcons.Enabled = false }()
+
+	a := list(integer.FromInt64(1), str.New("hello"), list(integer.FromInt64(1000000)))
+	b := list(integer.FromInt64(1), str.New("hello"), list(integer.FromInt64(1000000)))
+	if a != b {
+		t.Errorf("%v and %v are not shared", a, b)
+	}
+	if !cons.Same(a, b) {
+		t.Errorf("cons.Same(%v, %v) = false", a, b)
+	}
+
+	if c := list(integer.FromInt64(1), str.New("hello")); c == a {
+		t.Errorf("%v and %v are shared", a, c)
+	}
+
+	if x, y := list(real.FromFloat64(0)), list(real.FromFloat64(-1*0.0*-1)); x != y {
+		t.Errorf("%v and %v are not shared", x, y)
+	}
+
+	x := anyof.NewOrPanic([]types.Value{integer.FromInt64(1), a})
+	y := anyof.NewOrPanic([]types.Value{integer.FromInt64(1), b})
+	if x != y {
+		t.Errorf("%v and %v are not shared", x, y)
+	}
+}
+
+func TestUncertainListsAreNotSame(t *testing.T) {
+	hashcons.Enabled = true
+	defer func() { hashcons.Enabled = false }()
+
+	unk := anyof.NewOrPanic([]types.Value{integer.FromInt64(1), integer.FromInt64(2)})
+	a := list(unk)
+	b := list(unk)
+	if a != b {
+		t.Errorf("%v and %v are not shared", a, b)
+	}
+	if cons.Same(a, b) {
+		t.Errorf("cons.Same(%v, %v) = true, but they may differ", a, b)
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	a := list(integer.FromInt64(1))
+	b := list(integer.FromInt64(1))
+	if a == b {
+		t.Errorf("%v and %v are shared", a, b)
+	}
+}
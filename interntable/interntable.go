@@ -31,6 +31,16 @@ func (t *Table) ToInt(s string) (uint32, bool) {
 	return result, true
 }
 
+// Fresh allocates a new identity with the given name, without interning it:
+// ToInt will never return the new identity, even for the same name.
+func (t *Table) Fresh(s string) uint32 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.intToString = append(t.intToString, s)
+	return uint32(len(t.intToString))
+}
+
 func (t *Table) ToString(i uint32) (string, bool) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
@@ -7,7 +7,10 @@ import (
 
 	"github.com/steinarvk/heisenlisp/env"
 	"github.com/steinarvk/heisenlisp/expr"
+	"github.com/steinarvk/heisenlisp/lisperr"
+	"github.com/steinarvk/heisenlisp/pattern"
 	"github.com/steinarvk/heisenlisp/types"
+	"github.com/steinarvk/heisenlisp/value/null"
 	"github.com/steinarvk/heisenlisp/value/symbol"
 )
 
@@ -16,11 +19,49 @@ type namedValue struct {
 	val  types.Value
 }
 
+// requiredArg is either a plain named argument, or a pattern that the
+// argument is destructured by.
+type requiredArg struct {
+	name    uint32
+	pattern pattern.Pattern
+}
+
+type keywordArg struct {
+	namedValue
+	keyword string
+}
+
 type LambdaList struct {
 	rawValue     types.Value
-	requiredArgs []uint32
+	requiredArgs []requiredArg
 	optionalArgs []namedValue
 	restArgName  uint32
+	keywordArgs  []keywordArg
+	hasKeys      bool
+	variables    []uint32
+}
+
+// Variables returns the names bound by the lambda list, in the order of the
+// slots of the frames created by BindArgs.
+func (l *LambdaList) Variables() []uint32 {
+	var rv []uint32
+	for _, reqArg := range l.requiredArgs {
+		if reqArg.pattern == nil {
+			rv = append(rv, reqArg.name)
+		} else {
+			rv = append(rv, reqArg.pattern.Variables()...)
+		}
+	}
+	for _, optArg := range l.optionalArgs {
+		rv = append(rv, optArg.name)
+	}
+	if l.restArgName != 0 {
+		rv = append(rv, l.restArgName)
+	}
+	for _, keyArg := range l.keywordArgs {
+		rv = append(rv, keyArg.name)
+	}
+	return rv
 }
 
 func (l *LambdaList) minArgs() int {
@@ -28,31 +69,77 @@ func (l *LambdaList) minArgs() int {
 }
 
 func (l *LambdaList) maxArgs() (int, bool) {
-	if l.restArgName != 0 {
+	if l.restArgName != 0 || l.hasKeys {
 		return 0, false
 	}
 	return len(l.requiredArgs) + len(l.optionalArgs), true
 }
 
+// Destructures returns whether any of the arguments are destructured by a
+// pattern, so that a call may bind them in several possible worlds.
+func (l *LambdaList) Destructures() bool {
+	for _, reqArg := range l.requiredArgs {
+		if reqArg.pattern != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// BindArgs binds the arguments of a call in a new frame. It is an error if
+// a destructured argument may match in several ways, or may not match.
 func (l *LambdaList) BindArgs(e types.Env, params []types.Value, pure bool) (types.Env, error) {
+	frames, exceptions, err := l.BindArgsInWorlds(e, params, pure)
+	if err != nil {
+		return nil, err
+	}
+	if len(frames) != 1 || len(exceptions) != 0 {
+		return nil, fmt.Errorf("arguments %v do not match %v in a single way", params, l.rawValue)
+	}
+	return frames[0], nil
+}
+
+// BindArgsInWorlds binds the arguments of a call in new frames, one for each
+// possible world of destructuring them (see pattern.Worlds). The exceptions
+// are those of the worlds in which an argument does not match.
+func (l *LambdaList) BindArgsInWorlds(e types.Env, params []types.Value, pure bool) ([]types.Env, []error, error) {
 	if min := l.minArgs(); len(params) < min {
-		return nil, fmt.Errorf("too few params (want %d got %d)", min, len(params))
+		return nil, nil, lisperr.WrongArity{fmt.Sprintf("at least %d params", min), len(params)}
 	}
 
 	if max, limited := l.maxArgs(); limited && len(params) > max {
-		return nil, fmt.Errorf("too many params (want at most %d got %d)", max, len(params))
+		return nil, nil, lisperr.WrongArity{fmt.Sprintf("at most %d params", max), len(params)}
 	}
 
-	e = env.New(e)
-	if pure {
-		e.MarkPure()
+	worlds := pattern.NewWorlds()
+	for _, reqArg := range l.requiredArgs {
+		if reqArg.pattern == nil {
+			worlds.Bind(reqArg.name, params[0])
+		} else if err := worlds.Destructure(e, reqArg.pattern, params[0]); err != nil {
+			return nil, nil, err
+		}
+		params = params[1:]
 	}
 
-	for _, reqArgName := range l.requiredArgs {
-		e.Bind(reqArgName, params[0])
-		params = params[1:]
+	var frames []types.Env
+	for _, bindings := range worlds.Bindings {
+		frame := env.NewFrame(e, l.variables)
+		if pure {
+			frame.MarkPure()
+		}
+		for _, binding := range bindings {
+			frame.Bind(binding.Name, binding.Value)
+		}
+		if err := l.bindOptionalArgs(frame, params); err != nil {
+			return nil, nil, err
+		}
+		frames = append(frames, frame)
 	}
+	return frames, worlds.Exceptions, nil
+}
 
+// bindOptionalArgs binds the arguments after the required ones in a frame.
+func (l *LambdaList) bindOptionalArgs(e types.Env, params []types.Value) error {
 	var err error
 
 	for _, optArg := range l.optionalArgs {
@@ -60,7 +147,7 @@ func (l *LambdaList) BindArgs(e types.Env, params []types.Value, pure bool) (typ
 		if len(params) == 0 {
 			val, err = optArg.val.Eval(e)
 			if err != nil {
-				return nil, err
+				return err
 			}
 		} else {
 			val = params[0]
@@ -73,7 +160,64 @@ func (l *LambdaList) BindArgs(e types.Env, params []types.Value, pure bool) (typ
 		e.Bind(l.restArgName, expr.WrapList(params))
 	}
 
-	return e, nil
+	if l.hasKeys {
+		var names []string
+		for _, keyArg := range l.keywordArgs {
+			names = append(names, keyArg.keyword)
+		}
+
+		given, err := ParseKeywords(params, names...)
+		if err != nil {
+			return err
+		}
+
+		for _, keyArg := range l.keywordArgs {
+			val, ok := given[keyArg.keyword]
+			if !ok {
+				val, err = keyArg.val.Eval(e)
+				if err != nil {
+					return err
+				}
+			}
+			e.Bind(keyArg.name, val)
+		}
+	}
+
+	return nil
+}
+
+// ParseKeywords parses a list of alternating keywords and values, e.g.
+// (:from 1 :to 10), into a map keyed by keyword name (without prefix).
+// Only the given keyword names are accepted, and each at most once.
+func ParseKeywords(xs []types.Value, names ...string) (map[string]types.Value, error) {
+	if len(xs)%2 != 0 {
+		return nil, fmt.Errorf("parsing keywords from odd-numbered arguments: %v", xs)
+	}
+
+	allowed := map[string]bool{}
+	for _, name := range names {
+		allowed[name] = true
+	}
+
+	rv := map[string]types.Value{}
+	for i := 0; i < len(xs); i += 2 {
+		name, err := symbol.KeywordName(xs[i])
+		if err != nil {
+			return nil, fmt.Errorf("parsing keywords: %v is not a keyword", xs[i])
+		}
+
+		if !allowed[name] {
+			return nil, fmt.Errorf("unknown keyword argument: %v", xs[i])
+		}
+
+		if _, present := rv[name]; present {
+			return nil, fmt.Errorf("duplicate keyword argument: %v", xs[i])
+		}
+
+		rv[name] = xs[i+1]
+	}
+
+	return rv, nil
 }
 
 func Parse(val types.Value) (*LambdaList, error) {
@@ -86,27 +230,49 @@ func Parse(val types.Value) (*LambdaList, error) {
 		return nil, err
 	}
 
-	addRequiredArgument := func(name string) {
-		rv.requiredArgs = append(rv.requiredArgs, symbol.StringToIdOrPanic(name))
+	// Parameters are bound by identity rather than by name, so that
+	// uninterned symbols can be parameters.
+	addRequiredArgument := func(sym types.Value) {
+		rv.requiredArgs = append(rv.requiredArgs, requiredArg{name: symbol.IdOrPanic(sym)})
+	}
+
+	addRequiredPattern := func(v types.Value) error {
+		p, err := pattern.Parse(v)
+		if err != nil {
+			return err
+		}
+		rv.requiredArgs = append(rv.requiredArgs, requiredArg{pattern: p})
+		return nil
 	}
 
-	addOptionalArgument := func(name string, val types.Value) {
+	addOptionalArgument := func(sym types.Value, val types.Value) {
 		rv.optionalArgs = append(rv.optionalArgs, namedValue{
-			name: symbol.StringToIdOrPanic(name),
+			name: symbol.IdOrPanic(sym),
 			val:  val,
 		})
 	}
 
-	setRestArgument := func(name string) {
-		rv.restArgName = symbol.StringToIdOrPanic(name)
+	setRestArgument := func(sym types.Value) {
+		rv.restArgName = symbol.IdOrPanic(sym)
+	}
+
+	addKeywordArgument := func(sym types.Value, name string, val types.Value) {
+		rv.keywordArgs = append(rv.keywordArgs, keywordArg{
+			namedValue: namedValue{
+				name: symbol.IdOrPanic(sym),
+				val:  val,
+			},
+			keyword: name,
+		})
 	}
 
 	inOptionalMode := false
+	inKeyMode := false
 
 	for i := 0; i < len(xs); i++ {
 		name, err := symbol.Name(xs[i])
 		if err != nil {
-			if inOptionalMode {
+			if inOptionalMode || inKeyMode {
 				nameSym, defaultValue, err := expr.UnwrapProperListPair(xs[i])
 				if err != nil {
 					return nil, err
@@ -116,31 +282,48 @@ func Parse(val types.Value) (*LambdaList, error) {
 					return nil, err
 				}
 
-				addOptionalArgument(name, defaultValue)
+				if inKeyMode {
+					addKeywordArgument(nameSym, name, defaultValue)
+				} else {
+					addOptionalArgument(nameSym, defaultValue)
+				}
 
 				continue
 			}
-			return nil, err
+			if err := addRequiredPattern(xs[i]); err != nil {
+				return nil, err
+			}
+			continue
 		}
 
 		if strings.HasPrefix(name, "&") {
 			switch name {
 			case "&optional":
+				if inKeyMode {
+					return nil, errors.New("&optional must come before &key in lambda list")
+				}
 				inOptionalMode = true
 
 			case "&rest":
-				// must be the second-to-last, and next must be a symbol
-				if len(xs) != (i + 2) {
-					return nil, errors.New("&rest must be penultimate element in lambda list")
+				// next must be a symbol, and only &key may follow it.
+				if len(xs) < (i+2) || (len(xs) > (i+2) && !isLambdaListKeyword(xs[i+2], "&key")) {
+					return nil, errors.New("&rest must be penultimate element in lambda list (or followed by &key)")
 				}
-				restName, err := symbol.Name(xs[i+1])
-				if err != nil {
+				if _, err := symbol.Name(xs[i+1]); err != nil {
 					return nil, fmt.Errorf("final element after &rest must be symbol (was %v): %v", xs[i+1], err)
 				}
-				setRestArgument(restName)
+				setRestArgument(xs[i+1])
 
 				i++ // skip over last
 
+			case "&key":
+				if rv.hasKeys {
+					return nil, errors.New("duplicate &key in lambda list")
+				}
+				inOptionalMode = false
+				inKeyMode = true
+				rv.hasKeys = true
+
 			default:
 				return nil, fmt.Errorf("unknown & parameter: %v", xs[i])
 			}
@@ -148,8 +331,20 @@ func Parse(val types.Value) (*LambdaList, error) {
 			continue
 		}
 
-		addRequiredArgument(name)
+		if inKeyMode {
+			addKeywordArgument(xs[i], name, null.Nil)
+			continue
+		}
+
+		addRequiredArgument(xs[i])
 	}
 
+	rv.variables = rv.Variables()
+
 	return rv, nil
 }
+
+func isLambdaListKeyword(v types.Value, want string) bool {
+	name, err := symbol.Name(v)
+	return err == nil && name == want
+}
@@ -12,7 +12,11 @@ import (
 
 	"github.com/steinarvk/heisenlisp/builtin"
 	"github.com/steinarvk/heisenlisp/code"
+	"github.com/steinarvk/heisenlisp/compile"
 	"github.com/steinarvk/heisenlisp/gen/parser"
+	"github.com/steinarvk/heisenlisp/hashcons"
+	"github.com/steinarvk/heisenlisp/module"
+	"github.com/steinarvk/heisenlisp/parallel"
 	"github.com/steinarvk/heisenlisp/types"
 	"github.com/steinarvk/heisenlisp/unknown"
 )
@@ -203,6 +207,15 @@ func TestExpressionsTruthy(t *testing.T) {
 		`(_maybe? (any-of true false))`,
 		`(_maybe? (any-of false true))`,
 		`(_maybe? (= (any-of 1 2) 2))`,
+		`(not (= (any-of 1 2 3) 4))`,
+		`(_maybe? (= (any-of "a" "b") "b"))`,
+		`(not (= (any-of "a" "b") 'b))`,
+		`(_maybe? (= (any-of (list 1) (list 2)) (list 2.0)))`,
+		`(_maybe? (= (any-of 1 2 3) (any-of 3 4 5)))`,
+		`(not (= (any-of 1 2 3) (any-of 4 5 6)))`,
+		`(= 100 (length (possible-values (apply any-of (range 100)))))`,
+		`(= 'unknown (_type (apply any-of (range 101))))`,
+		`(= 'unknown (_type (+ (apply any-of (range 50)) (* 100 (apply any-of (range 50))))))`,
 		`(_maybe? (= (unknown-of-type 'string) (unknown-of-type 'string)))`,
 		`(not (= (unknown-of-type 'integer) (unknown-of-type 'string)))`,
 		`(_maybe? (= (unknown-of-type 'string 'integer) (unknown-of-type 'string)))`,
@@ -224,63 +237,63 @@ func TestExpressionsTruthy(t *testing.T) {
 		`(floating-point? 3.14)`,
 		`(string? "hello")`,
 		`(symbol? 'hello)`,
-		`(_maybe? (= (number-in-range 'from 100 'to 200) 200))`,
-		`(not (= (number-in-range 'from 100 'below 200) 200))`,
-		`(_maybe? (= (number-in-range 'from 100 'below 200) 150))`,
-		`(_maybe? (= (number-in-range 'from 100 'below 200) 100))`,
-		`(_maybe? (= (number-in-range 'from 100 'below 200) 100))`,
-		`(not (= (number-in-range 'above 100 'below 200) 100))`,
-		`(not (= (number-in-range 'above 100 'below 200) 50))`,
-		`(_maybe? (= (number-in-range 'from 0 'to 1) (number-in-range 'from 0.5 'to 1.5)))`,
+		`(_maybe? (= (number-in-range :from 100 :to 200) 200))`,
+		`(not (= (number-in-range :from 100 :below 200) 200))`,
+		`(_maybe? (= (number-in-range :from 100 :below 200) 150))`,
+		`(_maybe? (= (number-in-range :from 100 :below 200) 100))`,
+		`(_maybe? (= (number-in-range :from 100 :below 200) 100))`,
+		`(not (= (number-in-range :above 100 :below 200) 100))`,
+		`(not (= (number-in-range :above 100 :below 200) 50))`,
+		`(_maybe? (= (number-in-range :from 0 :to 1) (number-in-range :from 0.5 :to 1.5)))`,
 		`(_atom-eq? "#number-in-range([0,200])" (_to-string
-		   (+ (number-in-range 'from 0 'to 100)
-			    (number-in-range 'from 0 'to 100))))`,
+		   (+ (number-in-range :from 0 :to 100)
+			    (number-in-range :from 0 :to 100))))`,
 		`(_atom-eq? "#number-in-range([100,200])" (_to-string
-		   (+ (number-in-range 'from 50 'to 100)
-			    (number-in-range 'from 50 'to 100))))`,
+		   (+ (number-in-range :from 50 :to 100)
+			    (number-in-range :from 50 :to 100))))`,
 		`(_atom-eq? "#number-in-range((100,200])" (_to-string
-		   (+ (number-in-range 'above 50 'to 100)
-			    (number-in-range 'from 50 'to 100))))`,
+		   (+ (number-in-range :above 50 :to 100)
+			    (number-in-range :from 50 :to 100))))`,
 		`(_atom-eq? "#number-in-range([100,200))" (_to-string
-		   (+ (number-in-range 'from 50 'to 100)
-			    (number-in-range 'from 50 'below 100))))`,
+		   (+ (number-in-range :from 50 :to 100)
+			    (number-in-range :from 50 :below 100))))`,
 		`(_atom-eq? "#number-in-range([-51,58])" (_to-string
-		   (- (number-in-range 'from 50 'to 100)
-			    (number-in-range 'from 42 'to 101))))`,
+		   (- (number-in-range :from 50 :to 100)
+			    (number-in-range :from 42 :to 101))))`,
 		`(_atom-eq? "#number-in-range([2100,10100])" (_to-string
-		   (* (number-in-range 'from 50 'to 100)
-			    (number-in-range 'from 42 'to 101))))`,
+		   (* (number-in-range :from 50 :to 100)
+			    (number-in-range :from 42 :to 101))))`,
 		`(_atom-eq? "#number-in-range([2100,10100))" (_to-string
-		   (* (number-in-range 'from 50 'below 100)
-			    (number-in-range 'from 42 'to 101))))`,
+		   (* (number-in-range :from 50 :below 100)
+			    (number-in-range :from 42 :to 101))))`,
 		`(_atom-eq? "#number-in-range([-5050,10100])" (_to-string
-		   (* (number-in-range 'from -50 'to 100)
-			    (number-in-range 'from 42 'to 101))))`,
+		   (* (number-in-range :from -50 :to 100)
+			    (number-in-range :from 42 :to 101))))`,
 		`(_atom-eq? "#number-in-range([-13869,10050])" (_to-string
-		   (* (number-in-range 'from -50 'to 69)
-			    (number-in-range 'from -201 'to 101))))`,
+		   (* (number-in-range :from -50 :to 69)
+			    (number-in-range :from -201 :to 101))))`,
 		`(= 1 1.0)`,
 		`(= 42 42.0)`,
 		`(= (/ 1 2) 0.5)`,
 		`(= "((((nil 1) 2) 3) 4)" (_to-string (fold-left list '() '(1 2 3 4))))`,
 		`(= "(((1 2) 3) 4)" (_to-string (reduce-left list '() '(1 2 3 4))))`,
 		`(= (length (list 1 2 3 4 5)) 5)`,
-		`(> (number-in-range 'from 10 'to 20) 0)`,
-		`(_maybe? (> (number-in-range 'from 11 'to 21) 16))`,
-		`(not (> (number-in-range 'from 10 'to 20) 25))`,
-		`(< 0 (number-in-range 'from 10 'to 20))`,
-		`(_maybe? (< 15 (number-in-range 'from 10 'to 20)))`,
-		`(not (< 25 (number-in-range 'from 10 'to 20)))`,
-		`(_maybe? (<= (number-in-range 'from 10 'to 20) 10))`,
-		`(not (<= (number-in-range 'above 10 'to 20) 10))`,
-		`(_maybe? (>= (number-in-range 'from 12 'to 22) 22))`,
-		`(not (>= (number-in-range 'from 10 'below 20) 20))`,
-		`(_maybe? (= (number-in-range 'from 10 'to 20) 20))`,
-		`(not (= (number-in-range 'from 10 'below 20) 20))`,
-		`(_maybe? (= (number-in-range 'above 0) 5))`,
-		`(not (= (number-in-range 'above 0) 0))`,
-		`(_maybe? (= (number-in-range 'below 0) -5))`,
-		`(not (= (number-in-range 'below 0) 5))`,
+		`(> (number-in-range :from 10 :to 20) 0)`,
+		`(_maybe? (> (number-in-range :from 11 :to 21) 16))`,
+		`(not (> (number-in-range :from 10 :to 20) 25))`,
+		`(< 0 (number-in-range :from 10 :to 20))`,
+		`(_maybe? (< 15 (number-in-range :from 10 :to 20)))`,
+		`(not (< 25 (number-in-range :from 10 :to 20)))`,
+		`(_maybe? (<= (number-in-range :from 10 :to 20) 10))`,
+		`(not (<= (number-in-range :above 10 :to 20) 10))`,
+		`(_maybe? (>= (number-in-range :from 12 :to 22) 22))`,
+		`(not (>= (number-in-range :from 10 :below 20) 20))`,
+		`(_maybe? (= (number-in-range :from 10 :to 20) 20))`,
+		`(not (= (number-in-range :from 10 :below 20) 20))`,
+		`(_maybe? (= (number-in-range :above 0) 5))`,
+		`(not (= (number-in-range :above 0) 0))`,
+		`(_maybe? (= (number-in-range :below 0) -5))`,
+		`(not (= (number-in-range :below 0) 5))`,
 		`(_maybe? (= (any-of 0 1) 0))`,
 		`(_maybe? (= (dec (any-of 0 1)) 0))`,
 		`(not (= (dec (dec (any-of 0 1))) 0))`,
@@ -317,6 +330,25 @@ func TestExpressionsTruthy(t *testing.T) {
 		`(= (list (list 1 2) (list 1 3)) (possible-values (any-of (list 1 2) (list 1 2) (list 1 3))))`,
 		`(= (list 1 2 3 4 5 6) (list* 1 2 3 (list 4 5 6)))`,
 		`(= (letfunc ((f (x y z) (+ y (* x z)))) (f 2 3 4)) 11)`,
+		`(letfunc* ((my-fact (n) (if (= 0 n) 1 (* n (my-fact (- n 1)))))) (= (my-fact 5) 120))`,
+		`(letfunc ((my-even? (n) (if (= n 0) true (my-odd? (- n 1)))) (my-odd? (n) (if (= n 0) false (my-even? (- n 1))))) (and (my-even? 10) (my-odd? 7)))`,
+		`(= (labels ((f (n) (if (= n 0) nil (cons n (f (- n 1)))))) (f 3)) '(3 2 1))`,
+		`(= (letrec ((f (lambda (n) (if (= n 0) 1 (* n (f (- n 1)))))) (x (f 4))) x) 24)`,
+		`(= (letrec ((f (lambda () g)) (g 42)) (f)) 42)`,
+		`(= (let ((f (lambda () 'outer))) (labels ((f () 'inner) (g () (f))) (g))) 'inner)`,
+		`(= (middle '(1 2 3 4 5)) 3)`,
+		`(= (let loop ((i 0) (acc nil)) (if (= i 3) acc (loop (+ i 1) (cons i acc)))) '(2 1 0))`,
+		`(simply-equal? (list 2 3 4) (possible-values (let loop ((i 0)) (if (>= i (any-of 2 4)) i (loop (+ i 1))))))`,
+		`(simply-equal? (list 2 21 22) (possible-values ((lambda (x) (cond ((= x 1) 1) ((= x 2) 2) ((= x 3) 3) ((= x 4) 4) ((= x 5) 5) ((= x 6) 6) ((= x 7) 7) ((= x 8) 8) ((= x 9) 9) ((= x 10) 10) ((= x 11) 11) ((= x 12) 12) ((= x 13) 13) ((= x 14) 14) ((= x 15) 15) ((= x 16) 16) ((= x 17) 17) ((= x 18) 18) ((= x 19) 19) ((= x 20) 20) ((= x 21) 21) (true 22))) (any-of 2 21 40))))`,
+		`(= (do ((i 0 (+ i 1)) (acc nil (cons i acc))) ((= i 3) acc)) '(2 1 0))`,
+		`(= (do ((i 0 (+ i 1)) (n 7)) ((= i n) (* i 2))) 14)`,
+		`(= (do ((i 0 (+ i 1))) ((= i 100000) i)) 100000)`,
+		`(nil? (do ((i 0 (+ i 1))) ((= i 3))))`,
+		`(simply-equal? (list 2 3 4) (possible-values (do ((i 0 (+ i 1))) ((>= i (number-in-range :from 2 :below 4)) i))))`,
+		`(simply-equal? (list 1 2 3) (possible-values (do ((i 0 (+ i 1))) ((>= i (any-of 1 3)) i))))`,
+		`(= 'unknown (_type (do ((i 0 (+ i 1))) ((= i (any-of 5 (number-in-range :from 0))) i))))`,
+		`(may-throw? (do ((i 0 (+ i 1))) ((if (= i 3) true (if (= i 1) (if maybe false (/ 1 0)) false)) i)))`,
+		`(= 3 (try (do ((i 0 (+ i 1))) ((if (= i 3) true (if (= i 1) (if maybe false (/ 1 0)) false)) i)) (catch (division-by-zero e) 3)))`,
 		`(= (list 1 2 3 4 5 6) (append (list 1 2) (list 3 4) (list 5 6)))`,
 		`(= 3 (let* ((x 0) (y (inc x)) (z (inc y)) (z (+ z y))) z))`,
 		`(= (list 1 2 3 4 5 6 7 8 9) (sorted (list 7 3 2 1 5 4 9 8 6)))`,
@@ -324,6 +356,201 @@ func TestExpressionsTruthy(t *testing.T) {
 		`(= (list) (sorted nil))`,
 		`(= (list 1) (sorted (list 1)))`,
 		`(= (list 1 2 3) (append nil (list 1 2 3)))`,
+		`(= (cons 1 (cons 2 3)) (append (list 1) (list 2) 3))`,
+		`(nil? (append))`,
+		`(simply-equal? (list (list 3) (list 1 2 3)) (possible-values (append (any-of nil (list 1 2)) (list 3))))`,
+		`(simply-equal? (list (list 1 3) (list 3)) (possible-values (append (filter (lambda (x) maybe) (list 1)) (list 3))))`,
+		`(= (list 1 2 3) (list* (list 1 2 3)))`,
+		`(= 2 (nth 1 (list 1 2 3)))`,
+		`(nil? (nth 3 (list 1 2 3)))`,
+		`(simply-equal? (list 1 3) (possible-values (nth (any-of 0 2) (list 1 2 3))))`,
+		`(simply-equal? (list 2 3) (possible-values (nth 1 (filter (lambda (x) (not (= x (any-of 1 9)))) (list 1 2 3)))))`,
+		`(= (list 1 2) (take 2 (list 1 2 3)))`,
+		`(= (list 1 2 3) (take 5 (list 1 2 3)))`,
+		`(nil? (take 0 (list 1 2 3)))`,
+		`(simply-equal? (list (list 1 2) (list 2 3)) (possible-values (take 2 (filter (lambda (x) (not (= x (any-of 1 9)))) (list 1 2 3)))))`,
+		`(= (list 3) (drop 2 (list 1 2 3)))`,
+		`(nil? (drop 5 (list 1 2 3)))`,
+		`(simply-equal? (list (list 2 3) (list 3)) (possible-values (drop 1 (filter (lambda (x) (not (= x (any-of 1 9)))) (list 1 2 3)))))`,
+		`(= 5 (length (take 5 (range 10))))`,
+		`(= (list 1 1 2 2 3) (sorted (list 2 1 3 1 2)))`,
+		`(= (list 1.5 2 3) (sorted (list 3 1.5 2)))`,
+		`(simply-equal? (list (list 1 2) (list 3 4)) (possible-values (sorted (any-of (list 2 1) (list 4 3)))))`,
+		`(let ((xs (sorted (filter (lambda (x) (not (= x (any-of 5 9)))) (list 5 1 7)))))
+		   (and (= 1 (first xs))
+		        (simply-equal? (list 3 2) (possible-values (length xs)))
+		        (simply-equal? (list 5 7) (possible-values (nth 1 xs)))))`,
+		`(simply-equal? (list (list 1 3) (list 3 5)) (possible-values (sorted (list (any-of 1 5) 3))))`,
+		`(simply-equal? (list (list 1 2 3) (list 2 3 5)) (possible-values (sorted (list 3 (any-of 1 5) 2))))`,
+		`(simply-equal? (list (list 1 5) (list 1 6)) (possible-values (sorted (list (any-of 5 6) 1))))`,
+		`(simply-equal? (list (list 1 2) (list 2 2) (list 1 3) (list 2 3)) (possible-values (sorted (list (any-of 1 2) (any-of 2 3)))))`,
+		`(= 2 (length (possible-values (sorted (list (number-in-range :from 1 :to 10) 5 20)))))`,
+		`(let ((xs (sorted (list (any-of 1 2 3 4 5 6 7 8 9 10) (any-of 1 2 3 4 5 6 7 8 9 10) (any-of 1 2 3 4 5 6 7 8 9 10) 4))))
+		   (and (= 4 (length xs))
+		        (simply-equal? (list 1 2 3 4) (possible-values (nth 0 xs)))
+		        (simply-equal? (list 4 5 6 7 8 9 10) (possible-values (nth 3 xs)))))`,
+		`(let ((xs (sorted (list (number-in-range :from 1 :to 10) (number-in-range :from 1 :to 10) (number-in-range :from 1 :to 10) (number-in-range :from 1 :to 10) 5))))
+		   (and (= 5 (length xs))
+		        (_maybe? (= 1 (nth 0 xs)))
+		        (not (= 6 (nth 0 xs)))
+		        (not (= 4 (nth 4 xs)))))`,
+		`(= (list 3 2 1) (sort-by (lambda (x) (- 0 x)) (list 1 3 2)))`,
+		`(= (list (list 1 'b) (list 2 'a) (list 2 'c)) (sort-by car (list (list 2 'a) (list 1 'b) (list 2 'c))))`,
+		`(simply-equal? (list (list 'a 'b) (list 'b 'a)) (possible-values (sort-by (lambda (x) (if (= x 'a) (any-of 1 5) 3)) (list 'a 'b))))`,
+		`(simply-equal? (list (list 'a 'b) (list 'b 'a)) (possible-values (sort-by (lambda (x) (if (= x 'a) (any-of 2 5) 2)) (list 'a 'b))))`,
+		`(simply-equal? (list (list 'b 'a) (list 'a 'b)) (possible-values (sort-by (lambda (x) (if (= x 'b) (any-of 0 2) 2)) (list 'a 'b))))`,
+		`(let ((xs (sort-by (lambda (x) (any-of 0 1 2 3 4 5 6 7 8 9)) (list 1 2 3 4))))
+		   (and (= 4 (length xs))
+		        (simply-equal? (list 1 2 3 4) (possible-values (nth 0 xs)))))`,
+		`(= 3 (string-length "abc"))`,
+		`(= 2 (string-length "hé"))`,
+		`(simply-equal? (list 1 3) (possible-values (string-length (any-of "a" "bcd"))))`,
+		`(= "el" (substring "hello" 1 3))`,
+		`(= "llo" (substring "hello" 2))`,
+		`(simply-equal? (list "hello" "llo") (possible-values (substring "hello" (any-of 0 2))))`,
+		`(= "abc" (string-append "a" "b" "c"))`,
+		`(= "" (concat))`,
+		`(simply-equal? (list "abd" "acd") (possible-values (concat "a" (any-of "b" "c") "d")))`,
+		`(= (list "a" "b" "" "c") (split "a,b,,c" ","))`,
+		`(= (list "a" "b" "c") (split "  a b  c "))`,
+		`(= "a, b, c" (join (list "a" "b" "c") ", "))`,
+		`(= "abc" (join (list "a" "b" "c")))`,
+		`(= "" (join nil ","))`,
+		`(= "a,b" (join (split "a,b" ",") ","))`,
+		`(simply-equal? (list "a-b" "b") (possible-values (join (filter (lambda (x) (not (= x (any-of "a" "z")))) (list "a" "b")) "-")))`,
+		`(simply-equal? (list "a-b" "a+b") (possible-values (join (list "a" "b") (any-of "-" "+"))))`,
+		`(= "HÉLLO" (string-upcase "héllo"))`,
+		`(= "hello" (string-downcase "HeLLo"))`,
+		`(= "x y" (trim "  x y "))`,
+		`(= 2 (string-index "héllo" "l"))`,
+		`(= nil (string-index "abc" "z"))`,
+		`(= (list "a" "b" "c") (string->list "abc"))`,
+		`(= nil (string->list ""))`,
+		`(= 'foo (string->symbol "foo"))`,
+		`(= "foo" (symbol->string 'foo))`,
+		`(simply-equal? (list "1" "2.5") (possible-values (number->string (any-of 1 2.5))))`,
+		`(= "1/3" (number->string (/ 1 3)))`,
+		`(= 3.0 (string->number (number->string 3.0)))`,
+		`(= 42 (string->number "42"))`,
+		`(= nil (string->number "forty-two"))`,
+		`(= 3 (abs -3))`,
+		`(= 3/2 (abs -3/2))`,
+		`(_dumb-equals? (number-in-range :from 0 :to 5) (abs (number-in-range :from -5 :to 3)))`,
+		`(_dumb-equals? (number-in-range :above 3 :to 5) (abs (number-in-range :from -5 :below -3)))`,
+		`(simply-equal? (list 4 9) (possible-values (sqr (any-of -2 2 3))))`,
+		`(_dumb-equals? (number-in-range :from 0 :to 25) (sqr (number-in-range :from -5 :to 3)))`,
+		`(and (= 2 (floor 5/2)) (= -3 (floor -5/2)) (= 2 (floor 2.5)))`,
+		`(and (= 3 (ceiling 5/2)) (= -2 (ceiling -5/2)) (= 3 (ceiling 2.1)))`,
+		`(and (= 2 (round 5/2)) (= 4 (round 7/2)) (= 2 (round 2.5)) (= -3 (round -2.6)))`,
+		`(and (= 2 (truncate 5/2)) (= -2 (truncate -5/2)) (= -2 (truncate -2.7)))`,
+		`(simply-equal? (list 2 3 4 5) (possible-values (ceiling (number-in-range :from 1.5 :to 4.2))))`,
+		`(_atom-eq? 'integer (type (floor (number-in-range :from 0 :to 1000))))`,
+		`(and (= 4 (sqrt 16)) (= 3/2 (sqrt 9/4)) (_atom-eq? 'floating-point (type (sqrt 2))))`,
+		`(_dumb-equals? (number-in-range :from 0 :to 3) (sqrt (number-in-range :from 0 :to 9)))`,
+		`(may-throw? (sqrt (number-in-range :from -1 :to 4)))`,
+		`(not (may-throw? (sqrt (number-in-range :from 0 :to 4))))`,
+		`(= 1267650600228229401496703205376 (expt 2 100))`,
+		`(= 9/4 (expt 2/3 -2))`,
+		`(= 1 (expt 5 0))`,
+		`(_dumb-equals? (number-in-range :from 0 :to 9) (expt (number-in-range :from -3 :to 2) 2))`,
+		`(_dumb-equals? (number-in-range :from -27 :to 8) (expt (number-in-range :from -3 :to 2) 3))`,
+		`(_dumb-equals? (number-in-range :from 1 :to 8) (expt (number-in-range :from 1 :to 2) (number-in-range :from 1 :to 3)))`,
+		`(simply-equal? (list 4 8) (possible-values (expt 2 (any-of 2 3))))`,
+		`(= 1.0 (exp 0))`,
+		`(_maybe? (= 2 (exp (number-in-range :from 0 :to 1))))`,
+		`(not (= 3 (exp (number-in-range :from 0 :to 1))))`,
+		`(= 0.0 (log 1))`,
+		`(may-throw? (log (number-in-range :from 0 :to 1)))`,
+		`(not (may-throw? (log (number-in-range :above 0 :to 1))))`,
+		`(_maybe? (= -1000 (log (number-in-range :above 0 :to 1))))`,
+		`(not (= 1 (log (number-in-range :above 0 :to 1))))`,
+		`(not (= -0.5 (sin (number-in-range :from 0 :to 2))))`,
+		`(_maybe? (= 1 (sin (number-in-range :from 0 :to 2))))`,
+		`(_maybe? (= -1 (sin (number-in-range :from 0 :to 10))))`,
+		`(_maybe? (= 1 (cos (number-in-range :from -1 :to 1))))`,
+		`(not (= 0.5 (cos (number-in-range :from 1.5 :to 3))))`,
+		`(not (= 2 (atan (number-in-range :from 0))))`,
+		`(= 1 (min 3 1 2))`,
+		`(= 3 (max 3 1 2))`,
+		`(simply-equal? (list 3 5) (possible-values (max 3 (any-of 1 5))))`,
+		`(_dumb-equals? (number-in-range :from 0 :to 5) (min (number-in-range :from 0 :to 10) 5))`,
+		`(_dumb-equals? (number-in-range :from 5 :to 10) (max (number-in-range :from 0 :to 10) 5))`,
+		`(and (= 6 (gcd 12 18)) (= 4 (gcd -4)) (= 0 (gcd)))`,
+		`(and (= 12 (lcm 4 6)) (= 60 (lcm 4 6 10)) (= 0 (lcm 0 3)) (= 1 (lcm)))`,
+		`(simply-equal? (list 1 2 3 4) (possible-values (gcd (number-in-range :from 1 :to 5) 12)))`,
+		`(simply-equal? (list 1 2 3 4 5) (possible-values (low-level-gcd (number-in-range :from 1 :to 5) 0)))`,
+		`(= 4 (mod 36893488147419103232 7))`,
+		`(= 5270498306774157604 (quotient 36893488147419103232 7))`,
+		`(= 36893488147419103232 (+ (* 7 (quotient 36893488147419103232 7)) (mod 36893488147419103232 7)))`,
+		`(and (= -1 (mod -7 2)) (= -3 (quotient -7 2)) (= 1 (remainder 7 -2)))`,
+		`(and (= 1/2 (mod 7/2 1)) (= 3 (quotient 7/2 1)))`,
+		`(and (= 1.5 (mod 5.5 2)) (= 3 (quotient 7.5 2)))`,
+		`(= 9223372036854775808 (quotient -9223372036854775808 -1))`,
+		`(simply-equal? (list 4 1) (possible-values (mod 36893488147419103232 (any-of 7 36893488147419103231))))`,
+		`(_dumb-equals? (number-in-range :from 0 :below 7) (mod (number-in-range :from 0 :to 100) 7))`,
+		`(_dumb-equals? (number-in-range :from 2 :to 5) (mod (number-in-range :from 2 :to 5) 7))`,
+		`(_dumb-equals? (number-in-range :from -3 :below 7) (mod (number-in-range :from -3 :to 100) (number-in-range :from 5 :to 7)))`,
+		`(= 15 (length (possible-values (quotient (number-in-range :from 0 :to 100) 7))))`,
+		`(< -9223372036854775808 9223372036854775807)`,
+		`(not (= 0.1 1/10))`,
+		`(< 1/10 0.1)`,
+		`(< 36893488147419103232 36893488147419103233)`,
+		`(not (= 36893488147419103233 36893488147419103232.0))`,
+		`(_maybe? (= (number-in-range :from 1/3 :to 1/2) 0.4))`,
+		`(not (= (number-in-range :from 36893488147419103232 :to 36893488147419103233) 36893488147419103234))`,
+		`(_maybe? (= (number-in-range :from 36893488147419103232 :to 36893488147419103233) 36893488147419103233))`,
+		`(_atom-eq? "#number-in-range([1/3,36893488147419103233])" (_to-string (number-in-range :from 1/3 :to 36893488147419103233)))`,
+		`(_atom-eq? "#number-in-range([4/3,36893488147419103234])" (_to-string (+ 1 (number-in-range :from 1/3 :to 36893488147419103233))))`,
+		`(and (= 1 (expt 1 36893488147419103232)) (= -1 (expt -1 36893488147419103233)))`,
+		`(= 'not-implemented (try (expt 3 1000000000) (catch (e) (condition-kind e))))`,
+		`(= 0 (mod (expt 10 300000) 1000))`,
+		`(_dumb-equals? (number-in-range :from 1) (+ (number-in-range :from 0) 1))`,
+		`(_dumb-equals? (number-in-range :above 2) (- 5 (number-in-range :below 3)))`,
+		`(_dumb-equals? (number-in-range :to -2) (* (number-in-range :from 1) -2))`,
+		`(_dumb-equals? (number-in-range :above 0 :to 1) (/ 1 (number-in-range :from 1)))`,
+		`(_dumb-equals? (number-in-range :from 1/2) (/ 1 (number-in-range :above 0 :to 2)))`,
+		`(_maybe? (= 2/3 (* (number-in-range :from 0 :to 2/3) 1.0)))`,
+		`(_maybe? (= 1/2 (number-in-range :from 0 :to 1)))`,
+		`(may-throw? (/ 1 (number-in-range :from -1 :to 1)))`,
+		`(not (must-throw? (/ 1 (number-in-range :from -1 :to 1))))`,
+		`(not (may-throw? (/ 1 (number-in-range :above 0 :to 1))))`,
+		`(_maybe? (= 1000 (/ 1 (number-in-range :above 0 :to 1))))`,
+		`(_maybe? (= -1000 (/ 1 (number-in-range :from -1 :below 0))))`,
+		`(may-throw? (/ (number-in-range :from -1 :to 1) (number-in-range :from -1 :to 1)))`,
+		`(= 0 (try (/ 0 (number-in-range :from -1 :to 1)) (catch (division-by-zero e) 0)))`,
+		`(_maybe? (= (number-in-range :from 1) (number-in-range :from 2)))`,
+		`(may-throw? (< 1 (/ 1 (number-in-range :from 0 :to 2))))`,
+		`(_maybe? (>= (number-in-range :from 0) 3))`,
+		`(_maybe? (< (number-in-range :to 0) (number-in-range :to -1)))`,
+		`(_maybe? (= 0 (any-of (number-in-range :to 1) (number-in-range :to 1))))`,
+		`(= 8 (bit-and 12 10))`,
+		`(= 14 (bit-or 12 10))`,
+		`(= 6 (bit-xor 12 10))`,
+		`(= 2 (bit-and 14 11 7))`,
+		`(and (= -1 (bit-and)) (= 0 (bit-or)) (= 0 (bit-xor)))`,
+		`(= -6 (bit-not 5))`,
+		`(= 7 (bit-and -1 7))`,
+		`(= 48 (shift-left 3 4))`,
+		`(= -4 (shift-right -7 1))`,
+		`(= 1 (shift-left 3 -1))`,
+		`(= 36893488147419103232 (shift-left 1 65))`,
+		`(and (= 8 (popcount 255)) (= 0 (popcount -1)) (= 1 (popcount -2)))`,
+		`(simply-equal? (list 1 0) (possible-values (bit-and (any-of 1 2 3) 1)))`,
+		`(simply-equal? (list 0 2 4 6) (possible-values (bit-and (number-in-range :from 0 :to 10) 6)))`,
+		`(_atom-eq? "#number-in-range([0,255] [integer])" (_to-string (bit-and (number-in-range :from 0 :to 100000) 255)))`,
+		`(_atom-eq? "#number-in-range([0,255] [integer])" (_to-string (bit-and (number-in-range :from -100000 :to 100000) 255)))`,
+		`(_atom-eq? "#number-in-range([1024,2047] [integer])" (_to-string (bit-or (number-in-range :from 0 :to 1000) 1024)))`,
+		`(_atom-eq? "#number-in-range((-inf,-1] [integer])" (_to-string (bit-not (number-in-range :from 0))))`,
+		`(_atom-eq? "#number-in-range([1,8000] [integer])" (_to-string (shift-left (number-in-range :from 1 :to 1000) (number-in-range :from 0 :to 3))))`,
+		`(not (= 18 (popcount (number-in-range :from 0 :to 100000))))`,
+		`(and (= 44 (u8+ 200 100)) (= 255 (u8- 0 1)) (= 0 (u8* 16 16)))`,
+		`(and (= -56 (i8+ 100 100)) (= 127 (i8- -128 1)) (= -128 (i8 128)))`,
+		`(and (= 0 (i32* 65536 65536)) (= 18446744073709551615 (u64 -1)) (= 65535 (u16 -1)))`,
+		`(simply-equal? (list 254 255 0 1) (possible-values (u8+ (number-in-range :from 253 :to 256) 1)))`,
+		`(_atom-eq? "#number-in-range([0,255] [integer])" (_to-string (u8 (number-in-range :from 0 :to 1000))))`,
+		`(= 2 (length (possible-values (i8 (number-in-range :from 100 :to 200)))))`,
+		`(not (= 0 (i8 (number-in-range :from 100 :to 200))))`,
+		`(_maybe? (= -100 (i8 (number-in-range :from 100 :to 200))))`,
 		`(let ((result (fold-left (lambda (x y) (if (> (* x y) 10) 10 (* x y))) 1 (filter (lambda (x) maybe) (list 2 3 6 7 8 9 10 11 12 13 14)))))
 		   (and (_maybe? (= 2 result))
 			      (not (= 5 result))))`,
@@ -338,6 +565,198 @@ func TestExpressionsTruthy(t *testing.T) {
 		`(not (contains-duplicates? (list 1 2 3 4 5 (any-of 6 7))))`,
 		`(_maybe? (contains-duplicates? (list 1 2 3 4 5 (any-of 6 7) (any-of 7 8))))`,
 		`(_maybe? (contains-duplicates? (list 1 2 3 4 5 unknown)))`,
+		`(= :foo :foo)`,
+		`(not (= :foo :bar))`,
+		`(not (= :foo 'foo))`,
+		`(symbol? :foo)`,
+		`(= (list :a 1) (list :a 1))`,
+		`(= 3 ((lambda (&key a b) (+ a b)) :a 1 :b 2))`,
+		`(= 3 ((lambda (&key a b) (+ a b)) :b 2 :a 1))`,
+		`(= 11 ((lambda (&key a (b 10)) (+ a b)) :a 1))`,
+		`(nil? ((lambda (&key a) a)))`,
+		`(= 7 ((lambda (x &optional (y 2) &key (z 3)) (+ x y z)) 1 3 :z 3))`,
+		`(= (list :z 3) ((lambda (&rest xs &key z) xs) :z 3))`,
+		`(defun! keyword-test-fn (x &key (scale 1) (offset 0)) (+ offset (* x scale)))`,
+		`(= 25 (keyword-test-fn 5 :scale 4 :offset 5))`,
+		`(= 5 (keyword-test-fn 5))`,
+		`(symbol? (gensym))`,
+		`(not (= (gensym) (gensym)))`,
+		`(let ((g (gensym "x"))) (and (= g g) (not (= g 'x1))))`,
+		`(defmacro! gensym-test-swap (a b) (let ((tmp (gensym))) ` + "`" + `(let ((,tmp ,a)) (list ,b ,tmp))))`,
+		`(= '(2 1) (let ((tmp 1)) (gensym-test-swap tmp 2)))`,
+		`(defmacro! auto-gensym-test-swap (a b) ` + "`" + `(let ((tmp# ,a)) (list ,b tmp#)))`,
+		`(= '(2 1) (let ((tmp 1)) (auto-gensym-test-swap tmp 2)))`,
+		`(= '(2 1) (let ((tmp# 2)) (auto-gensym-test-swap 1 tmp#)))`,
+		`(not (= (quasiquote x#) (quasiquote x#)))`,
+		`(= 'x# (quote x#))`,
+		`(defmacro! hygiene-test-swap (a b) ` + "`" + `(let ((tmp ,a)) (list ,b tmp)))`,
+		`(= '(2 1) (let ((tmp 1)) (hygiene-test-swap tmp 2)))`,
+		`(defmacro! hygiene-test-repeat (n x) ` + "`" + `(do ((i 0 (+ i 1)) (acc nil (cons ,x acc))) ((= i ,n) acc)))`,
+		`(= '(10 10) (let ((i 10)) (hygiene-test-repeat 2 i)))`,
+		`(defmacro! hygiene-test-catch (x) ` + "`" + `(try ,x (catch (e) 'caught)))`,
+		`(= 'caught (let ((e 5)) (hygiene-test-catch (/ e 0))))`,
+		`(defmacro! hygiene-test-quoted (x) ` + "`" + `(let ((y ,x)) (list 'y y)))`,
+		`(= '(y 3) (hygiene-test-quoted 3))`,
+		`(defmacro! hygiene-test-free () ` + "`" + `(list x (let ((x 2)) x)))`,
+		`(= '(1 2) (let ((x 1)) (hygiene-test-free)))`,
+		`(defmacro! hygiene-test-shadow (xs) ` + "`" + `(list (first ,xs) (let ((first 1)) first)))`,
+		`(= '(5 1) (hygiene-test-shadow '(5 6)))`,
+		`(defmacro! hygiene-test-sequential (v) ` + "`" + `(let* ((a ,v) (b (+ a 1))) (list a b)))`,
+		`(= '(10 11) (let ((a 10)) (hygiene-test-sequential a)))`,
+		`(defmacro! hygiene-test-labels (v) ` + "`" + `(labels ((f (n) (if (= n 0) ,v (f (- n 1))))) (f 3)))`,
+		`(= 7 (let ((n 7)) (hygiene-test-labels n)))`,
+		`(= 5 (let ((progn 5)) (when true progn)))`,
+		`(= 1 (let ((if 1)) (unless false if)))`,
+		`(= 7 (let ((if 7)) (cond (false 1) (false 2) (true if))))`,
+		`(defun! hygiene-test-when (progn x) (when x progn))`,
+		`(= 3 (hygiene-test-when 3 true))`,
+		`(= (quasiquote (let ((x 1)) (if x 2))) (quote (let ((x 1)) (if x 2))))`,
+		`(= 'three (match 3 (1 'one) (3 'three) (_ 'other)))`,
+		`(= 'other (match 4 (1 'one) (3 'three) (_ 'other)))`,
+		`(= 5 (match 5 (x x)))`,
+		`(= 'str (match "a" (:a 'keyword) ("a" 'str)))`,
+		`(= 'k (match :a ("a" 'str) (:a 'k)))`,
+		`(= 'empty (match nil ((x &rest xs) 'nonempty) (nil 'empty)))`,
+		`(= 3 (match '(1 2) ((a b) (+ a b))))`,
+		`(= '(2 3) (match '(1 2 3) ((a &rest bs) bs)))`,
+		`(= 2 (match (cons 1 2) ((a . b) b)))`,
+		`(= 'quoted (match '(quote x) ('(quote x) 'quoted) (_ 'other)))`,
+		`(= 'sym (match 'x ('x 'sym) (_ 'other)))`,
+		`(= 'int (match 3 ((? string?) 'str) ((? integer? n) 'int)))`,
+		`(= 'nested (match '(1 (2 3)) ((1 (2 x)) 'nested) (_ 'other)))`,
+		`(= 3 (match '(1 (2 3)) ((1 (2 x)) x)))`,
+		`(simply-equal? (list 'two 'one) (possible-values (match (any-of '(1 2) '(1)) ((a b) 'two) ((a) 'one))))`,
+		`(simply-equal? (list 1 3) (possible-values (match (any-of '(1 2) '(3)) ((a b) a) ((a) a))))`,
+		`(simply-equal? (list 'one 'other) (possible-values (match (any-of 1 2) (1 'one) (_ 'other))))`,
+		`(simply-equal? (list 'nonempty 'empty) (possible-values (match (filter (lambda (x) maybe) '(1)) ((x) 'nonempty) (nil 'empty))))`,
+		`(simply-equal? (list 'a 'b) (possible-values (match (list (any-of 1 2)) ((1) 'a) (_ 'b))))`,
+		`(= 3 (let (((a b) '(1 2))) (+ a b)))`,
+		`(= 6 (let (((a (b c)) '(1 (2 3)))) (+ a b c)))`,
+		`(= '(2 3) (let (((_ &rest xs) '(1 2 3))) xs))`,
+		`(simply-equal? (list 1 3) (possible-values (let (((a _) (any-of '(1 2) '(3 4)))) a)))`,
+		`(simply-equal? (list '(1 2) '(3 4)) (possible-values (let (((a b) (any-of '(1 2) '(3 4)))) (list a b))))`,
+		`(may-throw? (let (((a b) (any-of (list 1 2) (list 3)))) (list a b)))`,
+		`(simply-equal? (list '(1 2) 'failed) (possible-values (try (let (((a b) (any-of (list 1 2) (list 3)))) (list a b)) (catch (e) 'failed))))`,
+		`(= 'no-matching-clause (try (let (((a b) '(1))) a) (catch (e) (condition-kind e))))`,
+		`(simply-equal? (list '(2 1) '(4 3)) (possible-values ((lambda ((a b)) (list b a)) (any-of '(1 2) '(3 4)))))`,
+		`(may-throw? ((lambda ((a b)) a) (any-of '(1 2) 5)))`,
+		`(= 7 ((lambda ((a b) c) (+ a b c)) '(1 2) 4))`,
+		`(defun! destructuring-test-fn ((x . y)) (list y x))`,
+		`(= '((2) 1) (destructuring-test-fn '(1 2)))`,
+		`(_maybe? (= (number-in-range :from 1 :to 10) 10))`,
+		`(= 'point (defstruct! point x y))`,
+		`(= 3 (point-x (make-point :x 3 :y 4)))`,
+		`(= 4 (point-y (make-point :x 3 :y 4)))`,
+		`(nil? (point-y (make-point :x 3)))`,
+		`(= 'point (type (make-point :x 1)))`,
+		`(point? (make-point))`,
+		`(not (point? 3))`,
+		`(not (point? '(1 2)))`,
+		`(_maybe? (point? (unknown-of-type 'point 'integer)))`,
+		`(not (point? (unknown-of-type 'integer 'string)))`,
+		`(_maybe? (point? (any-of (make-point) 3)))`,
+		`(= (make-point :x 1 :y 2) (make-point :x 1 :y 2))`,
+		`(not (= (make-point :x 1 :y 2) (make-point :x 1 :y 3)))`,
+		`(_maybe? (= (make-point :x 1 :y 2) (make-point :x (any-of 1 2) :y 2)))`,
+		`(= 5 (point-x (point-with (make-point :x 1 :y 2) :x 5)))`,
+		`(= 2 (point-y (point-with (make-point :x 1 :y 2) :x 5)))`,
+		`(= 1 (point-x (let ((p (make-point :x 1))) (point-with p :x 2) p)))`,
+		`(simply-equal? (list 1 2) (possible-values (point-x (any-of (make-point :x 1) (make-point :x 2)))))`,
+		`(simply-equal? (list 3) (possible-values (point-y (point-with (any-of (make-point :x 1) (make-point :x 2)) :y 3))))`,
+		`(defstruct! other-point x y)`,
+		`(not (= (make-point :x 1 :y 2) (make-other-point :x 1 :y 2)))`,
+		`(not (point? (make-other-point)))`,
+		`(= 1 (length (possible-values (any-of (make-point :x 1) (make-point :x 1)))))`,
+		`(= 3 (try 3 (catch (e) 4)))`,
+		`(= 'caught (try (/ 1 0) (catch (division-by-zero e) 'caught)))`,
+		`(= 'division-by-zero (try (/ 1 0) (catch (e) (condition-kind e))))`,
+		`(= "division by zero" (try (/ 1 0) (catch (e) (condition-message e))))`,
+		`(= 'unbound (try no-such-variable (catch (division-by-zero e) 'zero) (catch (unbound-variable e) 'unbound)))`,
+		`(= 'unexpected-value (try (map 3 '(1 2)) (catch (e) (condition-kind e))))`,
+		`(= 'not-implemented (try (reversed (any-of '(1) '(2 3))) (catch (e) (condition-kind e))))`,
+		`(= 'deep (try ((lambda (x) (let ((y (/ x 0))) y)) 1) (catch (division-by-zero e) 'deep)))`,
+		`(= 'caught (try ((lambda (x) x)) (catch (e) 'caught)))`,
+		`(= 'caught (try (car 1 2) (catch (e) 'caught)))`,
+		`(= 'wrong-arity (try ((lambda (x) x) 1 2) (catch (e) (condition-kind e))))`,
+		`(= 'wrong-arity (try (car) (catch (e) (condition-kind e))))`,
+		`(= 'no-matching-clause (try (match 3 (1 'one)) (catch (e) (condition-kind e))))`,
+		`(= "foo" (try (throw-exception "foo") (catch (exception e) e)))`,
+		`(= 'my-error (try (throw 'my-error "oops") (catch (my-error e) (condition-kind e))))`,
+		`(= "oops" (try (throw 'my-error "oops") (catch (other-error e) 'other) (catch (e) (condition-message e))))`,
+		`(condition? (try (throw 'my-error "oops") (catch (e) e)))`,
+		`(not (condition? "oops"))`,
+		`(= 'outer (try (try (/ 1 0) (catch (e) (rethrow))) (catch (division-by-zero e) 'outer)))`,
+		`(= 'outer (try (try (/ 1 0) (catch (my-error e) 'inner)) (catch (e) 'outer)))`,
+		`(= 'my-error (try (try (/ 1 0) (catch (e) (throw 'my-error "wrapped"))) (catch (e) (condition-kind e))))`,
+		`(= 1 (try 1 (finally 2)))`,
+		`(= 'caught (try (/ 1 0) (catch (e) 'caught) (finally 2)))`,
+		`(defun! try-test-finally-counter! () (set! try-test-finally-count (+ try-test-finally-count 1)))`,
+		`(= 0 (set! try-test-finally-count 0))`,
+		`(= 'caught (try (try (/ 1 0) (finally (try-test-finally-counter!))) (catch (e) 'caught)))`,
+		`(= 1 try-test-finally-count)`,
+		`(= 2 (try 2 (finally (try-test-finally-counter!))))`,
+		`(= 2 try-test-finally-count)`,
+		`(may-throw? (if maybe 1 (/ 1 0)))`,
+		`(not (must-throw? (if maybe 1 (/ 1 0))))`,
+		`(must-throw? (/ 1 0))`,
+		`(may-throw? (/ 1 0))`,
+		`(not (may-throw? (if maybe 1 2)))`,
+		`(not (must-throw? 1))`,
+		`(must-throw? (if maybe (/ 1 0) (/ 2 0)))`,
+		`(must-throw? (if true (/ 1 0) 1))`,
+		`(not (may-throw? (if false (/ 1 0) 1)))`,
+		`(may-throw? (+ 1 (if maybe 1 (/ 1 0))))`,
+		`(may-throw? (let ((x (if maybe 1 (/ 1 0)))) (* x 2)))`,
+		`(may-throw? (progn (if maybe 1 (/ 1 0)) 2))`,
+		`(may-throw? ((lambda () (if maybe 1 (/ 1 0)) 2)))`,
+		`(may-throw? (and maybe (/ 1 0)))`,
+		`(may-throw? (or maybe (/ 1 0)))`,
+		`(may-throw? (match (if maybe 1 (/ 1 0)) (1 'one)))`,
+		`(simply-equal? (list 1 'zero) (possible-values (try (if maybe 1 (/ 1 0)) (catch (division-by-zero e) 'zero))))`,
+		`(simply-equal? (list 2 0) (possible-values (try (+ 1 (if maybe 1 (/ 1 0))) (catch (e) 0))))`,
+		`(not (may-throw? (try (if maybe 1 (/ 1 0)) (catch (e) 0))))`,
+		`(may-throw? (try (if maybe 1 (/ 1 0)) (catch (unbound-variable e) 0)))`,
+		`(simply-equal? (list 1 2) (possible-values (handle-exception (if maybe 1 (throw-exception "x")) ((x) 2))))`,
+		`(may-throw? (handle-exception (if maybe 1 (/ 1 0)) ((x) 2)))`,
+		`(simply-equal? (list 1 2 3) (possible-values (try (if maybe 1 (if maybe (/ 1 0) (throw 'other "x"))) (catch (division-by-zero e) 2) (catch (other e) 3))))`,
+		`(simply-equal? (list 2 3) (possible-values (try (if maybe (/ 1 0) (throw 'other "x")) (catch (division-by-zero e) 2) (catch (other e) 3))))`,
+		`(may-throw? (try (if maybe (/ 1 0) (throw 'other "x")) (catch (division-by-zero e) 2)))`,
+		`(may-throw? (if maybe 1 (no-such-function 1)))`,
+		`(defun! memo-test-fib (n) (if (< n 2) n (+ (memo-test-fib (- n 1)) (memo-test-fib (- n 2)))))`,
+		`(set! memo-test-fib (memoize memo-test-fib))`,
+		`(= 23416728348467685 (memo-test-fib 80))`,
+		`(= 55 (memo-test-fib 10))`,
+		`(= 55 ((memoize (lambda (n) (memo-test-fib n)) :capacity 1) 10))`,
+		`(simply-equal? (list 2 3) (possible-values ((memoize (lambda (x) (+ x 1))) (any-of 1 2))))`,
+		`(let ((f (memoize (lambda (x) (list x x))))) (and (= '(1 1) (f 1)) (= '(1 1) (f 1)) (= '(1.0 1.0) (f 1.0))))`,
+		`(= 'floating-point (let ((f (memoize (lambda (x) (type x))))) (f 1) (f 1.0)))`,
+		`(let ((f (memoize (lambda (x) (_type x))))) (and (= 'any-of (f (any-of 1 2))) (= 'integer (f 1))))`,
+		`(= '((1 2) (1 2)) (let ((f (memoize (lambda (x) (list x x))))) (f '(1 2)) (f (list 1 2))))`,
+		`(= 'called ((lambda (if) (if 1 2 3)) (lambda (a b c) 'called)))`,
+		`(defun! compile-test-shadowing (x) (let ((and (lambda (a b) (+ a b x)))) (and 1 2)))`,
+		`(= 6 (compile-test-shadowing 3))`,
+		`(defun! compile-test-destructuring (x) (let (((if b) x)) (if b)))`,
+		`(= 20 (compile-test-destructuring (list (lambda (y) (* y 10)) 2)))`,
+		`(defun! compile-test-later () (compile-test-defined-later 1))`,
+		`(defun! compile-test-defined-later (x) (+ x 1))`,
+		`(= 2 (compile-test-later))`,
+		`(defun! compile-test-closure (n) (lambda (x) (if (< x n) 'below 'above)))`,
+		`(= '(below above) (map (compile-test-closure 3) '(1 5)))`,
+		`(defun! compile-test-malformed (x) (if x 1 (if)))`,
+		`(= 1 (compile-test-malformed true))`,
+		`(= '(2 1) ((lambda (x) (let ((x (+ x 1)) (y x)) (list x y))) 1))`,
+		`(= '(1 2 3) ((lambda (x) (let ((f (lambda (y) (let ((z 3)) (list x y z))))) (f 2))) 1))`,
+		`(= '(1 2 (3 4)) ((lambda ((a b) &rest r) (list a b r)) '(1 2) 3 4))`,
+		`(= '(1 5) ((lambda (&key (a 1) (b 2)) (list a b)) :b 5))`,
+		`(= 7 ((lambda (n) (labels ((f (k) (if (= k 0) n (f (- k 1))))) (f 3))) 7))`,
+		`(= 5 (set! lexical-test-global 5))`,
+		`(defun! lexical-test-get () lexical-test-global)`,
+		`(= 5 (lexical-test-get))`,
+		`(= 6 (progn (set! lexical-test-global 6) (lexical-test-get)))`,
+		`(defun! lexical-test-redefined (x) x)`,
+		`(defun! lexical-test-caller (x) (lexical-test-redefined x))`,
+		`(= 20 (progn (defun! lexical-test-redefined (x) (* x 10)) (lexical-test-caller 2)))`,
+		`(= 100 (progn (set! lexical-test-default 100) ((lambda (&optional (lexical-test-default lexical-test-default)) lexical-test-default))))`,
 	}
 
 	onExpression := func(category string, i int, s string, aspirational bool) {
@@ -393,7 +812,6 @@ func TestExpressionsTruthy(t *testing.T) {
 	// specifically for them).
 	thingsWeWouldLikeToBeTrue := []string{
 		`(contains-duplicates? (list 1 2 3 4 5 (any-of 4 5)))`,
-		`(letfunc* ((my-fact (n) (if (= 0 n) 1 (* n (my-fact (- n 1)))))) (= (my-fact 5) 120))`,
 	}
 
 	for i, s := range thingsWeWouldLikeToBeTrue {
@@ -401,6 +819,161 @@ func TestExpressionsTruthy(t *testing.T) {
 	}
 }
 
+func TestExpressionsError(t *testing.T) {
+	root := builtin.NewRootEnv()
+
+	exprs := []string{
+		"some-unbound-variable",
+		"((lambda (&key a) a) :b 1)",
+		"((lambda (&key a) a) :a 1 :a 2)",
+		"((lambda (&key a) a) :a)",
+		"((lambda (&key a) a) 'a 1)",
+		"(number-in-range :from 1 :upto 10)",
+		"(number-in-range :from 1 :from 2)",
+		"(number-in-range 'from 1 'to 10)",
+		"(string-length 5)",
+		"(substring \"abc\" 2 1)",
+		"(substring \"abc\" 4)",
+		"(concat \"a\" 'b)",
+		"(symbol->string \"a\")",
+		"(sqrt -1)",
+		"(log 0)",
+		"(sqrt (number-in-range :from -4 :below 0))",
+		"(gcd 1.5 2)",
+		"(expt 0 -1)",
+		"(abs 'a)",
+		"(floor NaN)",
+		"(mod 5 0)",
+		"(quotient 36893488147419103232 0)",
+		"(mod 5 (number-in-range :from -1 :to 1))",
+		"(bit-and 1.5 1)",
+		"(bit-or 'a 1)",
+		"(shift-left 1 100000)",
+		"(expt 3 1000000000)",
+		"(expt 1/2 -100000000)",
+		"(popcount (number-in-range :above 0 :below 1))",
+		"(wrap-unsigned 5 0)",
+		"(letrec ((x 1 2)) x)",
+		"(labels ((f (x))) (f 1))",
+		"(labels (((f) (x) x)) 1)",
+		"(do ((i 0 1 2)) ((= i 1) i))",
+		"(do ((i 0 (+ i 1))) ())",
+		"(match 3 (1 'one) (2 'two))",
+		"(match '(1 2) ((a) a))",
+		"(match '(1 2) ((a a) a))",
+		"(match 1 ((? 42) 'x))",
+		"(let (((a b) '(1 2 3))) a)",
+		"((lambda ((a b)) a) '(1))",
+		"(defstruct! duplicate-field-struct a a)",
+		"(defstruct! :keyword-struct a)",
+		"(defstruct! keyword-field-struct :a)",
+		"(progn (defstruct! error-struct a) (make-error-struct :b 1))",
+		"(progn (defstruct! error-struct a) (error-struct-a 3))",
+		"(progn (defstruct! error-struct a) (error-struct-a (any-of (make-error-struct) 3)))",
+		"(progn (defstruct! error-struct a) (error-struct-with (make-error-struct) :b 1))",
+		"(try (/ 1 0) (catch (unbound-variable e) e))",
+		"(try (/ 1 0) (catch (e) (rethrow)))",
+		"(try (/ 1 0) (finally 1))",
+		"(try 1 (finally (/ 1 0)))",
+		"(try 1 (finally 2) (catch (e) e))",
+		"(try (catch (e) e))",
+		"(try 1 (catch e e))",
+		"(try 1 (catch (a b c) e))",
+		"(rethrow)",
+		"(throw \"kind\" \"message\")",
+		"(condition-kind 3)",
+		"(if maybe (/ 1 0) (/ 2 0))",
+		"(may-throw? 1 2)",
+		"(may-throw? (lambda))",
+		"(memoize 3)",
+		"(progn (defun! compile-test-malformed-2 (x) (if x 1 (if))) (compile-test-malformed-2 false))",
+		"(memoize (lambda (x) x) :capacity 0)",
+		"(memoize (lambda (x) x) :size 10)",
+		"(progn (defun! memo-test-impure! (x) x) (memoize memo-test-impure!))",
+	}
+
+	for i, s := range exprs {
+		result, err := code.Run(root, fmt.Sprintf("<testcase %d>", i), []byte(s))
+		if err == nil {
+			t.Errorf("code.Run(..., %q) = %v, want error", s, result)
+		}
+	}
+}
+
+// TestErrorContext checks that errors name the function they happened in,
+// even after tail calls.
+func TestErrorContext(t *testing.T) {
+	root := builtin.NewRootEnv()
+
+	s := `(progn
+	  (defun! error-context-inner (x) (car x))
+	  (defun! error-context-outer (n)
+	    (if (= n 0)
+	        (error-context-inner 5)
+	        (error-context-outer (- n 1))))
+	  (error-context-outer 3))`
+	_, err := code.Run(root, "<testcase>", []byte(s))
+	if err == nil || !strings.Contains(err.Error(), "error-context-outer: ") || !strings.Contains(err.Error(), "error-context-inner: ") {
+		t.Errorf("code.Run(..., %q) = err: %v, want error in error-context-inner called from error-context-outer", s, err)
+	}
+}
+
+func TestModules(t *testing.T) {
+	oldSearchPath := module.SearchPath
+	module.SearchPath = []string{"./testdata/modules"}
+	defer func() { module.SearchPath = oldSearchPath }()
+
+	root := builtin.NewRootEnv()
+
+	// Evaluated in order; each must be truthy.
+	exprs := []string{
+		"(import geometry)",
+		"(= 6 (geometry/rectangle-area 2 3))",
+		"(= 16 (geometry/square-area 4))",
+		"(import geometry :as geo)",
+		"(= 9 (geo/square-area 3))",
+		`(require "./testdata/modules/uses-geometry.hlisp")`,
+		"(= 24 (uses-geometry/cube-surface 2))",
+		`(require "./testdata/modules/geometry.hlisp" :as g)`,
+		"(= 4 (g/square-area 2))",
+		"(import counter)",
+		"(set! first-token counter/token)",
+		"(import counter)",
+		"(= first-token counter/token)",
+		"(= 'counter (module counter (export token)))",
+	}
+
+	for i, s := range exprs {
+		result, err := code.Run(root, fmt.Sprintf("<testcase %d>", i), []byte(s))
+		if err != nil {
+			t.Fatalf("code.Run(..., %q) = err: %v", s, err)
+		}
+		if result.Falsey() {
+			t.Fatalf("code.Run(..., %q) = %v, want truthy", s, result)
+		}
+	}
+
+	errorExprs := []string{
+		"rectangle-area",
+		"geometry/private-helper",
+		"private-helper",
+		"(import no-such-module)",
+		"(import circular-a)",
+		"(import bad-export)",
+		"(import leaky)",
+		`(require "./testdata/modules/no-such-file.hlisp")`,
+		"(import geometry :alias g)",
+		"(module)",
+	}
+
+	for i, s := range errorExprs {
+		result, err := code.Run(root, fmt.Sprintf("<error testcase %d>", i), []byte(s))
+		if err == nil {
+			t.Errorf("code.Run(..., %q) = %v, want error", s, result)
+		}
+	}
+}
+
 var values = []string{
 	"123",
 	"3.14",
@@ -420,7 +993,7 @@ var values = []string{
 	"(any-of 1.5 2)",
 	"(list 1.5 2)",
 	"(unknown-of-type 'integer)",
-	"(number-in-range 'from 1 'to 42)",
+	"(number-in-range :from 1 :to 42)",
 	"NaN",
 	"1/2",
 	"36893488147419103232",
@@ -540,6 +1113,46 @@ func TestExamples(t *testing.T) {
 	}
 }
 
+// runSuites runs the tests that the TestWith... functions repeat in other
+// modes.
+func runSuites(t *testing.T) {
+	t.Run("ExpressionsTruthy", TestExpressionsTruthy)
+	t.Run("ExpressionsError", TestExpressionsError)
+	t.Run("ErrorContext", TestErrorContext)
+	t.Run("Modules", TestModules)
+	t.Run("UnaryInvariants", TestUnaryInvariants)
+	t.Run("BinaryInvariants", TestBinaryInvariants)
+	t.Run("Examples", TestExamples)
+}
+
+// TestWithVM runs the tests with function bodies compiled to bytecode.
+func TestWithVM(t *testing.T) {
+	compile.Bytecode = true
+	defer func() { compile.Bytecode = false }()
+
+	runSuites(t)
+}
+
+// TestWithParallelism runs the tests with possible worlds evaluated in
+// parallel where possible.
+func TestWithParallelism(t *testing.T) {
+	parallel.SetParallelism(4)
+	defer parallel.SetParallelism(1)
+
+	runSuites(t)
+	t.Run("WithVM", TestWithVM)
+}
+
+// TestWithHashConsing runs the tests with structurally identical values
+// shared.
+func TestWithHashConsing(t *testing.T) {
+	hashcons.Enabled = true
+	defer func() { hashcons.Enabled = false }()
+
+	runSuites(t)
+	t.Run("WithVM", TestWithVM)
+}
+
 func BenchmarkTuringBinaryCounter20(b *testing.B) {
 	turingSetupCode := []byte(`
 (defun! remove-top (tape)
@@ -38,6 +38,27 @@ func (u UnexpectedValue) Error() string {
 
 var DivisionByZero = errors.New("division by zero")
 
+// WrongArity is the error of calling a function with the wrong number of
+// arguments; Want describes how many it takes.
+type WrongArity struct {
+	Want string
+	Got  int
+}
+
+func (w WrongArity) Error() string {
+	return fmt.Sprintf("want %s, got %d", w.Want, w.Got)
+}
+
+// NoMatchingClause is the error of matching a value none of the clauses of
+// a match accept, or that does not match a destructuring pattern.
+type NoMatchingClause struct {
+	Value types.Value
+}
+
+func (n NoMatchingClause) Error() string {
+	return fmt.Sprintf("no clause matches %v", n.Value)
+}
+
 type UnboundVariable string
 
 func (u UnboundVariable) Error() string {
@@ -57,5 +78,5 @@ func Wrap(ctx string, err error) error {
 		}
 	}
 
-	return fmt.Errorf("%s: %v", ctx, err)
+	return fmt.Errorf("%s: %w", ctx, err)
 }
@@ -2,6 +2,7 @@ package listops
 
 import (
 	"github.com/steinarvk/heisenlisp/lisperr"
+	"github.com/steinarvk/heisenlisp/parallel"
 	"github.com/steinarvk/heisenlisp/types"
 	"github.com/steinarvk/heisenlisp/typeset"
 	"github.com/steinarvk/heisenlisp/unknown"
@@ -321,17 +322,35 @@ func Filter(f func(a types.Value) (types.Value, error), consish types.Value) (ty
 	return Reversed(rv)
 }
 
-func mapWithUncertainty(f func(types.Value) (types.Value, error), consish types.Value) (types.Value, error) {
+// mapWithUncertainty maps f over a list. If f is pure, independent calls
+// may be made in parallel.
+func mapWithUncertainty(f func(types.Value) (types.Value, error), consish types.Value, pure bool) (types.Value, error) {
 	if null.IsNil(consish) {
 		return null.Nil, nil
 	}
 
+	if pure {
+		if cars, tail := spine(consish); len(cars) > 1 {
+			carsMapped, err := parallel.Map(len(cars), func(i int) (types.Value, error) {
+				return f(cars[i])
+			})
+			if err != nil {
+				return nil, err
+			}
+			tailMapped, err := mapWithUncertainty(f, tail, pure)
+			if err != nil {
+				return nil, err
+			}
+			return cons.NewChain(carsMapped, tailMapped), nil
+		}
+	}
+
 	if car, cdr, ok := cons.Decompose(consish); ok {
 		carMapped, err := f(car)
 		if err != nil {
 			return nil, err
 		}
-		cdrMapped, err := mapWithUncertainty(f, cdr)
+		cdrMapped, err := mapWithUncertainty(f, cdr, pure)
 		if err != nil {
 			return nil, err
 		}
@@ -343,7 +362,7 @@ func mapWithUncertainty(f func(types.Value) (types.Value, error), consish types.
 		if err != nil {
 			return nil, err
 		}
-		cdrMapped, err := mapWithUncertainty(f, cdr)
+		cdrMapped, err := mapWithUncertainty(f, cdr, pure)
 		if err != nil {
 			return nil, err
 		}
@@ -356,10 +375,20 @@ func mapWithUncertainty(f func(types.Value) (types.Value, error), consish types.
 
 	values, _ := anyof.PossibleValues(consish)
 
+	if pure {
+		rv, err := parallel.Map(len(values), func(i int) (types.Value, error) {
+			return mapWithUncertainty(f, values[i], pure)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return anyof.New(rv)
+	}
+
 	var rv []types.Value
 
 	for _, realConsish := range values {
-		possible, err := mapWithUncertainty(f, realConsish)
+		possible, err := mapWithUncertainty(f, realConsish, pure)
 		if err != nil {
 			return nil, err
 		}
@@ -369,6 +398,26 @@ func mapWithUncertainty(f func(types.Value) (types.Value, error), consish types.
 	return anyof.New(rv)
 }
 
+// spine returns the cars of the conses at the start of a list, and the
+// rest of it.
+func spine(consish types.Value) ([]types.Value, types.Value) {
+	var cars []types.Value
+	for {
+		car, cdr, ok := cons.Decompose(consish)
+		if !ok {
+			return cars, consish
+		}
+		cars = append(cars, car)
+		consish = cdr
+	}
+}
+
 func Map(f func(a types.Value) (types.Value, error), consish types.Value) (types.Value, error) {
-	return mapWithUncertainty(f, consish)
+	return mapWithUncertainty(f, consish, false)
+}
+
+// MapPure is Map for a pure f, which may be called in parallel for
+// different elements and possible worlds (see package parallel).
+func MapPure(f func(a types.Value) (types.Value, error), consish types.Value) (types.Value, error) {
+	return mapWithUncertainty(f, consish, true)
 }
@@ -0,0 +1,289 @@
+package listops
+
+import (
+	"errors"
+
+	"github.com/steinarvk/heisenlisp/lisperr"
+	"github.com/steinarvk/heisenlisp/types"
+	"github.com/steinarvk/heisenlisp/value/cons"
+	"github.com/steinarvk/heisenlisp/value/integer"
+	"github.com/steinarvk/heisenlisp/value/null"
+	"github.com/steinarvk/heisenlisp/value/unknowns/anyof"
+	"github.com/steinarvk/heisenlisp/value/unknowns/numinrange"
+	"github.com/steinarvk/heisenlisp/value/unknowns/optcons"
+)
+
+// The functions in this file treat lists the way folds do: an optional cons
+// is either its cons or its cdr, and an any-of of lists is each of them.
+// Results are memoised by the list (by pointer, not a valuemap), since the
+// possible lists often share their tails.
+
+// Append returns the lists appended. The last list is not traversed, and
+// may be anything.
+func Append(lists []types.Value) (types.Value, error) {
+	if len(lists) == 0 {
+		return null.Nil, nil
+	}
+
+	rv := lists[len(lists)-1]
+	for i := len(lists) - 2; i >= 0; i-- {
+		var err error
+		rv, err = appendTwo(lists[i], rv)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return rv, nil
+}
+
+func appendTwo(xs, ys types.Value) (types.Value, error) {
+	memo := map[types.Value]types.Value{}
+
+	var app func(xs types.Value) (types.Value, error)
+	app = func(xs types.Value) (types.Value, error) {
+		if null.IsNil(xs) {
+			return ys, nil
+		}
+
+		if rv, ok := memo[xs]; ok {
+			return rv, nil
+		}
+
+		cars, tail := spine(xs)
+		if len(cars) > 0 {
+			rest, err := app(tail)
+			if err != nil {
+				return nil, err
+			}
+			rv := cons.NewChain(cars, rest)
+			memo[xs] = rv
+			return rv, nil
+		}
+
+		if car, cdr, ok := optcons.Decompose(xs); ok {
+			rest, err := app(cdr)
+			if err != nil {
+				return nil, err
+			}
+			rv := optcons.New(car, rest)
+			memo[xs] = rv
+			return rv, nil
+		}
+
+		if !anyof.Is(xs) {
+			return nil, lisperr.UnexpectedValue{"cons or enumerable", xs}
+		}
+
+		alternatives, _ := anyof.PossibleValues(xs)
+		var results []types.Value
+		for _, alternative := range alternatives {
+			rv, err := app(alternative)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, rv)
+		}
+		rv, err := anyof.New(results)
+		if err != nil {
+			return nil, err
+		}
+		memo[xs] = rv
+		return rv, nil
+	}
+
+	return app(xs)
+}
+
+// ListStar returns a list of all but the last value, ending with the last
+// value (which is usually a list) instead of nil.
+func ListStar(xs []types.Value) (types.Value, error) {
+	if len(xs) == 0 {
+		return nil, errors.New("list* needs at least one argument")
+	}
+	return cons.NewChain(xs[:len(xs)-1], xs[len(xs)-1]), nil
+}
+
+// indexes returns the possible values of an index or count (such as the n of
+// nth), which must be non-negative integers.
+func indexes(n types.Value) ([]int64, error) {
+	values, ok := anyof.PossibleValues(n)
+	if !ok {
+		return nil, lisperr.NotImplemented("index with non-enumerable uncertainty")
+	}
+
+	var rv []int64
+	for _, v := range values {
+		i, err := integer.ToInt64(v)
+		if err != nil || i < 0 {
+			return nil, lisperr.UnexpectedValue{"non-negative integer", v}
+		}
+		rv = append(rv, i)
+	}
+	return rv, nil
+}
+
+// byIndex applies f to each possible value of the index n, and returns the
+// possible results.
+func byIndex(n types.Value, f func(i int64) (types.Value, error)) (types.Value, error) {
+	is, err := indexes(n)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []types.Value
+	for _, i := range is {
+		rv, err := f(i)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, rv)
+	}
+	return anyof.New(results)
+}
+
+type indexedList struct {
+	i  int64
+	xs types.Value
+}
+
+// walk implements nth, take and drop, following the cdrs of a list from the
+// index n down. The walk stops where end says so, with its result; at a cons,
+// step returns the result, calling next for the result of the rest.
+func walk(n types.Value, xs types.Value, step func(i int64, car, cdr types.Value, next func() (types.Value, error)) (types.Value, error), end func(i int64, xs types.Value) (types.Value, bool)) (types.Value, error) {
+	return byIndex(n, func(start int64) (types.Value, error) {
+		memo := map[indexedList]types.Value{}
+
+		var w func(i int64, xs types.Value) (types.Value, error)
+		w = func(i int64, xs types.Value) (types.Value, error) {
+			if rv, done := end(i, xs); done {
+				return rv, nil
+			}
+
+			key := indexedList{i, xs}
+			if rv, ok := memo[key]; ok {
+				return rv, nil
+			}
+
+			var rv types.Value
+			var err error
+
+			if car, cdr, ok := cons.Decompose(xs); ok {
+				rv, err = step(i, car, cdr, func() (types.Value, error) {
+					return w(i-1, cdr)
+				})
+			} else if alternatives, ok := anyof.PossibleValues(xs); ok && (optcons.Is(xs) || anyof.Is(xs)) {
+				var results []types.Value
+				for _, alternative := range alternatives {
+					result, err := w(i, alternative)
+					if err != nil {
+						return nil, err
+					}
+					results = append(results, result)
+				}
+				rv, err = anyof.New(results)
+			} else {
+				err = lisperr.UnexpectedValue{"cons or enumerable", xs}
+			}
+
+			if err != nil {
+				return nil, err
+			}
+			memo[key] = rv
+			return rv, nil
+		}
+
+		return w(start, xs)
+	})
+}
+
+// Nth returns the element at index n of a list, or nil if it is too short.
+func Nth(n, xs types.Value) (types.Value, error) {
+	return walk(n, xs, func(i int64, car, _ types.Value, next func() (types.Value, error)) (types.Value, error) {
+		if i == 0 {
+			return car, nil
+		}
+		return next()
+	}, func(_ int64, xs types.Value) (types.Value, bool) {
+		return null.Nil, null.IsNil(xs)
+	})
+}
+
+// Take returns the first n elements of a list, or all of them if there are
+// fewer.
+func Take(n, xs types.Value) (types.Value, error) {
+	return walk(n, xs, func(_ int64, car, _ types.Value, next func() (types.Value, error)) (types.Value, error) {
+		rest, err := next()
+		if err != nil {
+			return nil, err
+		}
+		return cons.New(car, rest), nil
+	}, func(i int64, xs types.Value) (types.Value, bool) {
+		return null.Nil, i == 0 || null.IsNil(xs)
+	})
+}
+
+// Drop returns a list without its first n elements, or nil if there are
+// fewer.
+func Drop(n, xs types.Value) (types.Value, error) {
+	return walk(n, xs, func(_ int64, _, _ types.Value, next func() (types.Value, error)) (types.Value, error) {
+		return next()
+	}, func(i int64, xs types.Value) (types.Value, bool) {
+		if null.IsNil(xs) {
+			return null.Nil, true
+		}
+		return xs, i == 0
+	})
+}
+
+// Length returns the length of a list. A list ending in an unknown value
+// (which may be a list of any length) is at least as long as its conses.
+func Length(xs types.Value) (types.Value, error) {
+	memo := map[indexedList]types.Value{}
+
+	// length returns the length of xs plus the count of elements before it.
+	var length func(count int64, xs types.Value) (types.Value, error)
+	length = func(count int64, xs types.Value) (types.Value, error) {
+		cars, tail := spine(xs)
+		count += int64(len(cars))
+		xs = tail
+
+		if null.IsNil(xs) {
+			return integer.FromInt64(count), nil
+		}
+
+		key := indexedList{count, xs}
+		if rv, ok := memo[key]; ok {
+			return rv, nil
+		}
+
+		var rv types.Value
+		var err error
+
+		if optcons.Is(xs) || anyof.Is(xs) {
+			alternatives, _ := anyof.PossibleValues(xs)
+			var results []types.Value
+			for _, alternative := range alternatives {
+				result, err := length(count, alternative)
+				if err != nil {
+					return nil, err
+				}
+				results = append(results, result)
+			}
+			rv, err = anyof.New(results)
+		} else {
+			if _, ok := xs.(types.Unknown); !ok {
+				return nil, lisperr.UnexpectedValue{"valid list-tail", xs}
+			}
+			// TODO: do any sort of filtering of unknowns.
+			rv, err = numinrange.New(integer.FromInt64(count), nil, true, false, []string{integer.TypeName})
+		}
+
+		if err != nil {
+			return nil, err
+		}
+		memo[key] = rv
+		return rv, nil
+	}
+
+	return length(0, xs)
+}
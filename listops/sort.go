@@ -0,0 +1,452 @@
+package listops
+
+import (
+	"sort"
+
+	"github.com/steinarvk/heisenlisp/lisperr"
+	"github.com/steinarvk/heisenlisp/logic"
+	"github.com/steinarvk/heisenlisp/numcmp"
+	"github.com/steinarvk/heisenlisp/types"
+	"github.com/steinarvk/heisenlisp/value/cons"
+	"github.com/steinarvk/heisenlisp/value/null"
+	"github.com/steinarvk/heisenlisp/value/unknowns/anyof"
+	"github.com/steinarvk/heisenlisp/value/unknowns/fullyunknown"
+	"github.com/steinarvk/heisenlisp/value/unknowns/numinrange"
+	"github.com/steinarvk/heisenlisp/value/unknowns/optcons"
+)
+
+// MaxOrderings is the number of possible orderings of a list that sorting
+// keeps track of. Past it, the result is widened to a single list with the
+// possible values at each position.
+var MaxOrderings = 100
+
+// Less compares two values for sorting: whether a is (certainly, maybe or
+// certainly not) less than b.
+type Less func(a, b types.Value) (types.TernaryTruthValue, error)
+
+// element is an element of a list being sorted; optional if it is the car of
+// an optional cons.
+type element struct {
+	value    types.Value
+	key      types.Value
+	optional bool
+
+	// alternatives are the possible (value, key) pairs of the element: one
+	// for each possible key if it is an any-of, or else just one.
+	alternatives []alternative
+}
+
+type alternative struct {
+	value types.Value
+	key   types.Value
+}
+
+// entry is an alternative of an element in an ordering.
+type entry struct {
+	element     int
+	alternative int
+}
+
+// Sorted returns a list of numbers sorted (stably) by less. If the order of
+// some elements is uncertain, the result is an any-of of the possible sorted
+// lists, in which an element that is an any-of is replaced by the value it
+// has in each; an element that may be absent (the car of an optional cons)
+// remains so.
+//
+// If there are too many possible orderings, the result is instead a list of
+// the possible values at each position: the n'th smallest value is between
+// the n'th smallest lower bound and the n'th smallest upper bound.
+func Sorted(less Less, consish types.Value) (types.Value, error) {
+	return sortByKey(less, nil, consish)
+}
+
+// SortedBy is Sorted comparing the keys of elements (as given by key) rather
+// than the elements themselves. If there are too many possible orderings,
+// the result is a list of the elements that may be at each position.
+func SortedBy(less Less, key func(types.Value) (types.Value, error), consish types.Value) (types.Value, error) {
+	return sortByKey(less, key, consish)
+}
+
+func sortByKey(less Less, key func(types.Value) (types.Value, error), consish types.Value) (types.Value, error) {
+	lists, err := elementLists(consish)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []types.Value
+	for _, elements := range lists {
+		for i := range elements {
+			e := &elements[i]
+			e.key = e.value
+			if key != nil {
+				e.key, err = key(e.value)
+				if err != nil {
+					return nil, err
+				}
+			}
+			e.alternatives = alternativesOf(e, key == nil)
+		}
+
+		orderings, ok, err := sortedOrderings(less, elements)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			widened, err := widen(less, elements, key == nil)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, widened)
+			continue
+		}
+		for _, ordering := range orderings {
+			results = append(results, buildList(elements, ordering))
+		}
+	}
+	return anyof.New(results)
+}
+
+func alternativesOf(e *element, keyIsValue bool) []alternative {
+	if !anyof.Is(e.key) {
+		return []alternative{{e.value, e.key}}
+	}
+
+	keys, _ := anyof.PossibleValues(e.key)
+	var rv []alternative
+	for _, k := range keys {
+		if keyIsValue {
+			rv = append(rv, alternative{k, k})
+		} else {
+			rv = append(rv, alternative{e.value, k})
+		}
+	}
+	return rv
+}
+
+// elementLists returns the elements of each of the possible shapes of a list:
+// one for a list of conses and optional conses, more if it has any-ofs.
+func elementLists(consish types.Value) ([][]element, error) {
+	var elements []element
+	for {
+		if null.IsNil(consish) {
+			return [][]element{elements}, nil
+		}
+
+		if car, cdr, ok := cons.Decompose(consish); ok {
+			elements = append(elements, element{value: car})
+			consish = cdr
+			continue
+		}
+
+		if car, cdr, ok := optcons.Decompose(consish); ok {
+			elements = append(elements, element{value: car, optional: true})
+			consish = cdr
+			continue
+		}
+
+		if !anyof.Is(consish) {
+			return nil, lisperr.UnexpectedValue{"cons or enumerable", consish}
+		}
+
+		alternatives, _ := anyof.PossibleValues(consish)
+		var rv [][]element
+		for _, alternative := range alternatives {
+			tails, err := elementLists(alternative)
+			if err != nil {
+				return nil, err
+			}
+			for _, tail := range tails {
+				rv = append(rv, append(elements[:len(elements):len(elements)], tail...))
+			}
+			if int64(len(rv)) > anyof.MaxAnyOfElements {
+				return nil, lisperr.NotImplemented("sorting too many possible lists")
+			}
+		}
+		return rv, nil
+	}
+}
+
+func buildList(elements []element, ordering []entry) types.Value {
+	var rv types.Value = null.Nil
+	for i := len(ordering) - 1; i >= 0; i-- {
+		e := elements[ordering[i].element]
+		value := e.alternatives[ordering[i].alternative].value
+		if e.optional {
+			rv = optcons.New(value, rv)
+		} else {
+			rv = cons.New(value, rv)
+		}
+	}
+	return rv
+}
+
+// sortedOrderings returns the possible orderings of elements sorted stably by
+// less, or false if there are too many.
+func sortedOrderings(less Less, elements []element) ([][]entry, bool, error) {
+	var certain []entry
+	var uncertain []int
+	for i, e := range elements {
+		if _, ok := e.key.(types.Unknown); ok {
+			uncertain = append(uncertain, i)
+		} else {
+			certain = append(certain, entry{i, 0})
+		}
+	}
+
+	// Certain keys have a certain order (unless they are not comparable),
+	// so they can be sorted as usual first.
+	var sortErr error
+	sort.SliceStable(certain, func(i, j int) bool {
+		tv, err := less(elements[certain[i].element].key, elements[certain[j].element].key)
+		if err != nil && sortErr == nil {
+			sortErr = err
+		}
+		return tv == types.True
+	})
+	if sortErr != nil {
+		return nil, false, sortErr
+	}
+
+	orderings := [][]entry{certain}
+
+	// Each alternative of each uncertain element is inserted wherever it may
+	// be in each ordering: before the elements it may go before, and after
+	// the rest. As with a stable sort, it goes before an element with an
+	// equal key if it came first in the list.
+	for _, x := range uncertain {
+		var next [][]entry
+		for alt, xAlt := range elements[x].alternatives {
+			before := map[entry]types.TernaryTruthValue{}
+			compare := func(y entry) (types.TernaryTruthValue, error) {
+				if tv, ok := before[y]; ok {
+					return tv, nil
+				}
+				yKey := elements[y.element].alternatives[y.alternative].key
+				var tv types.TernaryTruthValue
+				var err error
+				if x < y.element {
+					tv, err = less(yKey, xAlt.key)
+					tv = logic.TernaryNot(tv)
+				} else {
+					tv, err = less(xAlt.key, yKey)
+				}
+				if err != nil {
+					return types.InvalidTernary, err
+				}
+				before[y] = tv
+				return tv, nil
+			}
+
+			for _, ordering := range orderings {
+				positions, err := possiblePositions(compare, ordering)
+				if err != nil {
+					return nil, false, err
+				}
+				for _, pos := range positions {
+					inserted := make([]entry, 0, len(ordering)+1)
+					inserted = append(inserted, ordering[:pos]...)
+					inserted = append(inserted, entry{x, alt})
+					inserted = append(inserted, ordering[pos:]...)
+					next = append(next, inserted)
+				}
+				if len(next) > MaxOrderings {
+					return nil, false, nil
+				}
+			}
+		}
+		orderings = next
+	}
+
+	return orderings, true, nil
+}
+
+// possiblePositions returns the positions of a sorted ordering at which an
+// element may be, given whether it goes before each of the elements there.
+func possiblePositions(before func(entry) (types.TernaryTruthValue, error), ordering []entry) ([]int, error) {
+	goesBefore := make([]types.TernaryTruthValue, len(ordering))
+	for i, y := range ordering {
+		tv, err := before(y)
+		if err != nil {
+			return nil, err
+		}
+		goesBefore[i] = tv
+	}
+
+	var rv []int
+	for pos := 0; pos <= len(ordering); pos++ {
+		ok := true
+		for i, tv := range goesBefore {
+			if (i < pos && tv == types.True) || (i >= pos && tv == types.False) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			rv = append(rv, pos)
+		}
+	}
+	return rv, nil
+}
+
+// widen returns a single list with the possible values at each position of
+// the sorted elements.
+func widen(less Less, elements []element, keyIsValue bool) (types.Value, error) {
+	for _, e := range elements {
+		if e.optional {
+			// The positions depend on which elements are present.
+			return fullyunknown.Value, nil
+		}
+	}
+
+	if keyIsValue {
+		return widenByBounds(elements)
+	}
+	return widenByRank(less, elements)
+}
+
+// widenByBounds returns, at each position, the values between the n'th
+// smallest lower bound and the n'th smallest upper bound of the elements (or
+// simply that range, if the elements are not all enumerable).
+func widenByBounds(elements []element) (types.Value, error) {
+	var lows, highs []types.Numeric
+	var values []types.Value
+	enumerable := true
+
+	for _, e := range elements {
+		low, high, ok := bounds(e.value)
+		if !ok {
+			return fullyunknown.Value, nil
+		}
+		lows = append(lows, low)
+		highs = append(highs, high)
+
+		if vals, ok := anyof.PossibleValues(e.value); ok {
+			values = append(values, vals...)
+		} else {
+			enumerable = false
+		}
+	}
+
+	// A nil lower bound is the smallest, and a nil upper bound the largest.
+	sort.Slice(lows, func(i, j int) bool {
+		return lows[j] != nil && (lows[i] == nil || numcmp.CompareOrPanic(lows[i], lows[j]) == numcmp.Less)
+	})
+	sort.Slice(highs, func(i, j int) bool {
+		return highs[i] != nil && (highs[j] == nil || numcmp.CompareOrPanic(highs[i], highs[j]) == numcmp.Less)
+	})
+
+	positions := make([]types.Value, len(elements))
+	for i := range positions {
+		low, high := lows[i], highs[i]
+
+		if enumerable {
+			var possible []types.Value
+			for _, v := range values {
+				n := v.(types.Numeric)
+				if numcmp.CompareOrPanic(n, low) != numcmp.Less && numcmp.CompareOrPanic(n, high) != numcmp.Greater {
+					possible = append(possible, v)
+				}
+			}
+			rv, err := anyof.New(possible)
+			if err != nil {
+				return nil, err
+			}
+			positions[i] = rv
+			continue
+		}
+
+		if low != nil && high != nil && numcmp.CompareOrPanic(low, high) == numcmp.Equal {
+			positions[i] = low
+			continue
+		}
+		rv, err := numinrange.New(low, high, true, true, nil)
+		if err != nil {
+			return nil, err
+		}
+		positions[i] = rv
+	}
+
+	return cons.NewChain(positions, null.Nil), nil
+}
+
+// bounds returns the (inclusive) bounds of a number or uncertain number;
+// nil if unbounded.
+func bounds(v types.Value) (types.Numeric, types.Numeric, bool) {
+	if r, ok := numinrange.ToRange(v); ok {
+		return r.LowerBound(), r.UpperBound(), true
+	}
+
+	vals, ok := anyof.PossibleValues(v)
+	if !ok {
+		return nil, nil, false
+	}
+
+	var low, high types.Numeric
+	for _, val := range vals {
+		n, ok := val.(types.Numeric)
+		if !ok {
+			return nil, nil, false
+		}
+		if low == nil || numcmp.CompareOrPanic(n, low) == numcmp.Less {
+			low = n
+		}
+		if high == nil || numcmp.CompareOrPanic(n, high) == numcmp.Greater {
+			high = n
+		}
+	}
+	return low, high, true
+}
+
+// widenByRank returns, at each position, the elements that may be there: an
+// element is at least preceded by the elements that must precede it, and at
+// most by those that may.
+func widenByRank(less Less, elements []element) (types.Value, error) {
+	n := len(elements)
+
+	// mustPrecede[i][j] is set if element i must precede element j.
+	mustPrecede := make([][]bool, n)
+	for i := range mustPrecede {
+		mustPrecede[i] = make([]bool, n)
+	}
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			// The earlier element i precedes j unless j is less than it.
+			jLess, err := less(elements[j].key, elements[i].key)
+			if err != nil {
+				return nil, err
+			}
+			switch jLess {
+			case types.False:
+				mustPrecede[i][j] = true
+			case types.True:
+				mustPrecede[j][i] = true
+			}
+		}
+	}
+
+	positions := make([][]types.Value, n)
+	for j := range elements {
+		first, last := 0, n-1
+		for i := range elements {
+			if mustPrecede[i][j] {
+				first++
+			}
+			if mustPrecede[j][i] {
+				last--
+			}
+		}
+		for pos := first; pos <= last; pos++ {
+			positions[pos] = append(positions[pos], elements[j].value)
+		}
+	}
+
+	values := make([]types.Value, n)
+	for i, possible := range positions {
+		rv, err := anyof.New(possible)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = rv
+	}
+	return cons.NewChain(values, null.Nil), nil
+}
@@ -54,3 +54,14 @@ func TernaryOr(a, b types.TernaryTruthValue) types.TernaryTruthValue {
 		return types.Maybe
 	}
 }
+
+func TernaryNot(a types.TernaryTruthValue) types.TernaryTruthValue {
+	switch a {
+	case types.True:
+		return types.False
+	case types.False:
+		return types.True
+	default:
+		return a
+	}
+}
@@ -91,17 +91,19 @@ func Macroexpand(e types.Env, v types.Value) (types.Value, error) {
 
 	metricNontrivialMacroexpands.Inc()
 
-	if !symbol.Is(car) {
-		return macroexpandNonmacroCons(e, v)
-	}
-
-	functionOrMacro, err := car.Eval(e)
-	if err != nil {
-		_, ok := err.(lisperr.UnboundVariable)
-		if ok {
-			return macroexpandNonmacroCons(e, v)
+	// The head of a form is either a symbol or, in the expansion of a
+	// hygienic macro, possibly the macro itself.
+	functionOrMacro := car
+	if symbol.Is(car) {
+		var err error
+		functionOrMacro, err = car.Eval(e)
+		if err != nil {
+			_, ok := err.(lisperr.UnboundVariable)
+			if ok {
+				return macroexpandNonmacroCons(e, v)
+			}
+			return nil, err
 		}
-		return nil, err
 	}
 
 	macro, ok := functionOrMacro.(types.Macro)
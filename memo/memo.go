@@ -0,0 +1,260 @@
+// Package memo implements memoization of pure functions.
+//
+// A memoized function remembers the results of its most recent calls, in a
+// bounded cache, and returns the remembered result when called again with
+// the same arguments. Arguments are the same if they are structurally
+// identical: equal atoms of the same type, conses of identical values, or
+// uncertain values with the same possibilities (so that a function called
+// repeatedly with the same #any-of is only evaluated once).
+package memo
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/steinarvk/heisenlisp/equality"
+	"github.com/steinarvk/heisenlisp/hashcode"
+	"github.com/steinarvk/heisenlisp/hashcons"
+	"github.com/steinarvk/heisenlisp/types"
+	"github.com/steinarvk/heisenlisp/value/cons"
+	"github.com/steinarvk/heisenlisp/value/record"
+	"github.com/steinarvk/heisenlisp/value/unknowns/anyof"
+)
+
+// DefaultCapacity is the number of results remembered by a memoized
+// function, unless another capacity is given.
+var DefaultCapacity = 10000
+
+var (
+	metricMemoHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "hlisp",
+			Name:      "memo_hits",
+			Help:      "Calls to memoized functions answered from the cache",
+		},
+	)
+
+	metricMemoMisses = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "hlisp",
+			Name:      "memo_misses",
+			Help:      "Calls to memoized functions not found in the cache",
+		},
+	)
+
+	metricMemoEvictions = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "hlisp",
+			Name:      "memo_evictions",
+			Help:      "Results evicted from memoization caches",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(metricMemoHits)
+	prometheus.MustRegister(metricMemoMisses)
+	prometheus.MustRegister(metricMemoEvictions)
+}
+
+type entry struct {
+	hash   uint32
+	params []types.Value
+	result types.Value
+}
+
+type memoizedFunction struct {
+	f        types.Callable
+	capacity int
+
+	mu      sync.Mutex
+	buckets map[uint32][]*list.Element
+	// recent holds the entries, most recently used first.
+	recent *list.List
+}
+
+var _ types.Callable = &memoizedFunction{}
+
+// New memoizes a pure function, remembering up to capacity results.
+func New(f types.Callable, capacity int) (types.Value, error) {
+	if !f.IsPure() {
+		return nil, fmt.Errorf("cannot memoize impure function %q", f.CallableName())
+	}
+	if capacity < 1 {
+		return nil, fmt.Errorf("invalid memoization capacity %d", capacity)
+	}
+	return &memoizedFunction{
+		f:        f,
+		capacity: capacity,
+		buckets:  map[uint32][]*list.Element{},
+		recent:   list.New(),
+	}, nil
+}
+
+func (m *memoizedFunction) CallableName() string { return m.f.CallableName() }
+func (m *memoizedFunction) IsPure() bool         { return true }
+func (m *memoizedFunction) TypeName() string     { return m.f.TypeName() }
+func (m *memoizedFunction) Falsey() bool         { return false }
+func (m *memoizedFunction) String() string {
+	return fmt.Sprintf("#<memoized %v>", m.f)
+}
+func (m *memoizedFunction) Eval(_ types.Env) (types.Value, error) { return m, nil }
+func (m *memoizedFunction) Hashcode() uint32 {
+	return hashcode.Hash(fmt.Sprintf("%p", m))
+}
+
+func paramsHash(params []types.Value) uint32 {
+	hasher := hashcode.New()
+	for _, p := range params {
+		fmt.Fprintf(hasher, "%d,", p.Hashcode())
+	}
+	return hasher.Sum32()
+}
+
+func (m *memoizedFunction) lookup(h uint32, params []types.Value) (types.Value, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, elem := range m.buckets[h] {
+		e := elem.Value.(*entry)
+		if identicalSlices(e.params, params) {
+			m.recent.MoveToFront(elem)
+			return e.result, true
+		}
+	}
+	return nil, false
+}
+
+func (m *memoizedFunction) store(h uint32, params []types.Value, result types.Value) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, elem := range m.buckets[h] {
+		if identicalSlices(elem.Value.(*entry).params, params) {
+			// Stored by a recursive call in the meantime.
+			return
+		}
+	}
+
+	elem := m.recent.PushFront(&entry{h, params, result})
+	m.buckets[h] = append(m.buckets[h], elem)
+
+	for m.recent.Len() > m.capacity {
+		m.evict(m.recent.Back())
+	}
+}
+
+func (m *memoizedFunction) evict(elem *list.Element) {
+	e := m.recent.Remove(elem).(*entry)
+	bucket := m.buckets[e.hash]
+	for i, x := range bucket {
+		if x == elem {
+			bucket = append(bucket[:i], bucket[i+1:]...)
+			break
+		}
+	}
+	if len(bucket) == 0 {
+		delete(m.buckets, e.hash)
+	} else {
+		m.buckets[e.hash] = bucket
+	}
+	metricMemoEvictions.Inc()
+}
+
+func (m *memoizedFunction) Call(params []types.Value) (types.Value, error) {
+	h := paramsHash(params)
+
+	if result, ok := m.lookup(h, params); ok {
+		metricMemoHits.Inc()
+		return result, nil
+	}
+	metricMemoMisses.Inc()
+
+	// Errors are not remembered.
+	result, err := m.f.Call(params)
+	if err != nil {
+		return nil, err
+	}
+
+	m.store(h, append([]types.Value(nil), params...), result)
+	return result, nil
+}
+
+func identicalSlices(a, b []types.Value) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !Identical(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Identical checks whether two values are structurally the same, so that a
+// pure function must return the same result for both. Values that cannot
+// be compared (such as functions) are never identical.
+func Identical(a, b types.Value) bool {
+	if a.Hashcode() != b.Hashcode() || a.TypeName() != b.TypeName() {
+		return false
+	}
+
+	_, aIsUnk := a.(types.Unknown)
+	_, bIsUnk := b.(types.Unknown)
+	if aIsUnk || bIsUnk {
+		if !aIsUnk || !bIsUnk {
+			return false
+		}
+		as, aok := anyof.PossibleValues(a)
+		bs, bok := anyof.PossibleValues(b)
+		if aok && bok {
+			return identicalSets(as, bs)
+		}
+		// Other unknowns are fully described by their representation.
+		return !aok && !bok && a.String() == b.String()
+	}
+
+	if aCar, aCdr, ok := cons.Decompose(a); ok {
+		if hashcons.Identical(a, b) {
+			return true
+		}
+		bCar, bCdr, ok := cons.Decompose(b)
+		return ok && Identical(aCar, bCar) && Identical(aCdr, bCdr)
+	}
+
+	if aType, aValues, ok := record.Decompose(a); ok {
+		bType, bValues, ok := record.Decompose(b)
+		return ok && aType == bType && identicalSlices(aValues, bValues)
+	}
+
+	_, aIsNum := a.(types.Numeric)
+	_, bIsNum := b.(types.Numeric)
+	if aIsNum && bIsNum {
+		tv, err := equality.Equals(a, b)
+		return err == nil && tv == types.True
+	}
+
+	return equality.AtomEquals(a, b)
+}
+
+func identicalSets(as, bs []types.Value) bool {
+	if len(as) != len(bs) {
+		return false
+	}
+	for _, a := range as {
+		found := false
+		for _, b := range bs {
+			if Identical(a, b) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,80 @@
+package memo
+
+import (
+	"testing"
+
+	"github.com/steinarvk/heisenlisp/types"
+	"github.com/steinarvk/heisenlisp/value/builtinfunc"
+	"github.com/steinarvk/heisenlisp/value/integer"
+	"github.com/steinarvk/heisenlisp/value/real"
+	"github.com/steinarvk/heisenlisp/value/str"
+	"github.com/steinarvk/heisenlisp/value/unknowns/anyof"
+)
+
+func TestIdentical(t *testing.T) {
+	one := integer.FromInt64(1)
+	two := integer.FromInt64(2)
+
+	testcases := []struct {
+		a, b types.Value
+		want bool
+	}{
+		{one, integer.FromInt64(1), true},
+		{one, two, false},
+		{one, real.FromFloat64(1), false},
+		{str.New("a"), str.New("a"), true},
+		{anyof.NewOrPanic([]types.Value{one, two}), anyof.NewOrPanic([]types.Value{two, one}), true},
+		{anyof.NewOrPanic([]types.Value{one, two}), one, false},
+	}
+
+	for _, testcase := range testcases {
+		if got := Identical(testcase.a, testcase.b); got != testcase.want {
+			t.Errorf("Identical(%v, %v) = %v want %v", testcase.a, testcase.b, got, testcase.want)
+		}
+	}
+}
+
+func TestEviction(t *testing.T) {
+	calls := 0
+	f := builtinfunc.New("count-calls", true, func(xs []types.Value) (types.Value, error) {
+		calls++
+		return xs[0], nil
+	})
+
+	m, err := New(f.(types.Callable), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	call := func(n int64) {
+		if _, err := m.(types.Callable).Call([]types.Value{integer.FromInt64(n)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	call(1)
+	call(2)
+	call(1)
+	if calls != 2 {
+		t.Errorf("calls = %d after repeated call, want 2", calls)
+	}
+
+	// 2 is the least recently used, so it is evicted.
+	call(3)
+	call(1)
+	if calls != 3 {
+		t.Errorf("calls = %d after call to remembered value, want 3", calls)
+	}
+	call(2)
+	if calls != 4 {
+		t.Errorf("calls = %d after call to evicted value, want 4", calls)
+	}
+}
+
+func TestImpure(t *testing.T) {
+	f := builtinfunc.New("impure!", false, func(xs []types.Value) (types.Value, error) {
+		return xs[0], nil
+	})
+	if _, err := New(f.(types.Callable), 10); err == nil {
+		t.Errorf("New(impure function) succeeded, want error")
+	}
+}
@@ -0,0 +1,291 @@
+// Package module implements loading of Heisenlisp modules.
+//
+// A module is a file starting with a module form:
+//
+//	(module geometry (export area perimeter))
+//
+// The rest of the file is evaluated in an environment of its own, so that
+// its definitions (even those made with defun! and defmacro!) do not end up
+// in the global namespace. Importing the module binds only the exported
+// names, qualified with the module name: geometry/area and so on.
+package module
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/steinarvk/heisenlisp/env"
+	"github.com/steinarvk/heisenlisp/expr"
+	"github.com/steinarvk/heisenlisp/gen/parser"
+	"github.com/steinarvk/heisenlisp/hashcode"
+	"github.com/steinarvk/heisenlisp/types"
+	"github.com/steinarvk/heisenlisp/value/symbol"
+)
+
+const (
+	TypeName = "module"
+
+	// Separator separates the module name from the name of an exported
+	// value in a qualified name.
+	Separator = "/"
+
+	// FileExtension is added to a module name to find its file.
+	FileExtension = ".hlisp"
+)
+
+// SearchPath is the list of directories searched by import.
+var SearchPath = []string{"."}
+
+var (
+	metricModulesLoaded = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "hlisp",
+			Name:      "modules_loaded",
+			Help:      "Modules loaded (not counting cached loads)",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(metricModulesLoaded)
+}
+
+type moduleValue struct {
+	name     string
+	filename string
+	env      types.Env
+	exports  []string
+}
+
+func (m *moduleValue) String() string                        { return fmt.Sprintf("#<module %q>", m.name) }
+func (m *moduleValue) Eval(_ types.Env) (types.Value, error) { return m, nil }
+func (m *moduleValue) Falsey() bool                          { return false }
+func (m *moduleValue) TypeName() string                      { return TypeName }
+func (m *moduleValue) Hashcode() uint32 {
+	return hashcode.Hash(fmt.Sprintf("%p", m))
+}
+
+// Header is the parsed module form at the start of a module file.
+type Header struct {
+	Name    string
+	Exports []string
+}
+
+// IsHeader checks whether v is a module form.
+func IsHeader(v types.Value) bool {
+	xs, err := expr.UnwrapList(v)
+	if err != nil || len(xs) == 0 {
+		return false
+	}
+	name, err := symbol.Name(xs[0])
+	return err == nil && name == "module"
+}
+
+// ParseHeader parses the arguments of a module form, e.g. for
+// (module name (export a b)) it parses (name (export a b)).
+func ParseHeader(args []types.Value) (*Header, error) {
+	if len(args) < 1 {
+		return nil, errors.New("module: missing module name")
+	}
+
+	name, err := symbol.Name(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("module: invalid module name %v: %v", args[0], err)
+	}
+
+	rv := &Header{Name: name}
+
+	for _, clause := range args[1:] {
+		xs, err := expr.UnwrapList(clause)
+		if err != nil || len(xs) == 0 {
+			return nil, fmt.Errorf("module: invalid clause %v", clause)
+		}
+		clauseName, err := symbol.Name(xs[0])
+		if err != nil || clauseName != "export" {
+			return nil, fmt.Errorf("module: unknown clause %v", clause)
+		}
+		for _, x := range xs[1:] {
+			exportName, err := symbol.Name(x)
+			if err != nil {
+				return nil, fmt.Errorf("module: cannot export %v: %v", x, err)
+			}
+			rv.Exports = append(rv.Exports, exportName)
+		}
+	}
+
+	return rv, nil
+}
+
+// Loader loads modules into a root environment, loading each file at most
+// once.
+type Loader struct {
+	root types.Env
+
+	mu      sync.Mutex
+	loaded  map[string]*moduleValue
+	loading map[string]bool
+}
+
+func NewLoader(root types.Env) *Loader {
+	return &Loader{
+		root:    root,
+		loaded:  map[string]*moduleValue{},
+		loading: map[string]bool{},
+	}
+}
+
+// Find returns the filename of the module with the given name, from the
+// first directory in SearchPath that has it.
+func Find(name string) (string, error) {
+	for _, dir := range SearchPath {
+		filename := filepath.Join(dir, name+FileExtension)
+		if _, err := os.Stat(filename); err == nil {
+			return filename, nil
+		}
+	}
+	return "", fmt.Errorf("module %q not found in search path %v", name, SearchPath)
+}
+
+// Import loads the module with the given name (see Find).
+func (l *Loader) Import(name string) (types.Value, error) {
+	filename, err := Find(name)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := l.load(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.name != name {
+		return nil, fmt.Errorf("module file %q declares module %q, not %q", filename, m.name, name)
+	}
+
+	return m, nil
+}
+
+// Require loads the module in the given file.
+func (l *Loader) Require(filename string) (types.Value, error) {
+	return l.load(filename)
+}
+
+func (l *Loader) load(filename string) (*moduleValue, error) {
+	filename, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	m, ok := l.loaded[filename]
+	isLoading := l.loading[filename]
+	if !ok && !isLoading {
+		l.loading[filename] = true
+	}
+	l.mu.Unlock()
+
+	if ok {
+		return m, nil
+	}
+	if isLoading {
+		return nil, fmt.Errorf("circular import of module file %q", filename)
+	}
+
+	defer func() {
+		l.mu.Lock()
+		delete(l.loading, filename)
+		l.mu.Unlock()
+	}()
+
+	m, err = l.loadUncached(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	l.loaded[filename] = m
+	l.mu.Unlock()
+
+	return m, nil
+}
+
+func (l *Loader) loadUncached(filename string) (*moduleValue, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	expressionsIntf, err := parser.Parse(filename, data)
+	if err != nil {
+		return nil, err
+	}
+	expressions := expressionsIntf.([]interface{})
+
+	if len(expressions) == 0 || !IsHeader(expressions[0].(types.Value)) {
+		return nil, fmt.Errorf("%s: not a module (must start with a module form)", filename)
+	}
+
+	headerForm, _ := expr.UnwrapList(expressions[0].(types.Value))
+	header, err := ParseHeader(headerForm[1:])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	moduleEnv := env.NewModule(l.root)
+
+	for _, expression := range expressions[1:] {
+		val := expression.(types.Value)
+		if _, err := val.Eval(moduleEnv); err != nil {
+			return nil, fmt.Errorf("%s: error evaluating %v: %v", filename, val, err)
+		}
+	}
+
+	// Only the module's own definitions can be exported, not the builtins
+	// it sees through the root.
+	for _, name := range header.Exports {
+		cell, _ := env.GlobalCell(moduleEnv, symbol.StringToIdOrPanic(name))
+		if _, ok := cell.Value(); !ok {
+			return nil, fmt.Errorf("%s: exported name %q is not defined", filename, name)
+		}
+	}
+
+	metricModulesLoaded.Inc()
+
+	return &moduleValue{
+		name:     header.Name,
+		filename: filename,
+		env:      moduleEnv,
+		exports:  header.Exports,
+	}, nil
+}
+
+// BindExports binds the exports of a module in e, qualified with the given
+// prefix (normally the name of the module).
+func BindExports(e types.Env, v types.Value, prefix string) error {
+	m, ok := v.(*moduleValue)
+	if !ok {
+		return fmt.Errorf("not a module: %v", v)
+	}
+
+	for _, name := range m.exports {
+		cell, _ := env.GlobalCell(m.env, symbol.StringToIdOrPanic(name))
+		val, _ := cell.Value()
+		e.BindRoot(symbol.StringToIdOrPanic(prefix+Separator+name), val)
+	}
+
+	return nil
+}
+
+// Name returns the declared name of a module.
+func Name(v types.Value) (string, error) {
+	m, ok := v.(*moduleValue)
+	if !ok {
+		return "", fmt.Errorf("not a module: %v", v)
+	}
+	return m.name, nil
+}
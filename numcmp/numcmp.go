@@ -2,6 +2,7 @@
 package numcmp
 
 import (
+	"math"
 	"math/big"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -33,11 +34,10 @@ const (
 var cmpNumerics = numtower.BinaryTowerFunc{
 	OnInt64s: func(a, b int64) (interface{}, error) {
 		metricNumericComparisons.WithLabelValues("int64").Inc()
-		diff := a - b
 		switch {
-		case diff < 0:
+		case a < b:
 			return Less, nil
-		case diff > 0:
+		case a > b:
 			return Greater, nil
 		default:
 			return Equal, nil
@@ -65,7 +65,57 @@ var cmpNumerics = numtower.BinaryTowerFunc{
 	},
 }.Call
 
+// compareFloatToExact compares a float and an exact number exactly, rather
+// than as floats (in which 0.1 would equal 1/10, and a large integer its
+// neighbours).
+func compareFloatToExact(a, b types.Numeric) (int, bool) {
+	aRat, aExact := a.AsBigrat()
+	bRat, bExact := b.AsBigrat()
+	if aExact == bExact {
+		return 0, false
+	}
+
+	x, _ := a.AsDouble()
+	if aExact {
+		x, _ = b.AsDouble()
+	}
+	if math.IsNaN(x) {
+		return 0, false
+	}
+
+	metricNumericComparisons.WithLabelValues("float64-exact").Inc()
+
+	var rv int
+	if math.IsInf(x, 0) {
+		rv = Less
+		if x < 0 {
+			rv = Greater
+		}
+	} else {
+		xRat := new(big.Rat).SetFloat64(x)
+		if aExact {
+			bRat = xRat
+		} else {
+			aRat = xRat
+		}
+		return aRat.Cmp(bRat), true
+	}
+
+	if !aExact {
+		rv = -rv
+	}
+	return rv, true
+}
+
 func Compare(a, b types.Numeric) (int, error) {
+	_, aInt := a.AsInt64()
+	_, bInt := b.AsInt64()
+	if !aInt || !bInt {
+		if rv, ok := compareFloatToExact(a, b); ok {
+			return rv, nil
+		}
+	}
+
 	valIf, err := cmpNumerics(a, b)
 	if err != nil {
 		return 0, err
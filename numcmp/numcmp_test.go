@@ -1,10 +1,14 @@
 package numcmp
 
 import (
+	"math"
+	"math/big"
 	"testing"
 
 	"github.com/steinarvk/heisenlisp/types"
 	"github.com/steinarvk/heisenlisp/value/integer"
+	"github.com/steinarvk/heisenlisp/value/rational"
+	"github.com/steinarvk/heisenlisp/value/real"
 )
 
 func TestCompare(t *testing.T) {
@@ -13,3 +17,32 @@ func TestCompare(t *testing.T) {
 		t.Errorf("CompareOrPanic(10, 20) = %v want %v", got, Less)
 	}
 }
+
+func TestCompareExactly(t *testing.T) {
+	big65 := new(big.Int).Lsh(big.NewInt(1), 65)
+	big65plus1 := new(big.Int).Add(big65, big.NewInt(1))
+
+	testcases := []struct {
+		a, b types.Numeric
+		want int
+	}{
+		{integer.FromInt64(math.MaxInt64), integer.FromInt64(math.MinInt64), Greater},
+		{integer.FromInt64(math.MinInt64), integer.FromInt64(1), Less},
+		{real.FromFloat64(0.1), rational.FromBig(big.NewRat(1, 10)), Greater},
+		{rational.FromBig(big.NewRat(1, 10)), real.FromFloat64(0.1), Less},
+		{real.FromFloat64(0.5), rational.FromBig(big.NewRat(1, 2)), Equal},
+		{integer.FromInt64(1), real.FromFloat64(1), Equal},
+		{integer.FromBig(big65plus1), real.FromFloat64(math.Ldexp(1, 65)), Greater},
+		{real.FromFloat64(math.Ldexp(1, 65)), integer.FromBig(big65plus1), Less},
+		{integer.FromBig(big65), real.FromFloat64(math.Inf(1)), Less},
+		{real.FromFloat64(math.Inf(-1)), integer.FromBig(big65), Less},
+		{real.FromFloat64(math.Inf(1)), integer.FromInt64(0), Greater},
+	}
+
+	for _, testcase := range testcases {
+		got := CompareOrPanic(testcase.a, testcase.b)
+		if got != testcase.want {
+			t.Errorf("CompareOrPanic(%v, %v) = %v want %v", testcase.a, testcase.b, got, testcase.want)
+		}
+	}
+}
@@ -0,0 +1,535 @@
+package numerics
+
+import (
+	"math/big"
+	"math/bits"
+
+	"github.com/steinarvk/heisenlisp/lisperr"
+	"github.com/steinarvk/heisenlisp/number"
+	"github.com/steinarvk/heisenlisp/numrange"
+	"github.com/steinarvk/heisenlisp/types"
+	"github.com/steinarvk/heisenlisp/value/integer"
+	"github.com/steinarvk/heisenlisp/value/unknowns/anyof"
+)
+
+// The bitwise operations work on integers as if in two's complement with
+// infinitely many sign bits, so that a negative number has infinitely many
+// ones. On ranges they work on the integers in the range: exactly, if there
+// are few enough of them, and otherwise on bounds from their signs and
+// widths.
+
+// maxShift is the most a number may be shifted left.
+const maxShift = 1 << 16
+
+// intRange is the integers from low to high, where nil is unbounded.
+type intRange struct {
+	low, high *big.Int
+}
+
+// integerBounds returns the integers in a range.
+func integerBounds(r *numrange.Range) (intRange, error) {
+	var rv intRange
+	if low, ok := finiteRat(r.LowerBound()); ok {
+		rv.low = ratCeiling(low)
+		if !r.LowerBoundInclusive() && low.IsInt() {
+			rv.low.Add(rv.low, big.NewInt(1))
+		}
+	}
+	if high, ok := finiteRat(r.UpperBound()); ok {
+		rv.high = ratFloor(high)
+		if !r.UpperBoundInclusive() && high.IsInt() {
+			rv.high.Sub(rv.high, big.NewInt(1))
+		}
+	}
+	if rv.low != nil && rv.high != nil && rv.low.Cmp(rv.high) > 0 {
+		return intRange{}, lisperr.UnexpectedValue{"range with integers", rangeValue(r)}
+	}
+	return rv, nil
+}
+
+func finiteRat(n types.Numeric) (*big.Rat, bool) {
+	if n == nil {
+		return nil, false
+	}
+	return ratOf(n)
+}
+
+// values returns the integers in the range, unless there are too many.
+func (r intRange) values() ([]types.Value, bool) {
+	if r.low == nil || r.high == nil {
+		return nil, false
+	}
+	count := new(big.Int).Sub(r.high, r.low)
+	if !count.IsInt64() || count.Int64() >= anyof.MaxAnyOfElements {
+		return nil, false
+	}
+	var rv []types.Value
+	for i := new(big.Int).Set(r.low); i.Cmp(r.high) <= 0; i.Add(i, big.NewInt(1)) {
+		rv = append(rv, number.FromBigInt(new(big.Int).Set(i)))
+	}
+	return rv, true
+}
+
+func (r intRange) toRange() *numrange.Range {
+	var low, high types.Numeric
+	if r.low != nil {
+		low = number.FromBigInt(r.low)
+	}
+	if r.high != nil {
+		high = number.FromBigInt(r.high)
+	}
+	return numrange.New(low, high, low != nil, high != nil)
+}
+
+// value returns the integers as a value: an any-of of them if there are few
+// enough.
+func (r intRange) value() (types.Value, error) {
+	return fromIntegerRange(r.toRange())
+}
+
+// rangeValue returns the integers as a range value.
+func (r intRange) rangeValue() (types.Value, error) {
+	return fromRange(r.toRange(), []string{integer.TypeName})
+}
+
+// sign returns 1 if the integers are all non-negative, -1 if they are all
+// negative, and 0 if not known.
+func (r intRange) sign() int {
+	switch {
+	case r.low != nil && r.low.Sign() >= 0:
+		return 1
+	case r.high != nil && r.high.Sign() < 0:
+		return -1
+	}
+	return 0
+}
+
+func bitNot(x *big.Int) *big.Int {
+	return new(big.Int).Not(x)
+}
+
+func (r intRange) not() intRange {
+	var rv intRange
+	if r.high != nil {
+		rv.low = bitNot(r.high)
+	}
+	if r.low != nil {
+		rv.high = bitNot(r.low)
+	}
+	return rv
+}
+
+// width returns the number of bits w besides the sign bits of the integers
+// in the ranges, so that they are all from -2^w to 2^w-1.
+func width(rs ...intRange) (uint, bool) {
+	var w int
+	for _, r := range rs {
+		for _, x := range []*big.Int{r.low, r.high} {
+			if x == nil {
+				return 0, false
+			}
+			if x.Sign() < 0 {
+				x = bitNot(x)
+			}
+			if x.BitLen() > w {
+				w = x.BitLen()
+			}
+		}
+	}
+	return uint(w), true
+}
+
+// bitwiseBounds returns the integers of the width of a and b with a sign
+// (if known), which includes the results of any bitwise operation on them.
+func bitwiseBounds(a, b intRange, sign int) intRange {
+	var rv intRange
+	w, ok := width(a, b)
+	switch {
+	case sign > 0:
+		rv.low = new(big.Int)
+	case ok:
+		rv.low = new(big.Int).Neg(new(big.Int).Lsh(big.NewInt(1), w))
+	}
+	switch {
+	case sign < 0:
+		rv.high = big.NewInt(-1)
+	case ok:
+		rv.high = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), w), big.NewInt(1))
+	}
+	return rv
+}
+
+// minHigh returns the lesser of upper bounds, where nil is unbounded.
+func minHigh(x, y *big.Int) *big.Int {
+	switch {
+	case x == nil:
+		return y
+	case y == nil || x.Cmp(y) <= 0:
+		return x
+	}
+	return y
+}
+
+// maxHigh returns the greater of upper bounds, where nil is unbounded.
+func maxHigh(x, y *big.Int) *big.Int {
+	if x == nil || y == nil {
+		return nil
+	}
+	if x.Cmp(y) >= 0 {
+		return x
+	}
+	return y
+}
+
+// andBounds bounds x & y, which only has bits of both: it is no greater than
+// a non-negative x (or y), or than both if they are negative, so masking with
+// a non-negative number bounds the result.
+func andBounds(a, b intRange) intRange {
+	sign := 0
+	switch {
+	case a.sign() > 0 || b.sign() > 0:
+		sign = 1
+	case a.sign() < 0 && b.sign() < 0:
+		sign = -1
+	}
+
+	rv := bitwiseBounds(a, b, sign)
+	rv.high = minHigh(rv.high, maxHigh(a.high, b.high))
+	if a.sign() > 0 || sign < 0 {
+		rv.high = minHigh(rv.high, a.high)
+	}
+	if b.sign() > 0 || sign < 0 {
+		rv.high = minHigh(rv.high, b.high)
+	}
+	return rv
+}
+
+// orBounds bounds x | y, which is ^(^x & ^y).
+func orBounds(a, b intRange) intRange {
+	return andBounds(a.not(), b.not()).not()
+}
+
+func xorBounds(a, b intRange) intRange {
+	sign := 0
+	if a.sign() != 0 && b.sign() != 0 {
+		sign = a.sign() * b.sign()
+	}
+	return bitwiseBounds(a, b, sign)
+}
+
+// bitwise applies an operation on integers to each possible pair of them,
+// or else to the bounds of ranges.
+func bitwise(a, b types.Value, f func(x, y *big.Int) (*big.Int, error), bounds func(a, b intRange) intRange) (types.Value, error) {
+	onNumerics := func(a, b types.Numeric) (types.Value, error) {
+		x, err := toBigint(a)
+		if err != nil {
+			return nil, err
+		}
+		y, err := toBigint(b)
+		if err != nil {
+			return nil, err
+		}
+		rv, err := f(x, y)
+		if err != nil {
+			return nil, err
+		}
+		return number.FromBigInt(rv), nil
+	}
+
+	return wrapBinaryWithRanges(a, b, onNumerics, func(a, b *numrange.Range) (types.Value, error) {
+		ia, err := integerBounds(a)
+		if err != nil {
+			return nil, err
+		}
+		ib, err := integerBounds(b)
+		if err != nil {
+			return nil, err
+		}
+
+		if xs, ok := ia.values(); ok {
+			if ys, ok := ib.values(); ok && int64(len(xs)*len(ys)) <= anyof.MaxAnyOfElements {
+				return anyof.MapProduct(xs, ys, func(x, y types.Value) (types.Value, error) {
+					return onNumerics(x.(types.Numeric), y.(types.Numeric))
+				})
+			}
+		}
+
+		return bounds(ia, ib).value()
+	})
+}
+
+// bitwiseUnary is bitwise for operations on one integer.
+func bitwiseUnary(a types.Value, f func(x *big.Int) *big.Int, bounds func(r intRange) intRange) (types.Value, error) {
+	onNumeric := func(n types.Numeric) (types.Value, error) {
+		x, err := toBigint(n)
+		if err != nil {
+			return nil, err
+		}
+		return number.FromBigInt(f(x)), nil
+	}
+
+	return wrapUnary(a, onNumeric, func(r *numrange.Range) (types.Value, error) {
+		ir, err := integerBounds(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if xs, ok := ir.values(); ok {
+			var results []types.Value
+			for _, x := range xs {
+				rv, err := onNumeric(x.(types.Numeric))
+				if err != nil {
+					return nil, err
+				}
+				results = append(results, rv)
+			}
+			return anyof.New(results)
+		}
+
+		return bounds(ir).value()
+	})
+}
+
+// BinaryBitAnd returns the bits set in both of two integers.
+func BinaryBitAnd(a, b types.Value) (types.Value, error) {
+	return bitwise(a, b, func(x, y *big.Int) (*big.Int, error) {
+		return new(big.Int).And(x, y), nil
+	}, andBounds)
+}
+
+// BinaryBitOr returns the bits set in either of two integers.
+func BinaryBitOr(a, b types.Value) (types.Value, error) {
+	return bitwise(a, b, func(x, y *big.Int) (*big.Int, error) {
+		return new(big.Int).Or(x, y), nil
+	}, orBounds)
+}
+
+// BinaryBitXor returns the bits set in exactly one of two integers.
+func BinaryBitXor(a, b types.Value) (types.Value, error) {
+	return bitwise(a, b, func(x, y *big.Int) (*big.Int, error) {
+		return new(big.Int).Xor(x, y), nil
+	}, xorBounds)
+}
+
+// BitNot returns an integer with its bits flipped, that is -x-1.
+func BitNot(a types.Value) (types.Value, error) {
+	return bitwiseUnary(a, bitNot, intRange.not)
+}
+
+func popcount(x *big.Int) *big.Int {
+	if x.Sign() < 0 {
+		x = bitNot(x)
+	}
+	n := 0
+	for _, word := range x.Bits() {
+		n += bits.OnesCount(uint(word))
+	}
+	return big.NewInt(int64(n))
+}
+
+// popcountBounds bounds the number of bits set, which is at most the width.
+func popcountBounds(r intRange) intRange {
+	// Negative numbers count like their complements.
+	if r.sign() < 0 {
+		r = r.not()
+	}
+	rv := intRange{low: new(big.Int)}
+	if r.sign() > 0 && r.low.Sign() > 0 {
+		rv.low = big.NewInt(1)
+	}
+	if w, ok := width(r); ok {
+		rv.high = big.NewInt(int64(w))
+	}
+	return rv
+}
+
+// Popcount returns the number of bits set in a non-negative integer, or
+// the number not set in a negative one.
+func Popcount(a types.Value) (types.Value, error) {
+	return bitwiseUnary(a, popcount, popcountBounds)
+}
+
+// shift returns x shifted left by k bits, or right if k is negative, which
+// is the floor of x times 2^k. It is not ok if the result would be too large.
+func shift(x, k *big.Int) (*big.Int, bool) {
+	switch {
+	case x.Sign() == 0:
+		return new(big.Int), true
+	case k.Sign() >= 0:
+		if !k.IsInt64() || k.Int64() > maxShift {
+			return nil, false
+		}
+		return new(big.Int).Lsh(x, uint(k.Int64())), true
+	case !k.IsInt64():
+		// Everything is shifted out except the sign.
+		if x.Sign() < 0 {
+			return big.NewInt(-1), true
+		}
+		return new(big.Int), true
+	}
+	return new(big.Int).Rsh(x, uint(-k.Int64())), true
+}
+
+// shiftBounds bounds shifting, which is increasing in x, and in k for a
+// non-negative x but decreasing for a negative one: so the least result is
+// at the least x, and the greatest at the greatest x.
+func shiftBounds(x, k intRange) intRange {
+	var rv intRange
+
+	at := func(x, k *big.Int) *big.Int {
+		if k == nil {
+			return nil
+		}
+		rv, ok := shift(x, k)
+		if !ok {
+			return nil
+		}
+		return rv
+	}
+
+	switch low := x.low; {
+	case low == nil:
+	case low.Sign() < 0:
+		rv.low = at(low, k.high)
+	case k.low == nil:
+		// Shifting right far enough gives zero.
+		rv.low = new(big.Int)
+	default:
+		rv.low = at(low, k.low)
+	}
+
+	switch high := x.high; {
+	case high == nil:
+	case high.Sign() < 0:
+		if k.low == nil {
+			rv.high = big.NewInt(-1)
+		} else {
+			rv.high = at(high, k.low)
+		}
+	case high.Sign() == 0:
+		rv.high = new(big.Int)
+	default:
+		rv.high = at(high, k.high)
+	}
+
+	return rv
+}
+
+// ShiftLeft returns an integer shifted left by a number of bits, or right
+// if negative.
+func ShiftLeft(a, b types.Value) (types.Value, error) {
+	return bitwise(a, b, func(x, k *big.Int) (*big.Int, error) {
+		rv, ok := shift(x, k)
+		if !ok {
+			return nil, lisperr.UnexpectedValue{"shift of at most 65536 bits", number.FromBigInt(k)}
+		}
+		return rv, nil
+	}, shiftBounds)
+}
+
+// ShiftRight returns an integer shifted right by a number of bits, which
+// is the floor of dividing it by that power of two.
+func ShiftRight(a, b types.Value) (types.Value, error) {
+	k, err := BinaryMinus(zeroValueNumeric, b)
+	if err != nil {
+		return nil, err
+	}
+	return ShiftLeft(a, k)
+}
+
+// wrapInteger returns the integer of a width congruent to x: from 0 to
+// 2^bits-1 if unsigned, and from -2^(bits-1) to 2^(bits-1)-1 if signed.
+func wrapInteger(x *big.Int, bits uint, signed bool) *big.Int {
+	m := new(big.Int).Lsh(big.NewInt(1), bits)
+	rv := new(big.Int).Mod(x, m)
+	if signed && rv.Bit(int(bits-1)) == 1 {
+		rv.Sub(rv, m)
+	}
+	return rv
+}
+
+// wrapBounds returns the integers of a width congruent to those in a
+// range: the same ones shifted if there are fewer than all of them, in one
+// part or two, if they wrap around.
+func wrapBounds(r intRange, bits uint, signed bool) (types.Value, error) {
+	all := intRange{low: new(big.Int), high: new(big.Int).Lsh(big.NewInt(1), bits)}
+	if signed {
+		all.low.Neg(new(big.Int).Lsh(big.NewInt(1), bits-1))
+		all.high.Add(all.high, all.low)
+	}
+	all.high.Sub(all.high, big.NewInt(1))
+
+	if r.low == nil || r.high == nil {
+		return all.value()
+	}
+	if new(big.Int).Sub(r.high, r.low).Cmp(new(big.Int).Sub(all.high, all.low)) >= 0 {
+		return all.value()
+	}
+
+	low, high := wrapInteger(r.low, bits, signed), wrapInteger(r.high, bits, signed)
+	if low.Cmp(high) <= 0 {
+		return intRange{low, high}.value()
+	}
+
+	// The two parts are enumerated only if there are few enough in all.
+	value := intRange.value
+	if _, ok := r.values(); !ok {
+		value = intRange.rangeValue
+	}
+	below, err := value(intRange{low, all.high})
+	if err != nil {
+		return nil, err
+	}
+	above, err := value(intRange{all.low, high})
+	if err != nil {
+		return nil, err
+	}
+	return anyof.New([]types.Value{below, above})
+}
+
+func wrapWidth(a, b types.Value, signed bool) (types.Value, error) {
+	widthOf := func(n types.Numeric) (uint, error) {
+		w, ok := n.AsInt64()
+		if !ok || w <= 0 || w > maxShift {
+			return 0, lisperr.UnexpectedValue{"positive number of bits", n}
+		}
+		return uint(w), nil
+	}
+
+	return wrapBinaryWithRanges(a, b, func(a, b types.Numeric) (types.Value, error) {
+		x, err := toBigint(a)
+		if err != nil {
+			return nil, err
+		}
+		w, err := widthOf(b)
+		if err != nil {
+			return nil, err
+		}
+		return number.FromBigInt(wrapInteger(x, w, signed)), nil
+	}, func(a, b *numrange.Range) (types.Value, error) {
+		if !b.IsSingleton() {
+			return nil, lisperr.NotImplemented("wrapping to a range of widths")
+		}
+		w, err := widthOf(b.LowerBound())
+		if err != nil {
+			return nil, err
+		}
+		ia, err := integerBounds(a)
+		if err != nil {
+			return nil, err
+		}
+		return wrapBounds(ia, w, signed)
+	})
+}
+
+// WrapUnsigned returns the unsigned integer of a number of bits that an
+// integer wraps around to.
+func WrapUnsigned(a, bits types.Value) (types.Value, error) {
+	return wrapWidth(a, bits, false)
+}
+
+// WrapSigned returns the signed (two's complement) integer of a number of
+// bits that an integer wraps around to.
+func WrapSigned(a, bits types.Value) (types.Value, error) {
+	return wrapWidth(a, bits, true)
+}
@@ -0,0 +1,132 @@
+package numerics
+
+import (
+	"math/big"
+	"math/rand"
+	"testing"
+)
+
+func randomIntRange(rng *rand.Rand) intRange {
+	bound := func() *big.Int {
+		if rng.Intn(6) == 0 {
+			return nil
+		}
+		return big.NewInt(rng.Int63n(1<<uint(rng.Intn(20)+1)) - rng.Int63n(1<<uint(rng.Intn(20)+1)))
+	}
+	r := intRange{bound(), bound()}
+	if r.low != nil && r.high != nil && r.low.Cmp(r.high) > 0 {
+		r.low, r.high = r.high, r.low
+	}
+	return r
+}
+
+// sampleInts returns some of the integers in a range.
+func sampleInts(rng *rand.Rand, r intRange) []*big.Int {
+	var rv []*big.Int
+	for _, x := range []*big.Int{r.low, r.high} {
+		if x != nil {
+			rv = append(rv, x)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		offset := big.NewInt(rng.Int63n(1 << uint(rng.Intn(24)+1)))
+		switch {
+		case r.low != nil && r.high != nil:
+			span := new(big.Int).Sub(r.high, r.low)
+			span.Add(span, big.NewInt(1))
+			rv = append(rv, offset.Add(r.low, offset.Mod(offset, span)))
+		case r.low != nil:
+			rv = append(rv, offset.Add(r.low, offset))
+		case r.high != nil:
+			rv = append(rv, offset.Sub(r.high, offset))
+		default:
+			rv = append(rv, offset.Sub(offset, big.NewInt(1<<23)))
+		}
+	}
+	return rv
+}
+
+func inIntRange(r intRange, x *big.Int) bool {
+	return (r.low == nil || r.low.Cmp(x) <= 0) && (r.high == nil || x.Cmp(r.high) <= 0)
+}
+
+func TestBitwiseBoundsAreSound(t *testing.T) {
+	testcases := []struct {
+		name   string
+		f      func(x, y *big.Int) *big.Int
+		bounds func(a, b intRange) intRange
+	}{
+		{"bit-and", func(x, y *big.Int) *big.Int { return new(big.Int).And(x, y) }, andBounds},
+		{"bit-or", func(x, y *big.Int) *big.Int { return new(big.Int).Or(x, y) }, orBounds},
+		{"bit-xor", func(x, y *big.Int) *big.Int { return new(big.Int).Xor(x, y) }, xorBounds},
+		{"shift-left", func(x, k *big.Int) *big.Int {
+			rv, _ := shift(x, k)
+			return rv
+		}, shiftBounds},
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	for _, testcase := range testcases {
+		for i := 0; i < 2000; i++ {
+			a, b := randomIntRange(rng), randomIntRange(rng)
+			rv := testcase.bounds(a, b)
+			for _, x := range sampleInts(rng, a) {
+				for _, y := range sampleInts(rng, b) {
+					if testcase.name == "shift-left" && y.Cmp(big.NewInt(maxShift)) > 0 {
+						continue
+					}
+					if got := testcase.f(x, y); !inIntRange(rv, got) {
+						t.Fatalf("%s of [%v,%v] and [%v,%v] bounded by [%v,%v]: missing %s(%v, %v) = %v", testcase.name, a.low, a.high, b.low, b.high, rv.low, rv.high, testcase.name, x, y, got)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestUnaryBitwiseBoundsAreSound(t *testing.T) {
+	testcases := []struct {
+		name   string
+		f      func(x *big.Int) *big.Int
+		bounds func(r intRange) intRange
+	}{
+		{"bit-not", bitNot, intRange.not},
+		{"popcount", popcount, popcountBounds},
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	for _, testcase := range testcases {
+		for i := 0; i < 2000; i++ {
+			r := randomIntRange(rng)
+			rv := testcase.bounds(r)
+			for _, x := range sampleInts(rng, r) {
+				if got := testcase.f(x); !inIntRange(rv, got) {
+					t.Fatalf("%s of [%v,%v] bounded by [%v,%v]: missing %s(%v) = %v", testcase.name, r.low, r.high, rv.low, rv.high, testcase.name, x, got)
+				}
+			}
+		}
+	}
+}
+
+func TestWrapInteger(t *testing.T) {
+	testcases := []struct {
+		x      int64
+		bits   uint
+		signed bool
+		want   int64
+	}{
+		{300, 8, false, 44},
+		{-1, 8, false, 255},
+		{200, 8, true, -56},
+		{-129, 8, true, 127},
+		{127, 8, true, 127},
+		{1 << 32, 32, true, 0},
+	}
+
+	for _, testcase := range testcases {
+		got := wrapInteger(big.NewInt(testcase.x), testcase.bits, testcase.signed)
+		if got.Cmp(big.NewInt(testcase.want)) != 0 {
+			t.Errorf("wrapInteger(%d, %d, %v) = %v want %d", testcase.x, testcase.bits, testcase.signed, got, testcase.want)
+		}
+	}
+}
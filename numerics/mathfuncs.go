@@ -0,0 +1,793 @@
+package numerics
+
+import (
+	"math"
+	"math/big"
+
+	"github.com/steinarvk/heisenlisp/lisperr"
+	"github.com/steinarvk/heisenlisp/number"
+	"github.com/steinarvk/heisenlisp/numcmp"
+	"github.com/steinarvk/heisenlisp/numrange"
+	"github.com/steinarvk/heisenlisp/types"
+	"github.com/steinarvk/heisenlisp/value/integer"
+	"github.com/steinarvk/heisenlisp/value/unknowns/anyof"
+	"github.com/steinarvk/heisenlisp/value/unknowns/fullyunknown"
+	"github.com/steinarvk/heisenlisp/value/unknowns/maythrow"
+	"github.com/steinarvk/heisenlisp/value/unknowns/numinrange"
+)
+
+// The mathematical functions below work like the arithmetic operators: on
+// each possible value of an any-of, and on the bounds of a range. A
+// monotonic function maps the bounds of a range to the bounds of its
+// result; the others also account for where they turn.
+
+func wrapUnary(a types.Value, onNumeric func(types.Numeric) (types.Value, error), onRange func(*numrange.Range) (types.Value, error)) (types.Value, error) {
+	if fullyunknown.Is(a) {
+		return fullyunknown.Value, nil
+	}
+
+	if anyof.Is(a) {
+		av, _ := anyof.PossibleValues(a)
+		var results []types.Value
+		for _, v := range av {
+			rv, err := wrapUnary(v, onNumeric, onRange)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, rv)
+		}
+		return anyof.New(results)
+	}
+
+	if r, ok := numinrange.ToRange(a); ok {
+		return onRange(r)
+	}
+
+	n, ok := a.(types.Numeric)
+	if !ok {
+		return nil, lisperr.UnexpectedValue{"number", a}
+	}
+	return onNumeric(n)
+}
+
+func wrapBinaryWithRanges(a, b types.Value, onNumerics func(a, b types.Numeric) (types.Value, error), onRanges func(a, b *numrange.Range) (types.Value, error)) (types.Value, error) {
+	if fullyunknown.Is(a) || fullyunknown.Is(b) {
+		return fullyunknown.Value, nil
+	}
+
+	return wrapBinary(a, b, castingToValue(wrappedWithRanges(func(a, b types.Numeric) (interface{}, error) {
+		return onNumerics(a, b)
+	}, func(a, b *numrange.Range) (interface{}, error) {
+		return onRanges(a, b)
+	})))
+}
+
+// fromRange returns a range as a value: a number if it has only one.
+func fromRange(r *numrange.Range, typenames []string) (types.Value, error) {
+	if r.IsSingleton() && r.LowerBoundInclusive() && r.UpperBoundInclusive() {
+		return r.LowerBound(), nil
+	}
+	return numinrange.New(r.LowerBound(), r.UpperBound(), r.LowerBoundInclusive(), r.UpperBoundInclusive(), typenames)
+}
+
+// fromIntegerRange returns a range of integers as a value: an any-of of
+// them if there are few enough.
+func fromIntegerRange(r *numrange.Range) (types.Value, error) {
+	low, high := r.LowerBound(), r.UpperBound()
+	if low != nil && high != nil {
+		count, err := BinaryMinus(high, low)
+		if err != nil {
+			return nil, err
+		}
+		if n, ok := count.(types.Numeric).AsInt64(); ok && n < anyof.MaxAnyOfElements {
+			var xs []types.Value
+			for i := int64(0); i <= n; i++ {
+				x, err := BinaryPlus(low, integer.FromInt64(i))
+				if err != nil {
+					return nil, err
+				}
+				xs = append(xs, x)
+			}
+			return anyof.New(xs)
+		}
+	}
+	return fromRange(r, []string{integer.TypeName})
+}
+
+// rangeValue returns a range as a value, for error messages.
+func rangeValue(r *numrange.Range) types.Value {
+	rv, err := fromRange(r, nil)
+	if err != nil {
+		panic(err)
+	}
+	return rv
+}
+
+// positivePart returns the part of a range above zero (or at zero, if
+// inclusive), or nil if there is none.
+func positivePart(r *numrange.Range, inclusive bool) *numrange.Range {
+	if high := r.UpperBound(); high != nil {
+		c := compareZero(high)
+		if c < 0 || (c == 0 && !(inclusive && r.UpperBoundInclusive())) {
+			return nil
+		}
+	}
+	if low := r.LowerBound(); low != nil {
+		c := compareZero(low)
+		if c > 0 || (c == 0 && (inclusive || !r.LowerBoundInclusive())) {
+			return r
+		}
+	}
+	return numrange.New(zeroValueNumeric, r.UpperBound(), inclusive, r.UpperBoundInclusive())
+}
+
+// outsideDomain makes the result of a function over the part of a range in
+// its domain one that may throw, if the range reaches outside the domain.
+func outsideDomain(result types.Value, r, domain *numrange.Range, expectation string) types.Value {
+	if domain == r {
+		return result
+	}
+	return maythrow.New(result, []error{lisperr.UnexpectedValue{expectation, rangeValue(r)}})
+}
+
+func compareZero(n types.Numeric) int {
+	return numcmp.CompareOrPanic(n, zeroValueNumeric)
+}
+
+func negate(n types.Numeric) types.Numeric {
+	rv, err := BinaryMinus(zeroValueNumeric, n)
+	if err != nil {
+		panic(err)
+	}
+	return rv.(types.Numeric)
+}
+
+func onFloat(f func(float64) float64) func(types.Numeric) (types.Numeric, error) {
+	return func(n types.Numeric) (types.Numeric, error) {
+		x, ok := n.AsDouble()
+		if !ok {
+			return nil, lisperr.UnexpectedValue{"number", n}
+		}
+		return number.FromFloat64(f(x)), nil
+	}
+}
+
+func asValue(f func(types.Numeric) (types.Numeric, error)) func(types.Numeric) (types.Value, error) {
+	return func(n types.Numeric) (types.Value, error) {
+		return f(n)
+	}
+}
+
+// nudgeOutward moves a finite float bound one step away from the range
+// (up if upper), since the floating-point functions are not exact.
+func nudgeOutward(n types.Numeric, upper bool) types.Numeric {
+	if _, ok := n.AsBigrat(); ok {
+		return n
+	}
+	x, ok := n.AsDouble()
+	if !ok || math.IsNaN(x) || math.IsInf(x, 0) {
+		return n
+	}
+	if upper {
+		return number.FromFloat64(math.Nextafter(x, math.Inf(1)))
+	}
+	return number.FromFloat64(math.Nextafter(x, math.Inf(-1)))
+}
+
+// mapIncreasing returns the range of a (non-strictly, unless strict)
+// increasing function over a range; an infinite bound maps to the limit of
+// the function there (nil if infinite).
+func mapIncreasing(r *numrange.Range, f func(types.Numeric) (types.Numeric, error), strict bool, atNegInf, atPosInf valueWithInclusion) (*numrange.Range, error) {
+	low, high := atNegInf, atPosInf
+	if r.LowerBound() != nil {
+		v, err := f(r.LowerBound())
+		if err != nil {
+			return nil, err
+		}
+		low = valueWithInclusion{nudgeOutward(v, false), r.LowerBoundInclusive() || !strict}
+	}
+	if r.UpperBound() != nil {
+		v, err := f(r.UpperBound())
+		if err != nil {
+			return nil, err
+		}
+		high = valueWithInclusion{nudgeOutward(v, true), r.UpperBoundInclusive() || !strict}
+	}
+	return numrange.New(low.val, high.val, low.inclusive, high.inclusive), nil
+}
+
+var unbounded = valueWithInclusion{}
+
+func absNumeric(n types.Numeric) (types.Numeric, error) {
+	if compareZero(n) < 0 {
+		return negate(n), nil
+	}
+	return n, nil
+}
+
+func absRange(r *numrange.Range) *numrange.Range {
+	low, high := r.LowerBound(), r.UpperBound()
+	switch {
+	case low != nil && compareZero(low) >= 0:
+		return r
+	case high != nil && compareZero(high) <= 0:
+		var negLow types.Numeric
+		if low != nil {
+			negLow = negate(low)
+		}
+		return numrange.New(negate(high), negLow, r.UpperBoundInclusive(), r.LowerBoundInclusive())
+	case low == nil || high == nil:
+		return numrange.New(zeroValueNumeric, nil, true, false)
+	}
+
+	top := valueWithInclusion{high, r.UpperBoundInclusive()}
+	switch numcmp.CompareOrPanic(negate(low), high) {
+	case numcmp.Greater:
+		top = valueWithInclusion{negate(low), r.LowerBoundInclusive()}
+	case numcmp.Equal:
+		top.inclusive = top.inclusive || r.LowerBoundInclusive()
+	}
+	return numrange.New(zeroValueNumeric, top.val, true, top.inclusive)
+}
+
+// Abs returns the absolute value of a number.
+func Abs(a types.Value) (types.Value, error) {
+	return wrapUnary(a, asValue(absNumeric), func(r *numrange.Range) (types.Value, error) {
+		return fromRange(absRange(r), nil)
+	})
+}
+
+func sqrNumeric(n types.Numeric) (types.Numeric, error) {
+	return numericMulOrPanic(n, n), nil
+}
+
+// Sqr returns the square of a number.
+func Sqr(a types.Value) (types.Value, error) {
+	return wrapUnary(a, asValue(sqrNumeric), func(r *numrange.Range) (types.Value, error) {
+		// Squaring is increasing for the absolute values.
+		rv, err := mapIncreasing(absRange(r), sqrNumeric, true, unbounded, unbounded)
+		if err != nil {
+			return nil, err
+		}
+		return fromRange(rv, nil)
+	})
+}
+
+// toInteger returns the integer closest to the float (which must be an
+// integer already), or an error if there is none.
+func toInteger(x float64) (types.Numeric, error) {
+	if math.IsNaN(x) || math.IsInf(x, 0) {
+		return nil, lisperr.UnexpectedValue{"finite number", number.FromFloat64(x)}
+	}
+	if x >= math.MinInt64 && x < math.MaxInt64 {
+		return integer.FromInt64(int64(x)), nil
+	}
+	i, _ := new(big.Float).SetFloat64(x).Int(nil)
+	return number.FromBigInt(i), nil
+}
+
+// integerStep returns a function rounding numbers to integers: exactly
+// with onRat, or with onFloat.
+func integerStep(onRat func(*big.Rat) *big.Int, onFloat func(float64) float64) func(types.Numeric) (types.Numeric, error) {
+	return func(n types.Numeric) (types.Numeric, error) {
+		if r, ok := n.AsBigrat(); ok {
+			return number.FromBigInt(onRat(r)), nil
+		}
+		x, ok := n.AsDouble()
+		if !ok {
+			return nil, lisperr.UnexpectedValue{"number", n}
+		}
+		return toInteger(onFloat(x))
+	}
+}
+
+func wrapIntegerStep(a types.Value, f func(types.Numeric) (types.Numeric, error)) (types.Value, error) {
+	return wrapUnary(a, asValue(f), func(r *numrange.Range) (types.Value, error) {
+		rv, err := mapIncreasing(r, f, false, unbounded, unbounded)
+		if err != nil {
+			return nil, err
+		}
+		return fromIntegerRange(rv)
+	})
+}
+
+// Division of big.Ints is Euclidean, and denominators of big.Rats are
+// positive, so dividing a numerator by its denominator gives the floor.
+
+func ratFloor(r *big.Rat) *big.Int {
+	return new(big.Int).Div(r.Num(), r.Denom())
+}
+
+func ratCeiling(r *big.Rat) *big.Int {
+	return new(big.Int).Neg(ratFloor(new(big.Rat).Neg(r)))
+}
+
+func ratTruncate(r *big.Rat) *big.Int {
+	return new(big.Int).Quo(r.Num(), r.Denom())
+}
+
+// ratRound rounds to the nearest integer, and halfway cases to the even one.
+func ratRound(r *big.Rat) *big.Int {
+	floor := ratFloor(r)
+	twiceFraction := new(big.Int).Mul(floor, r.Denom())
+	twiceFraction.Sub(r.Num(), twiceFraction)
+	twiceFraction.Lsh(twiceFraction, 1)
+
+	switch twiceFraction.Cmp(r.Denom()) {
+	case -1:
+		return floor
+	case 0:
+		if floor.Bit(0) == 0 {
+			return floor
+		}
+	}
+	return floor.Add(floor, big.NewInt(1))
+}
+
+var (
+	floorNumeric    = integerStep(ratFloor, math.Floor)
+	ceilingNumeric  = integerStep(ratCeiling, math.Ceil)
+	roundNumeric    = integerStep(ratRound, math.RoundToEven)
+	truncateNumeric = integerStep(ratTruncate, math.Trunc)
+)
+
+// Floor returns the greatest integer not greater than a number.
+func Floor(a types.Value) (types.Value, error) {
+	return wrapIntegerStep(a, floorNumeric)
+}
+
+// Ceiling returns the least integer not less than a number.
+func Ceiling(a types.Value) (types.Value, error) {
+	return wrapIntegerStep(a, ceilingNumeric)
+}
+
+// Round returns the integer nearest a number, or the even one if there are
+// two.
+func Round(a types.Value) (types.Value, error) {
+	return wrapIntegerStep(a, roundNumeric)
+}
+
+// Truncate returns a number without its fractional part.
+func Truncate(a types.Value) (types.Value, error) {
+	return wrapIntegerStep(a, truncateNumeric)
+}
+
+// exactSqrt returns the square root of a non-negative integer, if it is an
+// integer.
+func exactSqrt(n *big.Int) (*big.Int, bool) {
+	rv := new(big.Int).Sqrt(n)
+	return rv, new(big.Int).Mul(rv, rv).Cmp(n) == 0
+}
+
+func sqrtNumeric(n types.Numeric) (types.Numeric, error) {
+	if compareZero(n) < 0 {
+		return nil, lisperr.UnexpectedValue{"non-negative number", n}
+	}
+	if r, ok := n.AsBigrat(); ok {
+		num, ok1 := exactSqrt(r.Num())
+		denom, ok2 := exactSqrt(r.Denom())
+		if ok1 && ok2 {
+			return number.FromBigRat(new(big.Rat).SetFrac(num, denom)), nil
+		}
+	}
+	return onFloat(math.Sqrt)(n)
+}
+
+// Sqrt returns the square root of a non-negative number: exact if the
+// number is the square of an exact number.
+func Sqrt(a types.Value) (types.Value, error) {
+	return wrapUnary(a, asValue(sqrtNumeric), func(r *numrange.Range) (types.Value, error) {
+		// Only the non-negative part of the range has a square root; the
+		// rest may throw.
+		nonNegative := positivePart(r, true)
+		if nonNegative == nil {
+			return nil, lisperr.UnexpectedValue{"non-negative number", rangeValue(r)}
+		}
+		rv, err := mapIncreasing(nonNegative, sqrtNumeric, true, unbounded, unbounded)
+		if err != nil {
+			return nil, err
+		}
+		result, err := fromRange(rv, nil)
+		if err != nil {
+			return nil, err
+		}
+		return outsideDomain(result, r, nonNegative, "non-negative number"), nil
+	})
+}
+
+var expNumeric = onFloat(math.Exp)
+
+// Exp returns e raised to the power of a number.
+func Exp(a types.Value) (types.Value, error) {
+	return wrapUnary(a, asValue(expNumeric), func(r *numrange.Range) (types.Value, error) {
+		rv, err := mapIncreasing(r, expNumeric, true, valueWithInclusion{zeroValueNumeric, false}, unbounded)
+		if err != nil {
+			return nil, err
+		}
+		return fromRange(rv, nil)
+	})
+}
+
+func logNumeric(n types.Numeric) (types.Numeric, error) {
+	if compareZero(n) <= 0 {
+		return nil, lisperr.UnexpectedValue{"positive number", n}
+	}
+	return onFloat(math.Log)(n)
+}
+
+// Log returns the natural logarithm of a positive number.
+func Log(a types.Value) (types.Value, error) {
+	return wrapUnary(a, asValue(logNumeric), func(r *numrange.Range) (types.Value, error) {
+		positive := positivePart(r, false)
+		if positive == nil {
+			return nil, lisperr.UnexpectedValue{"positive number", rangeValue(r)}
+		}
+		domain := positive
+		// The logarithm tends to -inf towards zero.
+		if compareZero(positive.LowerBound()) == 0 {
+			positive = numrange.New(nil, positive.UpperBound(), false, positive.UpperBoundInclusive())
+		}
+		rv, err := mapIncreasing(positive, logNumeric, true, unbounded, unbounded)
+		if err != nil {
+			return nil, err
+		}
+		result, err := fromRange(rv, nil)
+		if err != nil {
+			return nil, err
+		}
+		return outsideDomain(result, r, domain, "positive number"), nil
+	})
+}
+
+// periodicRange returns the range of a function with period 2*pi, a maximum
+// of 1 at maxAt and a minimum of -1 at minAt, over a range.
+func periodicRange(r *numrange.Range, f func(float64) float64, maxAt, minAt float64) (*numrange.Range, error) {
+	full := numrange.New(number.FromFloat64(-1), number.FromFloat64(1), true, true)
+	if r.LowerBound() == nil || r.UpperBound() == nil {
+		return full, nil
+	}
+
+	low, _ := r.LowerBound().AsDouble()
+	high, _ := r.UpperBound().AsDouble()
+	if high-low >= 2*math.Pi {
+		return full, nil
+	}
+
+	// The first point at or after low where the function is at offset.
+	reaches := func(offset float64) bool {
+		k := math.Ceil((low - offset) / (2 * math.Pi))
+		return offset+2*math.Pi*k <= high
+	}
+
+	top := math.Max(f(low), f(high))
+	bottom := math.Min(f(low), f(high))
+	if reaches(maxAt) {
+		top = 1
+	}
+	if reaches(minAt) {
+		bottom = -1
+	}
+	top = math.Min(1, math.Nextafter(top, math.Inf(1)))
+	bottom = math.Max(-1, math.Nextafter(bottom, math.Inf(-1)))
+	return numrange.New(number.FromFloat64(bottom), number.FromFloat64(top), true, true), nil
+}
+
+// Sin returns the sine of a number.
+func Sin(a types.Value) (types.Value, error) {
+	return wrapUnary(a, asValue(onFloat(math.Sin)), func(r *numrange.Range) (types.Value, error) {
+		rv, err := periodicRange(r, math.Sin, math.Pi/2, -math.Pi/2)
+		if err != nil {
+			return nil, err
+		}
+		return fromRange(rv, nil)
+	})
+}
+
+// Cos returns the cosine of a number.
+func Cos(a types.Value) (types.Value, error) {
+	return wrapUnary(a, asValue(onFloat(math.Cos)), func(r *numrange.Range) (types.Value, error) {
+		rv, err := periodicRange(r, math.Cos, 0, math.Pi)
+		if err != nil {
+			return nil, err
+		}
+		return fromRange(rv, nil)
+	})
+}
+
+var atanNumeric = onFloat(math.Atan)
+
+// Atan returns the arctangent of a number.
+func Atan(a types.Value) (types.Value, error) {
+	return wrapUnary(a, asValue(atanNumeric), func(r *numrange.Range) (types.Value, error) {
+		rv, err := mapIncreasing(r, atanNumeric, true,
+			valueWithInclusion{nudgeOutward(number.FromFloat64(-math.Pi/2), false), false},
+			valueWithInclusion{nudgeOutward(number.FromFloat64(math.Pi/2), true), false})
+		if err != nil {
+			return nil, err
+		}
+		return fromRange(rv, nil)
+	})
+}
+
+// compareBounds compares bounds of ranges, for which nil is -inf if they are
+// lower bounds, and inf if they are upper bounds.
+func compareBounds(a, b types.Numeric, lower bool) int {
+	infinite := numcmp.Greater
+	if lower {
+		infinite = numcmp.Less
+	}
+	switch {
+	case a == nil && b == nil:
+		return numcmp.Equal
+	case a == nil:
+		return infinite
+	case b == nil:
+		return -infinite
+	}
+	return numcmp.CompareOrPanic(a, b)
+}
+
+// chooseBound returns the lesser (or greater) of two lower (or upper)
+// bounds. Of equal bounds, the result is inclusive if either is when it is
+// the outer bound (the lesser lower bound or greater upper bound), or else if
+// both are.
+func chooseBound(a, b valueWithInclusion, lower, lesser bool) valueWithInclusion {
+	c := compareBounds(a.val, b.val, lower)
+	if !lesser {
+		c = -c
+	}
+	switch {
+	case c < 0:
+		return a
+	case c > 0:
+		return b
+	case lesser == lower:
+		return valueWithInclusion{a.val, a.inclusive || b.inclusive}
+	default:
+		return valueWithInclusion{a.val, a.inclusive && b.inclusive}
+	}
+}
+
+func extremeRange(a, b *numrange.Range, lesser bool) (types.Value, error) {
+	low := chooseBound(
+		valueWithInclusion{a.LowerBound(), a.LowerBoundInclusive()},
+		valueWithInclusion{b.LowerBound(), b.LowerBoundInclusive()},
+		true, lesser)
+	high := chooseBound(
+		valueWithInclusion{a.UpperBound(), a.UpperBoundInclusive()},
+		valueWithInclusion{b.UpperBound(), b.UpperBoundInclusive()},
+		false, lesser)
+	return fromRange(numrange.New(low.val, high.val, low.inclusive, high.inclusive), nil)
+}
+
+// BinaryMin returns the lesser of two numbers (the first if they are equal).
+func BinaryMin(a, b types.Value) (types.Value, error) {
+	return wrapBinaryWithRanges(a, b, func(a, b types.Numeric) (types.Value, error) {
+		if numcmp.CompareOrPanic(b, a) == numcmp.Less {
+			return b, nil
+		}
+		return a, nil
+	}, func(a, b *numrange.Range) (types.Value, error) {
+		return extremeRange(a, b, true)
+	})
+}
+
+// BinaryMax returns the greater of two numbers (the first if they are
+// equal).
+func BinaryMax(a, b types.Value) (types.Value, error) {
+	return wrapBinaryWithRanges(a, b, func(a, b types.Numeric) (types.Value, error) {
+		if numcmp.CompareOrPanic(b, a) == numcmp.Greater {
+			return b, nil
+		}
+		return a, nil
+	}, func(a, b *numrange.Range) (types.Value, error) {
+		return extremeRange(a, b, false)
+	})
+}
+
+// maxPowerBits is the most bits an exact power may have.
+const maxPowerBits = 1 << 20
+
+func exptNumeric(base, power types.Numeric) (types.Numeric, error) {
+	if p, ok := power.AsBigint(); ok && !p.IsInt64() {
+		if r, ok := base.AsBigrat(); ok {
+			// Only the powers of 0, 1 and -1 are not too large (or small) to
+			// write down.
+			switch {
+			case r.Sign() == 0 && p.Sign() > 0:
+				return zeroValueNumeric, nil
+			case r.Sign() == 0:
+				return nil, lisperr.DivisionByZero
+			case r.Cmp(big.NewRat(1, 1)) == 0:
+				return oneValueNumeric, nil
+			case r.Cmp(big.NewRat(-1, 1)) == 0:
+				if p.Bit(0) == 0 {
+					return oneValueNumeric, nil
+				}
+				return integer.FromInt64(-1), nil
+			}
+			return nil, lisperr.NotImplemented("exact power with an exponent too large")
+		}
+	}
+
+	if p, ok := power.AsInt64(); ok {
+		if r, ok := base.AsBigrat(); ok {
+			if r.Sign() == 0 && p < 0 {
+				return nil, lisperr.DivisionByZero
+			}
+			exp := big.NewInt(p)
+			exp.Abs(exp)
+			// The power of an n-bit number has at least (n-1)*|p| bits.
+			bits := r.Num().BitLen()
+			if denomBits := r.Denom().BitLen(); denomBits > bits {
+				bits = denomBits
+			}
+			if bits > 1 && exp.Cmp(big.NewInt(maxPowerBits/int64(bits-1))) > 0 {
+				return nil, lisperr.NotImplemented("exact power too large")
+			}
+			num := new(big.Int).Exp(r.Num(), exp, nil)
+			denom := new(big.Int).Exp(r.Denom(), exp, nil)
+			if p < 0 {
+				num, denom = denom, num
+			}
+			return number.FromBigRat(new(big.Rat).SetFrac(num, denom)), nil
+		}
+	}
+
+	b, ok1 := base.AsDouble()
+	p, ok2 := power.AsDouble()
+	if !ok1 || !ok2 {
+		return nil, lisperr.UnexpectedValue{"number", power}
+	}
+	rv := math.Pow(b, p)
+	if math.IsNaN(rv) && !math.IsNaN(b) && !math.IsNaN(p) {
+		return nil, lisperr.UnexpectedValue{"non-negative base for non-integer power", base}
+	}
+	return number.FromFloat64(rv), nil
+}
+
+func exptRange(a, b *numrange.Range) (types.Value, error) {
+	if b.IsSingleton() {
+		if p, ok := b.LowerBound().AsInt64(); ok {
+			power := func(n types.Numeric) (types.Numeric, error) {
+				return exptNumeric(n, integer.FromInt64(p))
+			}
+
+			switch {
+			case p == 0:
+				return oneValueNumeric, nil
+			case p < 0:
+				positive, err := exptRange(a, numrange.NewSingleton(integer.FromInt64(-p)))
+				if err != nil {
+					return nil, err
+				}
+				return BinaryDivision(oneValueNumeric, positive)
+			case p%2 == 0:
+				// Even powers are increasing for the absolute values.
+				a = absRange(a)
+			}
+
+			rv, err := mapIncreasing(a, power, true, unbounded, unbounded)
+			if err != nil {
+				return nil, err
+			}
+			return fromRange(rv, nil)
+		}
+	}
+
+	// A power of a positive base is monotonic in both the base and the
+	// exponent, so its extremes are at the corners.
+	bounded := a.LowerBound() != nil && a.UpperBound() != nil && b.LowerBound() != nil && b.UpperBound() != nil
+	if !bounded || compareZero(a.LowerBound()) <= 0 {
+		return nil, lisperr.NotImplemented("expt of a range with a non-integer exponent, unless both are bounded and the base positive")
+	}
+
+	var vals []valueWithInclusion
+	for _, base := range []valueWithInclusion{{a.LowerBound(), a.LowerBoundInclusive()}, {a.UpperBound(), a.UpperBoundInclusive()}} {
+		for _, power := range []valueWithInclusion{{b.LowerBound(), b.LowerBoundInclusive()}, {b.UpperBound(), b.UpperBoundInclusive()}} {
+			v, err := exptNumeric(base.val, power.val)
+			if err != nil {
+				return nil, err
+			}
+			vals = append(vals, valueWithInclusion{v, base.inclusive && power.inclusive})
+		}
+	}
+	low, high := extremes(vals)
+	return fromRange(numrange.New(nudgeOutward(low.val, false), nudgeOutward(high.val, true), low.inclusive, high.inclusive), nil)
+}
+
+// Expt returns a number raised to a power: exact if the number is exact and
+// the power an integer.
+func Expt(base, power types.Value) (types.Value, error) {
+	return wrapBinaryWithRanges(base, power, func(a, b types.Numeric) (types.Value, error) {
+		return exptNumeric(a, b)
+	}, exptRange)
+}
+
+func toBigint(n types.Numeric) (*big.Int, error) {
+	i, ok := n.AsBigint()
+	if !ok {
+		return nil, lisperr.UnexpectedValue{"integer", n}
+	}
+	return i, nil
+}
+
+func gcdNumeric(a, b types.Numeric) (*big.Int, error) {
+	x, err := toBigint(a)
+	if err != nil {
+		return nil, err
+	}
+	y, err := toBigint(b)
+	if err != nil {
+		return nil, err
+	}
+	x = new(big.Int).Abs(x)
+	y = new(big.Int).Abs(y)
+	return new(big.Int).GCD(nil, nil, x, y), nil
+}
+
+// maxAbs returns the greatest absolute value in a range, or nil if there is
+// none.
+func maxAbs(r *numrange.Range) types.Numeric {
+	return absRange(r).UpperBound()
+}
+
+func mayBeZero(r *numrange.Range) bool {
+	return r.Contains(zeroValueNumeric)
+}
+
+// BinaryGcd returns the greatest common divisor of two integers.
+func BinaryGcd(a, b types.Value) (types.Value, error) {
+	return wrapBinaryWithRanges(a, b, func(a, b types.Numeric) (types.Value, error) {
+		rv, err := gcdNumeric(a, b)
+		if err != nil {
+			return nil, err
+		}
+		return number.FromBigInt(rv), nil
+	}, func(a, b *numrange.Range) (types.Value, error) {
+		// A divisor of a non-zero number is no greater than it, and the
+		// greatest common divisor of zero and a number is the number.
+		low := zeroValueNumeric
+		var high types.Numeric
+		switch {
+		case !mayBeZero(a) && !mayBeZero(b):
+			low = oneValueNumeric
+			high = chooseBound(valueWithInclusion{maxAbs(a), true}, valueWithInclusion{maxAbs(b), true}, false, true).val
+		case !mayBeZero(a) || !mayBeZero(b):
+			low = oneValueNumeric
+			fallthrough
+		default:
+			high = chooseBound(valueWithInclusion{maxAbs(a), true}, valueWithInclusion{maxAbs(b), true}, false, false).val
+		}
+		return fromIntegerRange(numrange.New(low, high, true, high != nil))
+	})
+}
+
+// BinaryLcm returns the least common multiple of two integers.
+func BinaryLcm(a, b types.Value) (types.Value, error) {
+	return wrapBinaryWithRanges(a, b, func(a, b types.Numeric) (types.Value, error) {
+		gcd, err := gcdNumeric(a, b)
+		if err != nil {
+			return nil, err
+		}
+		if gcd.Sign() == 0 {
+			return zeroValueNumeric, nil
+		}
+		x, _ := a.AsBigint()
+		y, _ := b.AsBigint()
+		rv := new(big.Int).Mul(x, y)
+		rv.Abs(rv).Quo(rv, gcd)
+		return number.FromBigInt(rv), nil
+	}, func(a, b *numrange.Range) (types.Value, error) {
+		// The least common multiple is a divisor of the product.
+		low := zeroValueNumeric
+		if !mayBeZero(a) && !mayBeZero(b) {
+			low = oneValueNumeric
+		}
+		var high types.Numeric
+		if x, y := maxAbs(a), maxAbs(b); x != nil && y != nil {
+			high = numericMulOrPanic(x, y)
+		}
+		return fromIntegerRange(numrange.New(low, high, true, high != nil))
+	})
+}
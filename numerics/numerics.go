@@ -1,8 +1,8 @@
 package numerics
 
 import (
-	"errors"
 	"fmt"
+	"math"
 	"math/big"
 
 	"github.com/steinarvk/heisenlisp/lisperr"
@@ -13,7 +13,6 @@ import (
 	"github.com/steinarvk/heisenlisp/types"
 	"github.com/steinarvk/heisenlisp/unknown"
 	"github.com/steinarvk/heisenlisp/value/boolean"
-	"github.com/steinarvk/heisenlisp/value/integer"
 
 	"github.com/steinarvk/heisenlisp/value/unknowns/anyof"
 	"github.com/steinarvk/heisenlisp/value/unknowns/fullyunknown"
@@ -53,17 +52,7 @@ func wrapBinary(a, b types.Value, f func(a, b types.Value) (types.Value, error))
 	bv, ok2 := anyof.PossibleValues(b)
 	if ok1 && ok2 {
 		if len(av) > 1 || len(bv) > 1 {
-			var rv []types.Value
-			for _, ra := range av {
-				for _, rb := range bv {
-					res, err := f(ra, rb)
-					if err != nil {
-						return nil, err
-					}
-					rv = append(rv, res)
-				}
-			}
-			return anyof.New(rv)
+			return anyof.MapProduct(av, bv, f)
 		}
 	}
 
@@ -117,22 +106,6 @@ func toBinaryNumeric(f func(types.Numeric, types.Numeric) (types.Value, error))
 	}
 }
 
-func toBinaryInt64(f func(int64, int64) (types.Value, error)) func(types.Value, types.Value) (types.Value, error) {
-	return toBinaryNumeric(func(a, b types.Numeric) (types.Value, error) {
-		an, ok := a.AsInt64()
-		if !ok {
-			return nil, fmt.Errorf("not an int64: %v", a)
-		}
-
-		bn, ok := b.AsInt64()
-		if !ok {
-			return nil, fmt.Errorf("not an int64: %v", b)
-		}
-
-		return f(an, bn)
-	})
-}
-
 func toBinaryTower(onInts func(int64, int64) (types.Value, error), onDoubles func(float64, float64) (types.Value, error)) func(types.Value, types.Value) (types.Value, error) {
 	return toBinaryNumeric(func(a, b types.Numeric) (types.Value, error) {
 		aInt, ok := a.AsInt64()
@@ -151,10 +124,6 @@ func toBinaryTower(onInts func(int64, int64) (types.Value, error), onDoubles fun
 	})
 }
 
-func fromInt64(n int64) types.Value {
-	return integer.FromInt64(n)
-}
-
 func areSmall(a, b int64) bool {
 	return int64(int32(a)) == a && int64(int32(b)) == b
 }
@@ -305,19 +274,103 @@ func BinaryDivision(a, b types.Value) (types.Value, error) {
 	return wrapBinary(a, b, binaryDivision)
 }
 
+var binaryRemainder func(a, b types.Value) (types.Value, error)
+
+func init() {
+	binaryRemainder = castingToValue(wrappedWithRanges(numtower.BinaryTowerFunc{
+		OnInt64s: func(a, b int64) (interface{}, error) {
+			if b == 0 {
+				return nil, lisperr.DivisionByZero
+			}
+			return number.FromInt64(a % b), nil
+		},
+		OnBigints: func(a, b *big.Int) (interface{}, error) {
+			if b.Sign() == 0 {
+				return nil, lisperr.DivisionByZero
+			}
+			return number.FromBigInt(new(big.Int).Rem(a, b)), nil
+		},
+		OnBigrats: func(a, b *big.Rat) (interface{}, error) {
+			if b.Sign() == 0 {
+				return nil, lisperr.DivisionByZero
+			}
+			q := new(big.Rat).SetInt(ratTruncate(new(big.Rat).Quo(a, b)))
+			return number.FromBigRat(q.Sub(a, q.Mul(q, b))), nil
+		},
+		OnFloat64s: func(a, b float64) (interface{}, error) {
+			if b == 0 {
+				return nil, lisperr.DivisionByZero
+			}
+			return number.FromFloat64(math.Mod(a, b)), nil
+		},
+	}.Call, func(a, b *numrange.Range) (interface{}, error) {
+		return rangeRemainder(a, b)
+	}))
+}
+
+// Mod returns the remainder of dividing a by b, truncating the quotient:
+// it has the sign of a, and a smaller absolute value than b.
 func Mod(a, b types.Value) (types.Value, error) {
 	if fullyunknown.Is(a) || fullyunknown.Is(b) {
 		return fullyunknown.Value, nil
 	}
 
-	return wrapBinary(a, b, toBinaryInt64(func(a, b int64) (types.Value, error) {
-		if b == 0 {
-			return nil, errors.New("division by zero")
+	return wrapBinary(a, b, binaryRemainder)
+}
+
+var binaryQuotient func(a, b types.Value) (types.Value, error)
+
+func init() {
+	binaryQuotient = castingToValue(wrappedWithRanges(numtower.BinaryTowerFunc{
+		OnInt64s: func(a, b int64) (interface{}, error) {
+			if b == 0 {
+				return nil, lisperr.DivisionByZero
+			}
+			if a == math.MinInt64 && b == -1 {
+				return number.FromBigInt(new(big.Int).Neg(big.NewInt(a))), nil
+			}
+			return number.FromInt64(a / b), nil
+		},
+		OnBigints: func(a, b *big.Int) (interface{}, error) {
+			if b.Sign() == 0 {
+				return nil, lisperr.DivisionByZero
+			}
+			return number.FromBigInt(new(big.Int).Quo(a, b)), nil
+		},
+		OnBigrats: func(a, b *big.Rat) (interface{}, error) {
+			if b.Sign() == 0 {
+				return nil, lisperr.DivisionByZero
+			}
+			return number.FromBigInt(ratTruncate(new(big.Rat).Quo(a, b))), nil
+		},
+		OnFloat64s: func(a, b float64) (interface{}, error) {
+			if b == 0 {
+				return nil, lisperr.DivisionByZero
+			}
+			return toInteger(math.Trunc(a / b))
+		},
+	}.Call, func(a, b *numrange.Range) (interface{}, error) {
+		if b.Contains(zeroValueNumeric) {
+			return nil, lisperr.DivisionByZero
 		}
-		return fromInt64(a % b), nil
+		q, err := rangeDiv(a, b)
+		if err != nil {
+			return nil, err
+		}
+		return Truncate(q)
 	}))
 }
 
+// Quotient returns the integer quotient of dividing a by b, truncated
+// towards zero.
+func Quotient(a, b types.Value) (types.Value, error) {
+	if fullyunknown.Is(a) || fullyunknown.Is(b) {
+		return fullyunknown.Value, nil
+	}
+
+	return wrapBinary(a, b, binaryQuotient)
+}
+
 var numericLeq = castingToValue(wrappedWithRanges(func(a, b types.Numeric) (interface{}, error) {
 	// <=
 	return boolean.FromBool(numrange.NewBelow(b, true).Contains(a)), nil
@@ -1,68 +1,128 @@
 package numerics
 
 import (
+	"math"
+	"math/big"
+
 	"github.com/steinarvk/heisenlisp/lisperr"
+	"github.com/steinarvk/heisenlisp/number"
 	"github.com/steinarvk/heisenlisp/numcmp"
 	"github.com/steinarvk/heisenlisp/numrange"
 	"github.com/steinarvk/heisenlisp/types"
 	"github.com/steinarvk/heisenlisp/value/integer"
+	"github.com/steinarvk/heisenlisp/value/unknowns/anyof"
+	"github.com/steinarvk/heisenlisp/value/unknowns/maythrow"
 	"github.com/steinarvk/heisenlisp/value/unknowns/numinrange"
 )
 
-func rangeAdd(a, b *numrange.Range) (types.Value, error) {
-	aHigh := a.UpperBound()
-	bHigh := b.UpperBound()
-	combinedHigh, err := BinaryPlus(aHigh, bHigh)
-	if err != nil {
-		return nil, err
+// Bounds of ranges are numbers, or nil for infinities: -inf as a lower
+// bound, and inf as an upper bound. Arithmetic on bounds rounds inexact float
+// results outward, away from the range, so that a range computed from others
+// contains the exact result of the operation on any of their numbers.
+
+// ratOf returns a finite number as an exact rational.
+func ratOf(n types.Numeric) (*big.Rat, bool) {
+	if r, ok := n.AsBigrat(); ok {
+		return r, true
+	}
+	x, ok := n.AsDouble()
+	if !ok || math.IsNaN(x) || math.IsInf(x, 0) {
+		return nil, false
 	}
+	return new(big.Rat).SetFloat64(x), true
+}
 
-	combinedHighIncl := a.UpperBoundInclusive() && b.UpperBoundInclusive()
+// roundOutward replaces a float result with the float nearest the exact
+// result, moved a step if needed to be no less than it if it is an upper
+// bound, and no greater if it is a lower bound. (The float result itself may
+// be several steps off, if the operands were converted to floats first.)
+func roundOutward(rv types.Numeric, exact *big.Rat, upper bool) types.Numeric {
+	if _, ok := rv.AsBigrat(); ok {
+		return rv
+	}
+	x, _ := exact.Float64()
 
-	aLow := a.LowerBound()
-	bLow := b.LowerBound()
-	combinedLow, err := BinaryPlus(aLow, bLow)
-	if err != nil {
-		return nil, err
+	c := 1
+	switch {
+	case math.IsInf(x, -1):
+		c = -1
+	case !math.IsInf(x, 1):
+		c = new(big.Rat).SetFloat64(x).Cmp(exact)
 	}
 
-	combinedLowIncl := a.LowerBoundInclusive() && b.LowerBoundInclusive()
+	switch {
+	case upper && c < 0:
+		x = math.Nextafter(x, math.Inf(1))
+	case !upper && c > 0:
+		x = math.Nextafter(x, math.Inf(-1))
+	}
+	return number.FromFloat64(x)
+}
 
-	// When we operate on two numerics, we should get a numeric back.
-	combinedLowNum := combinedLow.(types.Numeric)
-	combinedHighNum := combinedHigh.(types.Numeric)
+// boundOp applies an operation to finite bounds, rounding outward.
+func boundOp(a, b types.Numeric, upper bool, op func(a, b types.Value) (types.Value, error), exactOp func(z, x, y *big.Rat) *big.Rat) (types.Numeric, error) {
+	rv, err := op(a, b)
+	if err != nil {
+		return nil, err
+	}
 
-	return numinrange.New(combinedLowNum, combinedHighNum, combinedLowIncl, combinedHighIncl, nil)
+	x, ok1 := ratOf(a)
+	y, ok2 := ratOf(b)
+	if !ok1 || !ok2 {
+		return rv.(types.Numeric), nil
+	}
+	return roundOutward(rv.(types.Numeric), exactOp(new(big.Rat), x, y), upper), nil
 }
 
-func rangeSub(a, b *numrange.Range) (types.Value, error) {
-	aHigh := a.UpperBound()
-	bHigh := b.UpperBound()
+// newRange returns a range with the bounds; an infinite bound is exclusive.
+func newRange(low, high valueWithInclusion) (types.Value, error) {
+	return numinrange.New(low.val, high.val, low.val != nil && low.inclusive, high.val != nil && high.inclusive, nil)
+}
 
-	aLow := a.LowerBound()
-	bLow := b.LowerBound()
+func rangeAdd(a, b *numrange.Range) (types.Value, error) {
+	add := func(x, y types.Numeric, upper bool) (types.Numeric, error) {
+		if x == nil || y == nil {
+			return nil, nil
+		}
+		return boundOp(x, y, upper, BinaryPlus, (*big.Rat).Add)
+	}
 
-	// highest possibility: start with high, subtract low
-	combinedHigh, err := BinaryMinus(aHigh, bLow)
+	low, err := add(a.LowerBound(), b.LowerBound(), false)
+	if err != nil {
+		return nil, err
+	}
+	high, err := add(a.UpperBound(), b.UpperBound(), true)
 	if err != nil {
 		return nil, err
 	}
 
-	combinedHighIncl := a.UpperBoundInclusive() && b.LowerBoundInclusive()
+	return newRange(
+		valueWithInclusion{low, a.LowerBoundInclusive() && b.LowerBoundInclusive()},
+		valueWithInclusion{high, a.UpperBoundInclusive() && b.UpperBoundInclusive()})
+}
+
+func rangeSub(a, b *numrange.Range) (types.Value, error) {
+	sub := func(x, y types.Numeric, upper bool) (types.Numeric, error) {
+		if x == nil || y == nil {
+			return nil, nil
+		}
+		return boundOp(x, y, upper, BinaryMinus, (*big.Rat).Sub)
+	}
 
 	// lowest possibility: start with low, subtract high
-	combinedLow, err := BinaryMinus(aLow, bHigh)
+	low, err := sub(a.LowerBound(), b.UpperBound(), false)
+	if err != nil {
+		return nil, err
+	}
+	// highest possibility: start with high, subtract low
+	high, err := sub(a.UpperBound(), b.LowerBound(), true)
 	if err != nil {
 		return nil, err
 	}
 
-	combinedLowIncl := a.LowerBoundInclusive() && b.UpperBoundInclusive()
-
-	// When we operate on two numerics, we should get a numeric back.
-	combinedLowNum := combinedLow.(types.Numeric)
-	combinedHighNum := combinedHigh.(types.Numeric)
-
-	return numinrange.New(combinedLowNum, combinedHighNum, combinedLowIncl, combinedHighIncl, nil)
+	return newRange(
+		valueWithInclusion{low, a.LowerBoundInclusive() && b.UpperBoundInclusive()},
+		valueWithInclusion{high, a.UpperBoundInclusive() && b.LowerBoundInclusive()})
 }
 
 type valueWithInclusion struct {
@@ -78,35 +138,111 @@ func numericMulOrPanic(a, b types.Numeric) types.Numeric {
 	return result.(types.Numeric)
 }
 
-func numericDivOrPanic(a, b types.Numeric) types.Numeric {
-	result, err := BinaryDivision(a, b)
+// corner is the product of a bound of each of two ranges, rounded down and
+// up; or an infinity, with the sign inf.
+type corner struct {
+	low, high types.Numeric
+	inf       int
+	inclusive bool
+}
+
+// mulBounds multiplies bounds, where an infinite bound has the sign given.
+// As the bounds of ranges, zero times infinity is zero.
+func mulBounds(x, y valueWithInclusion, xInf, yInf int) (corner, error) {
+	xZero := x.val != nil && compareZero(x.val) == 0
+	yZero := y.val != nil && compareZero(y.val) == 0
+	switch {
+	case xZero || yZero:
+		// The product is zero whenever a zero bound is reached.
+		return corner{
+			low:       zeroValueNumeric,
+			high:      zeroValueNumeric,
+			inclusive: (xZero && x.inclusive) || (yZero && y.inclusive),
+		}, nil
+	case x.val == nil || y.val == nil:
+		if x.val != nil {
+			xInf = compareZero(x.val)
+		}
+		if y.val != nil {
+			yInf = compareZero(y.val)
+		}
+		return corner{inf: xInf * yInf}, nil
+	}
+
+	low, err := boundOp(x.val, y.val, false, BinaryMultiply, (*big.Rat).Mul)
 	if err != nil {
-		panic(err)
+		return corner{}, err
 	}
-	return result.(types.Numeric)
+	high, err := boundOp(x.val, y.val, true, BinaryMultiply, (*big.Rat).Mul)
+	if err != nil {
+		return corner{}, err
+	}
+	return corner{low: low, high: high, inclusive: x.inclusive && y.inclusive}, nil
+}
+
+// compareCorners compares corners, rounded up or down.
+func compareCorners(a, b corner, upper bool) int {
+	if a.inf != 0 || b.inf != 0 {
+		switch {
+		case a.inf < b.inf:
+			return numcmp.Less
+		case a.inf > b.inf:
+			return numcmp.Greater
+		}
+		return numcmp.Equal
+	}
+	if upper {
+		return numcmp.CompareOrPanic(a.high, b.high)
+	}
+	return numcmp.CompareOrPanic(a.low, b.low)
 }
 
 func rangeMul(a, b *numrange.Range) (types.Value, error) {
-	vals := []valueWithInclusion{
-		{
-			numericMulOrPanic(a.LowerBound(), b.LowerBound()),
-			a.LowerBoundInclusive() && b.LowerBoundInclusive(),
-		},
-		{
-			numericMulOrPanic(a.LowerBound(), b.UpperBound()),
-			a.LowerBoundInclusive() && b.UpperBoundInclusive(),
-		},
-		{
-			numericMulOrPanic(a.UpperBound(), b.LowerBound()),
-			a.UpperBoundInclusive() && b.LowerBoundInclusive(),
-		},
-		{
-			numericMulOrPanic(a.UpperBound(), b.UpperBound()),
-			a.UpperBoundInclusive() && b.UpperBoundInclusive(),
-		},
+	aBounds := []valueWithInclusion{{a.LowerBound(), a.LowerBoundInclusive()}, {a.UpperBound(), a.UpperBoundInclusive()}}
+	bBounds := []valueWithInclusion{{b.LowerBound(), b.LowerBoundInclusive()}, {b.UpperBound(), b.UpperBoundInclusive()}}
+	infs := []int{-1, 1}
+
+	var corners []corner
+	for i, x := range aBounds {
+		for j, y := range bBounds {
+			c, err := mulBounds(x, y, infs[i], infs[j])
+			if err != nil {
+				return nil, err
+			}
+			corners = append(corners, c)
+		}
 	}
 
 	// find the highest and the lowest of these numbers
+	lowest, highest := corners[0], corners[0]
+	for _, c := range corners[1:] {
+		switch compareCorners(c, lowest, false) {
+		case numcmp.Less:
+			lowest = c
+		case numcmp.Equal:
+			lowest.inclusive = lowest.inclusive || c.inclusive
+		}
+		switch compareCorners(c, highest, true) {
+		case numcmp.Greater:
+			highest = c
+		case numcmp.Equal:
+			highest.inclusive = highest.inclusive || c.inclusive
+		}
+	}
+
+	var low, high valueWithInclusion
+	if lowest.inf == 0 {
+		low = valueWithInclusion{lowest.low, lowest.inclusive}
+	}
+	if highest.inf == 0 {
+		high = valueWithInclusion{highest.high, highest.inclusive}
+	}
+	return newRange(low, high)
+}
+
+// extremes returns the lowest and the highest of some numbers, inclusive
+// if any equal one is.
+func extremes(vals []valueWithInclusion) (valueWithInclusion, valueWithInclusion) {
 	championLow := vals[0]
 	championHigh := vals[0]
 
@@ -130,21 +266,147 @@ func rangeMul(a, b *numrange.Range) (types.Value, error) {
 		}
 	}
 
-	return numinrange.New(championLow.val, championHigh.val, championLow.inclusive, championHigh.inclusive, nil)
+	return championLow, championHigh
 }
 
 var zeroValueNumeric = integer.FromInt64(0).(types.Numeric)
 var oneValueNumeric = integer.FromInt64(1).(types.Numeric)
 
+// reciprocal returns the range of 1/x over a range on one side of zero
+// (which may be an exclusive bound): 1/x decreases towards zero as x tends
+// to either infinity, and to the infinity of its side as x tends to zero.
+func reciprocal(r *numrange.Range) (*numrange.Range, error) {
+	invert := func(x types.Numeric, inclusive, upper bool) (valueWithInclusion, error) {
+		switch {
+		case x == nil:
+			return valueWithInclusion{zeroValueNumeric, false}, nil
+		case compareZero(x) == 0:
+			return valueWithInclusion{}, nil
+		}
+		rv, err := boundOp(oneValueNumeric, x, upper, BinaryDivision, (*big.Rat).Quo)
+		return valueWithInclusion{rv, inclusive}, err
+	}
+
+	low, err := invert(r.UpperBound(), r.UpperBoundInclusive(), false)
+	if err != nil {
+		return nil, err
+	}
+	high, err := invert(r.LowerBound(), r.LowerBoundInclusive(), true)
+	if err != nil {
+		return nil, err
+	}
+	return numrange.New(low.val, high.val, low.inclusive, high.inclusive), nil
+}
+
+// nonZeroParts returns the parts of a range below and above zero.
+func nonZeroParts(r *numrange.Range) []*numrange.Range {
+	var rv []*numrange.Range
+
+	if low := r.LowerBound(); low == nil || compareZero(low) < 0 {
+		high := valueWithInclusion{zeroValueNumeric, false}
+		if r.UpperBound() != nil && compareZero(r.UpperBound()) < 0 {
+			high = valueWithInclusion{r.UpperBound(), r.UpperBoundInclusive()}
+		}
+		rv = append(rv, numrange.New(low, high.val, r.LowerBoundInclusive(), high.inclusive))
+	}
+
+	if high := r.UpperBound(); high == nil || compareZero(high) > 0 {
+		low := valueWithInclusion{zeroValueNumeric, false}
+		if r.LowerBound() != nil && compareZero(r.LowerBound()) > 0 {
+			low = valueWithInclusion{r.LowerBound(), r.LowerBoundInclusive()}
+		}
+		rv = append(rv, numrange.New(low.val, high, low.inclusive, r.UpperBoundInclusive()))
+	}
+
+	return rv
+}
+
+// sameRange checks whether ranges have the same bounds.
+func sameRange(a, b *numrange.Range) bool {
+	sameBound := func(x, y types.Numeric) bool {
+		if x == nil || y == nil {
+			return x == nil && y == nil
+		}
+		return numcmp.CompareOrPanic(x, y) == numcmp.Equal
+	}
+	return sameBound(a.LowerBound(), b.LowerBound()) && sameBound(a.UpperBound(), b.UpperBound()) &&
+		a.LowerBoundInclusive() == b.LowerBoundInclusive() && a.UpperBoundInclusive() == b.UpperBoundInclusive()
+}
+
+// rangeDiv divides by each part of b on either side of zero, so that
+// dividing by a range with zero in it gives two half-infinite ranges (or
+// may throw, if zero itself is in it).
 func rangeDiv(a, b *numrange.Range) (types.Value, error) {
+	var quotients []*numrange.Range
+	for _, part := range nonZeroParts(b) {
+		inverse, err := reciprocal(part)
+		if err != nil {
+			return nil, err
+		}
+		rv, err := rangeMul(a, inverse)
+		if err != nil {
+			return nil, err
+		}
+		q, _ := numinrange.ToRange(rv)
+		if len(quotients) == 0 || !sameRange(quotients[0], q) {
+			quotients = append(quotients, q)
+		}
+	}
+
+	if len(quotients) == 0 {
+		return nil, lisperr.DivisionByZero
+	}
+
+	var results []types.Value
+	for _, q := range quotients {
+		rv, err := fromRange(q, nil)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, rv)
+	}
+
+	rv, err := anyof.New(results)
+	if err != nil {
+		return nil, err
+	}
+
+	if b.Contains(zeroValueNumeric) {
+		return maythrow.New(rv, []error{lisperr.DivisionByZero}), nil
+	}
+	return rv, nil
+}
+
+// rangeRemainder bounds the remainder of dividing by a range: it has the
+// sign of the dividend, an absolute value less than the divisor's, and is
+// the dividend itself if that is smaller than any divisor.
+func rangeRemainder(a, b *numrange.Range) (types.Value, error) {
 	if b.Contains(zeroValueNumeric) {
 		return nil, lisperr.DivisionByZero
 	}
 
-	lowerBoundInverted := numericDivOrPanic(oneValueNumeric, b.LowerBound())
-	upperBoundInverted := numericDivOrPanic(oneValueNumeric, b.UpperBound())
+	absB := absRange(b)
+	smallest := absB.LowerBound()
+	if a.LowerBound() != nil && a.UpperBound() != nil {
+		below := numrange.New(negate(smallest), smallest, false, false)
+		if below.Contains(a.LowerBound()) && below.Contains(a.UpperBound()) {
+			return fromRange(a, nil)
+		}
+	}
 
-	bInv := numrange.New(upperBoundInverted, lowerBoundInverted, b.UpperBoundInclusive(), b.LowerBoundInclusive())
+	largest := valueWithInclusion{absB.UpperBound(), false}
+	var negLargest valueWithInclusion
+	if largest.val != nil {
+		negLargest = valueWithInclusion{negate(largest.val), false}
+	}
 
-	return rangeMul(a, bInv)
+	low := valueWithInclusion{zeroValueNumeric, true}
+	high := valueWithInclusion{zeroValueNumeric, true}
+	if a.UpperBound() == nil || compareZero(a.UpperBound()) > 0 {
+		high = chooseBound(valueWithInclusion{a.UpperBound(), a.UpperBoundInclusive()}, largest, false, true)
+	}
+	if a.LowerBound() == nil || compareZero(a.LowerBound()) < 0 {
+		low = chooseBound(valueWithInclusion{a.LowerBound(), a.LowerBoundInclusive()}, negLargest, true, false)
+	}
+	return fromRange(numrange.New(low.val, high.val, low.inclusive, high.inclusive), nil)
 }
@@ -0,0 +1,235 @@
+package numerics
+
+import (
+	"math"
+	"math/big"
+	"math/rand"
+	"testing"
+
+	"github.com/steinarvk/heisenlisp/number"
+	"github.com/steinarvk/heisenlisp/numcmp"
+	"github.com/steinarvk/heisenlisp/numrange"
+	"github.com/steinarvk/heisenlisp/types"
+	"github.com/steinarvk/heisenlisp/value/unknowns/anyof"
+	"github.com/steinarvk/heisenlisp/value/unknowns/maythrow"
+	"github.com/steinarvk/heisenlisp/value/unknowns/numinrange"
+)
+
+func randomBound(rng *rand.Rand) types.Numeric {
+	switch rng.Intn(8) {
+	case 0:
+		return nil
+	case 1:
+		return number.FromInt64(0)
+	case 2:
+		return number.FromInt64(rng.Int63n(21) - 10)
+	case 3:
+		return number.FromBigRat(big.NewRat(rng.Int63n(201)-100, rng.Int63n(30)+1))
+	case 4:
+		return number.FromFloat64(math.Ldexp(rng.Float64()-0.5, rng.Intn(2000)-1000))
+	default:
+		return number.FromFloat64((rng.Float64() - 0.5) * 20)
+	}
+}
+
+func randomRange(rng *rand.Rand) *numrange.Range {
+	a, b := randomBound(rng), randomBound(rng)
+	if a != nil && b != nil && numcmp.CompareOrPanic(a, b) == numcmp.Greater {
+		a, b = b, a
+	}
+	if a != nil && b != nil && numcmp.CompareOrPanic(a, b) == numcmp.Equal {
+		return numrange.New(a, b, true, true)
+	}
+	return numrange.New(a, b, rng.Intn(2) == 0, rng.Intn(2) == 0)
+}
+
+// samples returns some of the numbers in a range, exactly.
+func samples(rng *rand.Rand, r *numrange.Range) []*big.Rat {
+	var rv []*big.Rat
+	low, lowOK := r.LowerBound(), r.LowerBound() != nil
+	high, highOK := r.UpperBound(), r.UpperBound() != nil
+	if lowOK && r.LowerBoundInclusive() {
+		x, _ := ratOf(low)
+		rv = append(rv, x)
+	}
+	if highOK && r.UpperBoundInclusive() {
+		x, _ := ratOf(high)
+		rv = append(rv, x)
+	}
+	if r.IsSingleton() {
+		return rv
+	}
+
+	for i := 0; i < 10; i++ {
+		// A fraction strictly between 0 and 1, often close to either.
+		t := big.NewRat(rng.Int63n(999)+1, 1000)
+		if rng.Intn(3) == 0 {
+			t.Mul(t, big.NewRat(1, 1<<40))
+		}
+		if rng.Intn(2) == 0 {
+			t.Sub(big.NewRat(1, 1), t)
+		}
+		distance := new(big.Rat).SetFloat64(math.Ldexp(rng.Float64()+0.01, rng.Intn(100)-20))
+
+		var x *big.Rat
+		switch {
+		case lowOK && highOK:
+			lo, _ := ratOf(low)
+			hi, _ := ratOf(high)
+			x = new(big.Rat).Add(lo, new(big.Rat).Mul(t, new(big.Rat).Sub(hi, lo)))
+		case lowOK:
+			lo, _ := ratOf(low)
+			x = new(big.Rat).Add(lo, distance)
+		case highOK:
+			hi, _ := ratOf(high)
+			x = new(big.Rat).Sub(hi, distance)
+		default:
+			x = distance
+			if rng.Intn(2) == 0 {
+				x.Neg(x)
+			}
+		}
+		rv = append(rv, x)
+	}
+	return rv
+}
+
+// contains checks whether a number is one of the possible results.
+func contains(v types.Value, x *big.Rat) bool {
+	v, _ = maythrow.Split(v)
+	values := []types.Value{v}
+	if anyof.Is(v) {
+		values, _ = anyof.PossibleValues(v)
+	}
+	n := number.FromBigRat(x)
+	for _, value := range values {
+		if r, ok := numinrange.ToRange(value); ok {
+			if r.Contains(n) {
+				return true
+			}
+			continue
+		}
+		if m, ok := value.(types.Numeric); ok && numcmp.CompareOrPanic(m, n) == numcmp.Equal {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRangeArithmeticIsSound(t *testing.T) {
+	testcases := []struct {
+		name  string
+		f     func(a, b *numrange.Range) (types.Value, error)
+		exact func(z, x, y *big.Rat) *big.Rat
+	}{
+		{"+", rangeAdd, (*big.Rat).Add},
+		{"-", rangeSub, (*big.Rat).Sub},
+		{"*", rangeMul, (*big.Rat).Mul},
+		{"/", rangeDiv, (*big.Rat).Quo},
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	for _, testcase := range testcases {
+		for i := 0; i < 500; i++ {
+			a, b := randomRange(rng), randomRange(rng)
+			rv, err := testcase.f(a, b)
+			if err != nil {
+				if testcase.name == "/" && b.IsSingleton() && b.Contains(zeroValueNumeric) {
+					continue
+				}
+				t.Fatalf("%v %s %v: %v", rangeValue(a), testcase.name, rangeValue(b), err)
+			}
+
+			if testcase.name == "/" && b.Contains(zeroValueNumeric) && !maythrow.Is(rv) {
+				t.Errorf("%v / %v = %v: want division by zero to be possible", rangeValue(a), rangeValue(b), rv)
+			}
+
+			for _, x := range samples(rng, a) {
+				for _, y := range samples(rng, b) {
+					if testcase.name == "/" && y.Sign() == 0 {
+						continue
+					}
+					exact := testcase.exact(new(big.Rat), x, y)
+					if !contains(rv, exact) {
+						t.Fatalf("%v %s %v = %v: missing %v %s %v = %v", rangeValue(a), testcase.name, rangeValue(b), rv, x.FloatString(20), testcase.name, y.FloatString(20), exact.FloatString(20))
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestRangeComparisonIsSound(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		a, b := randomRange(rng), randomRange(rng)
+		rv := numrange.Compare(a, b)
+		for _, x := range samples(rng, a) {
+			for _, y := range samples(rng, b) {
+				c := x.Cmp(y)
+				possible := map[int]bool{1: rv.MayBeLeftLarger, 0: rv.MayBeEqual, -1: rv.MayBeRightLarger}
+				certain := (rv.MustBeLeftLarger && c != 1) || (rv.MustBeEqual && c != 0) || (rv.MustBeRightLarger && c != -1)
+				if !possible[c] || certain {
+					t.Fatalf("Compare(%v, %v) = %+v: wrong for %v and %v", a, b, *rv, x.FloatString(20), y.FloatString(20))
+				}
+			}
+		}
+	}
+}
+
+func TestRoundOutward(t *testing.T) {
+	third := big.NewRat(1, 3)
+	x := number.FromFloat64(1.0 / 3)
+
+	low, _ := roundOutward(x, third, false).AsDouble()
+	high, _ := roundOutward(x, third, true).AsDouble()
+	if new(big.Rat).SetFloat64(low).Cmp(third) >= 0 {
+		t.Errorf("roundOutward(1/3, lower) = %v, not below 1/3", low)
+	}
+	if new(big.Rat).SetFloat64(high).Cmp(third) <= 0 {
+		t.Errorf("roundOutward(1/3, upper) = %v, not above 1/3", high)
+	}
+
+	exact, _ := roundOutward(number.FromFloat64(0.5), big.NewRat(1, 2), true).AsDouble()
+	if exact != 0.5 {
+		t.Errorf("roundOutward(0.5, upper) = %v want 0.5", exact)
+	}
+}
+
+func TestRangeDivisionByRangeWithZero(t *testing.T) {
+	unitRange := numrange.New(number.FromInt64(-1), number.FromInt64(1), true, true)
+	testcases := []struct {
+		a    *numrange.Range
+		want string
+	}{
+		{unitRange, `#may-throw(#number-in-range((-inf,inf)) :or "division by zero")`},
+		{numrange.NewSingleton(zeroValueNumeric), `#may-throw(0 :or "division by zero")`},
+		{numrange.NewSingleton(oneValueNumeric), `#may-throw(#any-of(#number-in-range((-inf,-1]) #number-in-range([1,inf))) :or "division by zero")`},
+	}
+
+	for _, testcase := range testcases {
+		rv, err := rangeDiv(testcase.a, unitRange)
+		if err != nil {
+			t.Fatalf("%v / %v: %v", rangeValue(testcase.a), rangeValue(unitRange), err)
+		}
+		if got := rv.String(); got != testcase.want {
+			t.Errorf("%v / %v = %v want %v", rangeValue(testcase.a), rangeValue(unitRange), got, testcase.want)
+		}
+	}
+}
+
+func TestIntersectionOfUnboundedRanges(t *testing.T) {
+	all := numrange.New(nil, nil, false, false)
+	above := numrange.NewAbove(oneValueNumeric, true)
+	below := numrange.NewBelow(oneValueNumeric, true)
+
+	if got := all.Intersection(all).String(); got != "(-inf,inf)" {
+		t.Errorf("%v.Intersection(%v) = %v want (-inf,inf)", all, all, got)
+	}
+	if got := above.Intersection(below).String(); got != "[1,1]" {
+		t.Errorf("%v.Intersection(%v) = %v want [1,1]", above, below, got)
+	}
+	if got := below.Intersection(below).String(); got != "(-inf,1]" {
+		t.Errorf("%v.Intersection(%v) = %v want (-inf,1]", below, below, got)
+	}
+}
@@ -27,6 +27,10 @@ func NewSingleton(x types.Numeric) *Range {
 }
 
 func (r *Range) otherRangeMayBeGreater(other *Range) bool {
+	if r.LowerBound() == nil || other.UpperBound() == nil {
+		// an infinite bound leaves room for other to be greater.
+		return true
+	}
 	whatIsMyLowerBound := numcmp.CompareOrPanic(r.LowerBound(), other.UpperBound())
 	switch whatIsMyLowerBound {
 	case numcmp.Less:
@@ -178,7 +182,7 @@ func (r *Range) strictestLowerBound(o *Range) (types.Numeric, bool) {
 }
 
 // strictestUpperBound returns the strictest upper bound; which is
-// a combination of a Numeric (nil means -inf) and a boolean
+// a combination of a Numeric (nil means +inf) and a boolean
 // meaning inclusive/exclusive.
 func (r *Range) strictestUpperBound(o *Range) (types.Numeric, bool) {
 	if r.upperBound == nil {
@@ -210,6 +214,16 @@ func (r *Range) Intersection(o *Range) *Range {
 	low, lowIncl := r.strictestLowerBound(o)
 	high, highIncl := r.strictestUpperBound(o)
 
+	if low == nil || high == nil {
+		// An infinite bound is exclusive, and there is no bound to cross.
+		return &Range{
+			lowerBound:          low,
+			upperBound:          high,
+			lowerBoundInclusive: low != nil && lowIncl,
+			upperBoundInclusive: high != nil && highIncl,
+		}
+	}
+
 	switch numcmp.CompareOrPanic(low, high) {
 	case numcmp.Equal:
 		if !lowIncl || !highIncl {
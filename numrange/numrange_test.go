@@ -105,6 +105,9 @@ func TestRangeIntersection(t *testing.T) {
 		{"[1, 5]", "[6,10]", "empty"},
 		{"[1, 5]", "(5,10]", "empty"},
 		{"[1, 5)", "[5,10]", "empty"},
+		{"(-inf, 5]", "[0, inf)", "[0,5]"},
+		{"(-inf, 1]", "(-inf, 3)", "(-inf,1]"},
+		{"(-inf, inf)", "(-inf, inf)", "(-inf,inf)"},
 	}
 
 	for _, testcase := range testcases {
@@ -0,0 +1,73 @@
+// Package outcome combines the outcomes of evaluations in several possible
+// worlds, in each of which either a value is returned or an exception
+// thrown.
+package outcome
+
+import (
+	"errors"
+
+	"github.com/steinarvk/heisenlisp/types"
+	"github.com/steinarvk/heisenlisp/value/condition"
+	"github.com/steinarvk/heisenlisp/value/unknowns/anyof"
+	"github.com/steinarvk/heisenlisp/value/unknowns/maythrow"
+)
+
+// Combine combines the outcomes of several possible worlds. If all of them
+// threw, so does the whole.
+func Combine(values []types.Value, exceptions []error) (types.Value, error) {
+	if len(values) == 0 {
+		if len(exceptions) == 0 {
+			return nil, errors.New("no possible outcomes")
+		}
+		return nil, maythrow.Fail(exceptions)
+	}
+
+	var normalValues []types.Value
+	for _, v := range values {
+		normal, valueExceptions := maythrow.Split(v)
+		normalValues = append(normalValues, normal)
+		exceptions = append(exceptions, valueExceptions...)
+	}
+
+	rv, err := anyof.New(normalValues)
+	if err != nil {
+		return nil, err
+	}
+
+	return maythrow.New(rv, exceptions), nil
+}
+
+// InWorlds evaluates something in each of n possible worlds, and combines
+// the outcomes with those of worlds that already threw. An exception in one
+// world is only one possible outcome.
+func InWorlds(n int, exceptions []error, eval func(i int) (types.Value, error)) (types.Value, error) {
+	if n == 1 && len(exceptions) == 0 {
+		return eval(0)
+	}
+
+	var values []types.Value
+	for i := 0; i < n; i++ {
+		val, err := eval(i)
+		if err != nil {
+			if !IsCatchable(err) {
+				return nil, err
+			}
+			exceptions = append(exceptions, maythrow.Exceptions(err)...)
+			continue
+		}
+		values = append(values, val)
+	}
+
+	return Combine(values, exceptions)
+}
+
+// IsCatchable checks whether an error is made up of exceptions that can be
+// caught (see condition.FromError), and so can be a possible outcome.
+func IsCatchable(err error) bool {
+	for _, exc := range maythrow.Exceptions(err) {
+		if _, _, ok := condition.FromError(exc); !ok {
+			return false
+		}
+	}
+	return true
+}
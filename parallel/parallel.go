@@ -0,0 +1,132 @@
+// Package parallel evaluates independent possible worlds concurrently, e.g.
+// the possible values of an #any-of passed to a pure function, or both
+// branches of an if whose condition is maybe.
+//
+// Work is shared by a pool of at most Parallelism goroutines (including the
+// ones asking for work to be done). A goroutine that finds no idle worker
+// does the work itself, so nested uses never wait for each other. Results
+// are always returned in order, so that the outcome does not depend on the
+// parallelism.
+package parallel
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/steinarvk/heisenlisp/tracing"
+	"github.com/steinarvk/heisenlisp/types"
+)
+
+var (
+	metricParallelWorkers = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "hlisp",
+			Name:      "parallel_workers",
+			Help:      "Goroutines started to evaluate possible worlds in parallel",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(metricParallelWorkers)
+}
+
+// workers holds a token for each busy worker goroutine; it is nil if
+// parallelism is disabled.
+var workers chan struct{}
+
+// SetParallelism sets the number of goroutines that may evaluate possible
+// worlds at the same time. With 1 (the default), everything is evaluated
+// sequentially. It must not be called while anything is being evaluated.
+func SetParallelism(n int) {
+	if n <= 1 {
+		workers = nil
+		return
+	}
+	workers = make(chan struct{}, n-1)
+}
+
+// Parallelism returns the number of goroutines that may evaluate possible
+// worlds at the same time.
+func Parallelism() int {
+	return cap(workers) + 1
+}
+
+func enabled() bool {
+	// Call tracing is not goroutine-safe.
+	return workers != nil && !tracing.Enabled
+}
+
+// Run calls f(0), ..., f(n-1), concurrently if parallelism is enabled, and
+// returns when all have returned.
+func Run(n int, f func(i int)) {
+	if n < 2 || !enabled() {
+		for i := 0; i < n; i++ {
+			f(i)
+		}
+		return
+	}
+
+	var next int64 = -1
+	work := func() {
+		for {
+			i := int(atomic.AddInt64(&next, 1))
+			if i >= n {
+				return
+			}
+			f(i)
+		}
+	}
+
+	tokens := workers
+	var wg sync.WaitGroup
+recruiting:
+	for helpers := 0; helpers < n-1; helpers++ {
+		select {
+		case tokens <- struct{}{}:
+			metricParallelWorkers.Inc()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-tokens }()
+				work()
+			}()
+		default:
+			break recruiting
+		}
+	}
+
+	work()
+	wg.Wait()
+}
+
+// Map returns f(0), ..., f(n-1), evaluated as by Run. If any of them fail,
+// the error is that of the first in order (and evaluated sequentially, the
+// later ones are not evaluated).
+func Map(n int, f func(i int) (types.Value, error)) ([]types.Value, error) {
+	rv := make([]types.Value, n)
+
+	if n < 2 || !enabled() {
+		for i := 0; i < n; i++ {
+			v, err := f(i)
+			if err != nil {
+				return nil, err
+			}
+			rv[i] = v
+		}
+		return rv, nil
+	}
+
+	errs := make([]error, n)
+	Run(n, func(i int) {
+		rv[i], errs[i] = f(i)
+	})
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return rv, nil
+}
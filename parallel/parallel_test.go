@@ -0,0 +1,53 @@
+package parallel
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/steinarvk/heisenlisp/types"
+	"github.com/steinarvk/heisenlisp/value/integer"
+)
+
+func TestMapOrder(t *testing.T) {
+	for _, parallelism := range []int{1, 4} {
+		SetParallelism(parallelism)
+
+		rv, err := Map(100, func(i int) (types.Value, error) {
+			// Nested uses must not wait for each other.
+			inner, err := Map(10, func(j int) (types.Value, error) {
+				return integer.FromInt64(int64(j)), nil
+			})
+			if err != nil {
+				return nil, err
+			}
+			return integer.FromInt64(int64(i + len(inner))), nil
+		})
+		if err != nil {
+			t.Fatalf("parallelism %d: Map() = err: %v", parallelism, err)
+		}
+		for i, v := range rv {
+			if want := fmt.Sprint(i + 10); v.String() != want {
+				t.Errorf("parallelism %d: result %d = %v want %s", parallelism, i, v, want)
+			}
+		}
+	}
+	SetParallelism(1)
+}
+
+func TestMapFirstError(t *testing.T) {
+	for _, parallelism := range []int{1, 4} {
+		SetParallelism(parallelism)
+
+		_, err := Map(50, func(i int) (types.Value, error) {
+			if i%10 == 7 {
+				return nil, fmt.Errorf("error %d", i)
+			}
+			return integer.FromInt64(int64(i)), nil
+		})
+		if want := errors.New("error 7"); err == nil || err.Error() != want.Error() {
+			t.Errorf("parallelism %d: Map() = err: %v want %v", parallelism, err, want)
+		}
+	}
+	SetParallelism(1)
+}
@@ -0,0 +1,438 @@
+// Package pattern implements the patterns used by the match special form and
+// by destructuring in let and lambda lists.
+//
+// A pattern is one of:
+//
+//	_                 matches anything
+//	x                 matches anything, binding it to x
+//	42, "s", :k, nil  (also true and false) matches an equal value
+//	'datum            matches a value equal to datum
+//	(p1 p2 ...)       matches a list of exactly that length
+//	(p1 p2 &rest ps)  matches a list of at least that length
+//	(p1 . ps)         matches a cons
+//	(? pred p)        matches a value for which pred is true and that
+//	                  matches p (which may be left out)
+//
+// Matching an uncertain value can produce several possible matches, each
+// with its own bindings: an #any-of (or optional cons) where a list or
+// predicate pattern is expected is matched against each of its possible values.
+package pattern
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/steinarvk/heisenlisp/equality"
+	"github.com/steinarvk/heisenlisp/expr"
+	"github.com/steinarvk/heisenlisp/lisperr"
+	"github.com/steinarvk/heisenlisp/types"
+	"github.com/steinarvk/heisenlisp/unknown"
+	"github.com/steinarvk/heisenlisp/value/boolean"
+	"github.com/steinarvk/heisenlisp/value/cons"
+	"github.com/steinarvk/heisenlisp/value/null"
+	"github.com/steinarvk/heisenlisp/value/symbol"
+	"github.com/steinarvk/heisenlisp/value/unknowns/anyof"
+	"github.com/steinarvk/heisenlisp/value/unknowns/fullyunknown"
+)
+
+type Binding struct {
+	Name  uint32
+	Value types.Value
+}
+
+// Match is one way in which a value may match a pattern.
+type Match struct {
+	// Certain is false if the value only maybe matches, e.g. because it
+	// was compared to a literal with a maybe result.
+	Certain  bool
+	Bindings []Binding
+}
+
+type Pattern interface {
+	String() string
+
+	// Match returns the possible matches of v (none if it cannot match),
+	// and whether v is certain to match in one of them. Expressions in the
+	// pattern (predicates) are evaluated in e.
+	Match(e types.Env, v types.Value) ([]Match, bool, error)
+
+	// Variables returns the ids of the variables bound by the pattern.
+	Variables() []uint32
+}
+
+type wildcardPattern struct{}
+
+func (_ wildcardPattern) String() string      { return "_" }
+func (_ wildcardPattern) Variables() []uint32 { return nil }
+func (_ wildcardPattern) Match(_ types.Env, _ types.Value) ([]Match, bool, error) {
+	return []Match{{Certain: true}}, true, nil
+}
+
+type variablePattern struct {
+	name string
+	id   uint32
+}
+
+func (p variablePattern) String() string      { return p.name }
+func (p variablePattern) Variables() []uint32 { return []uint32{p.id} }
+func (p variablePattern) Match(_ types.Env, v types.Value) ([]Match, bool, error) {
+	return []Match{{Certain: true, Bindings: []Binding{{p.id, v}}}}, true, nil
+}
+
+type literalPattern struct {
+	value types.Value
+}
+
+func (p literalPattern) String() string      { return p.value.String() }
+func (p literalPattern) Variables() []uint32 { return nil }
+func (p literalPattern) Match(_ types.Env, v types.Value) ([]Match, bool, error) {
+	tv, err := equality.Equals(p.value, v)
+	if err != nil {
+		return nil, false, err
+	}
+	switch tv {
+	case types.True:
+		return []Match{{Certain: true}}, true, nil
+	case types.Maybe:
+		return []Match{{Certain: false}}, false, nil
+	}
+	return nil, false, nil
+}
+
+type consPattern struct {
+	car, cdr Pattern
+}
+
+func (p consPattern) String() string {
+	var parts []string
+	var rest Pattern = p
+	for {
+		c, ok := rest.(consPattern)
+		if !ok {
+			break
+		}
+		parts = append(parts, c.car.String())
+		rest = c.cdr
+	}
+	if lit, ok := rest.(literalPattern); !ok || !null.IsNil(lit.value) {
+		parts = append(parts, ".", rest.String())
+	}
+	return fmt.Sprintf("(%s)", strings.Join(parts, " "))
+}
+
+func (p consPattern) Variables() []uint32 {
+	return append(p.car.Variables(), p.cdr.Variables()...)
+}
+
+func (p consPattern) Match(e types.Env, v types.Value) ([]Match, bool, error) {
+	if alternatives, ok := splittable(v); ok {
+		return matchEach(p, e, alternatives)
+	}
+
+	if unk, ok := v.(types.Unknown); ok {
+		// Cannot see inside the value; it may match (if it may be a cons),
+		// and tells us nothing about the variables.
+		if typenames, ok := unk.ActualTypeName(); ok && !contains(typenames, cons.TypeName) {
+			return nil, false, nil
+		}
+		m := Match{Certain: false}
+		for _, id := range p.Variables() {
+			m.Bindings = append(m.Bindings, Binding{id, fullyunknown.Value})
+		}
+		return []Match{m}, false, nil
+	}
+
+	car, cdr, ok := cons.Decompose(v)
+	if !ok {
+		return nil, false, nil
+	}
+
+	carMatches, carExhaustive, err := p.car.Match(e, car)
+	if err != nil || len(carMatches) == 0 {
+		return nil, false, err
+	}
+
+	cdrMatches, cdrExhaustive, err := p.cdr.Match(e, cdr)
+	if err != nil || len(cdrMatches) == 0 {
+		return nil, false, err
+	}
+
+	var rv []Match
+	for _, a := range carMatches {
+		for _, b := range cdrMatches {
+			var bindings []Binding
+			bindings = append(bindings, a.Bindings...)
+			bindings = append(bindings, b.Bindings...)
+			rv = append(rv, Match{
+				Certain:  a.Certain && b.Certain,
+				Bindings: bindings,
+			})
+		}
+	}
+
+	return rv, carExhaustive && cdrExhaustive, nil
+}
+
+type predicatePattern struct {
+	predicate types.Value
+	pattern   Pattern
+}
+
+func (p predicatePattern) String() string {
+	if _, ok := p.pattern.(wildcardPattern); ok {
+		return fmt.Sprintf("(? %v)", p.predicate)
+	}
+	return fmt.Sprintf("(? %v %v)", p.predicate, p.pattern)
+}
+
+func (p predicatePattern) Variables() []uint32 { return p.pattern.Variables() }
+
+func (p predicatePattern) Match(e types.Env, v types.Value) ([]Match, bool, error) {
+	if alternatives, ok := splittable(v); ok {
+		return matchEach(p, e, alternatives)
+	}
+
+	predVal, err := p.predicate.Eval(e)
+	if err != nil {
+		return nil, false, err
+	}
+
+	callable, ok := predVal.(types.Callable)
+	if !ok {
+		return nil, false, fmt.Errorf("pattern predicate is not callable: %v", predVal)
+	}
+
+	if !callable.IsPure() && e.IsInPureContext() {
+		return nil, false, errors.New("impure call in pure context")
+	}
+
+	result, err := callable.Call([]types.Value{v})
+	if err != nil {
+		return nil, false, err
+	}
+
+	tv, err := unknown.TruthValue(result)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if tv == types.False {
+		return nil, false, nil
+	}
+
+	ms, exhaustive, err := p.pattern.Match(e, v)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if tv == types.Maybe {
+		for i := range ms {
+			ms[i].Certain = false
+		}
+		exhaustive = false
+	}
+
+	return ms, exhaustive, nil
+}
+
+func contains(xs []string, s string) bool {
+	for _, x := range xs {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// splittable returns the possible values of an uncertain value that can
+// be enumerated, such as an #any-of or an optional cons.
+func splittable(v types.Value) ([]types.Value, bool) {
+	if _, ok := v.(types.Unknown); !ok || anyof.IsMaybe(v) {
+		return nil, false
+	}
+	xs, ok := anyof.PossibleValues(v)
+	if !ok || len(xs) < 2 {
+		return nil, false
+	}
+	return xs, true
+}
+
+func matchEach(p Pattern, e types.Env, xs []types.Value) ([]Match, bool, error) {
+	var rv []Match
+	exhaustive := true
+	for _, x := range xs {
+		ms, ex, err := p.Match(e, x)
+		if err != nil {
+			return nil, false, err
+		}
+		rv = append(rv, ms...)
+		exhaustive = exhaustive && ex
+	}
+	return rv, exhaustive, nil
+}
+
+// Parse parses a pattern. A variable may only occur once in a pattern.
+func Parse(v types.Value) (Pattern, error) {
+	p, err := parse(v)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[uint32]bool{}
+	for _, id := range p.Variables() {
+		if seen[id] {
+			return nil, fmt.Errorf("variable occurs more than once in pattern %v", v)
+		}
+		seen[id] = true
+	}
+
+	return p, nil
+}
+
+func parse(v types.Value) (Pattern, error) {
+	if name, err := symbol.Name(v); err == nil {
+		switch {
+		case name == "_":
+			return wildcardPattern{}, nil
+		case name == "nil":
+			return literalPattern{null.Nil}, nil
+		case name == "true":
+			return literalPattern{boolean.True}, nil
+		case name == "false":
+			return literalPattern{boolean.False}, nil
+		case symbol.IsKeyword(v):
+			return literalPattern{v}, nil
+		case strings.HasPrefix(name, "&"):
+			return nil, fmt.Errorf("misplaced %s in pattern", name)
+		}
+		return variablePattern{name, symbol.IdOrPanic(v)}, nil
+	}
+
+	if !cons.IsCons(v) {
+		if _, ok := v.(types.Unknown); ok {
+			return nil, fmt.Errorf("uncertain value is not a valid pattern: %v", v)
+		}
+		return literalPattern{v}, nil
+	}
+
+	if quoted, ok := expr.IsWrappedInUnary("quote", v); ok {
+		return literalPattern{quoted}, nil
+	}
+
+	head, tail, _ := cons.Decompose(v)
+	if name, err := symbol.Name(head); err == nil && name == "?" {
+		xs, err := expr.UnwrapList(tail)
+		if err != nil || len(xs) < 1 || len(xs) > 2 {
+			return nil, fmt.Errorf("predicate pattern must be (? predicate [pattern]): %v", v)
+		}
+		var sub Pattern = wildcardPattern{}
+		if len(xs) == 2 {
+			sub, err = parse(xs[1])
+			if err != nil {
+				return nil, err
+			}
+		}
+		return predicatePattern{xs[0], sub}, nil
+	}
+
+	return parseList(v)
+}
+
+func parseList(v types.Value) (Pattern, error) {
+	car, cdr, ok := cons.Decompose(v)
+	if !ok {
+		return parse(v)
+	}
+
+	// The reader has no dotted pairs, so (p1 . ps) is read as a list with
+	// the symbol "." in it.
+	if name, err := symbol.Name(car); err == nil && (name == "&rest" || name == ".") {
+		rest, err := expr.UnwrapList(cdr)
+		if err != nil || len(rest) != 1 {
+			return nil, fmt.Errorf("%s must be followed by exactly one pattern", name)
+		}
+		return parse(rest[0])
+	}
+
+	carPattern, err := parse(car)
+	if err != nil {
+		return nil, err
+	}
+
+	cdrPattern, err := parseList(cdr)
+	if err != nil {
+		return nil, err
+	}
+
+	return consPattern{carPattern, cdrPattern}, nil
+}
+
+// Destructure matches v against p, for binding variables in let or lambda
+// lists. Each way in which v may match is a possible world with its own
+// bindings. If v may not match, the exceptions are those of the worlds in
+// which it does not; if it cannot match at all, that is the error.
+func Destructure(e types.Env, p Pattern, v types.Value) ([][]Binding, []error, error) {
+	ms, exhaustive, err := p.Match(e, v)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	noMatch := lisperr.NoMatchingClause{v}
+	if len(ms) == 0 {
+		return nil, nil, noMatch
+	}
+
+	var worlds [][]Binding
+	for _, m := range ms {
+		worlds = append(worlds, m.Bindings)
+	}
+
+	var exceptions []error
+	if !exhaustive {
+		exceptions = append(exceptions, noMatch)
+	}
+	return worlds, exceptions, nil
+}
+
+// Worlds are the possible worlds of binding several variables, some of them
+// by destructuring: one for each combination of the ways in which their
+// values may match.
+type Worlds struct {
+	Bindings   [][]Binding
+	Exceptions []error
+}
+
+// NewWorlds returns a single world without bindings.
+func NewWorlds() *Worlds {
+	return &Worlds{Bindings: [][]Binding{nil}}
+}
+
+// Bind binds a variable to the same value in every world.
+func (w *Worlds) Bind(id uint32, v types.Value) {
+	for i, bindings := range w.Bindings {
+		w.Bindings[i] = append(bindings[:len(bindings):len(bindings)], Binding{id, v})
+	}
+}
+
+// Destructure matches v against p in every world, splitting each into the
+// worlds of the ways in which v may match.
+func (w *Worlds) Destructure(e types.Env, p Pattern, v types.Value) error {
+	worlds, exceptions, err := Destructure(e, p, v)
+	if err != nil {
+		return err
+	}
+	if int64(len(w.Bindings)*len(worlds)) > anyof.MaxAnyOfElements {
+		return lisperr.NotImplemented("destructuring with too many possible matches")
+	}
+
+	var rv [][]Binding
+	for _, bindings := range w.Bindings {
+		for _, more := range worlds {
+			rv = append(rv, append(bindings[:len(bindings):len(bindings)], more...))
+		}
+	}
+	w.Bindings = rv
+	w.Exceptions = append(w.Exceptions, exceptions...)
+	return nil
+}
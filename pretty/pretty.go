@@ -0,0 +1,382 @@
+// Package pretty lays out values (code and data) to a target width, using
+// Lisp-style indentation for special forms.
+package pretty
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/steinarvk/heisenlisp/types"
+	"github.com/steinarvk/heisenlisp/value/cons"
+	"github.com/steinarvk/heisenlisp/value/null"
+	"github.com/steinarvk/heisenlisp/value/symbol"
+	"github.com/steinarvk/heisenlisp/value/unknowns/anyof"
+)
+
+type Options struct {
+	// Width is the target line width. Atoms wider than this are never broken.
+	Width int
+
+	// MaxAnyOfElements is the number of elements shown of an any-of before
+	// the rest are elided with a count. Zero means never elide.
+	MaxAnyOfElements int
+}
+
+var DefaultOptions = Options{
+	Width:            80,
+	MaxAnyOfElements: 20,
+}
+
+// bodyForms are forms printed with a number of distinguished arguments on the
+// first line, followed by a body indented by two spaces. Other forms, such as
+// if and cond, are printed like function calls, with arguments (or clauses)
+// aligned under the first one.
+var bodyForms = map[string]int{
+	"let":              1,
+	"let*":             1,
+	"letfunc":          1,
+	"letfunc*":         1,
+	"letrec":           1,
+	"labels":           1,
+	"do":               2,
+	"match":            1,
+	"lambda":           1,
+	"when":             1,
+	"unless":           1,
+	"defun!":           2,
+	"defmacro!":        2,
+	"handle-exception": 1,
+	"try":              0,
+	"catch":            1,
+	"finally":          0,
+	"defstruct!":       1,
+}
+
+// functionBindingForms are body forms whose bindings define functions, as
+// (name (params) body...); each is laid out like a defun!.
+var functionBindingForms = map[string]bool{
+	"letfunc":  true,
+	"letfunc*": true,
+	"labels":   true,
+}
+
+var quoteShorthands = map[string]string{
+	"quote":            "'",
+	"quasiquote":       "`",
+	"unquote":          ",",
+	"unquote-splicing": ",@",
+}
+
+// BodyFormArguments returns the number of distinguished arguments of a
+// form that is laid out with an indented body, such as let or defun!.
+func BodyFormArguments(name string) (int, bool) {
+	n, ok := bodyForms[name]
+	return n, ok
+}
+
+// BindsFunctions reports whether the bindings of a body form define
+// functions, as (name (params) body...).
+func BindsFunctions(name string) bool {
+	return functionBindingForms[name]
+}
+
+func Sprint(v types.Value) string {
+	return DefaultOptions.Sprint(v)
+}
+
+func (o Options) Sprint(v types.Value) string {
+	return o.SprintFromColumn(v, 0)
+}
+
+// SprintFromColumn lays out v as if it were printed starting at the given
+// column; continuation lines are indented accordingly.
+func (o Options) SprintFromColumn(v types.Value, column int) string {
+	p := &printer{opts: o}
+	p.layout(v, column)
+	return p.buf.String()
+}
+
+type printer struct {
+	opts Options
+	buf  strings.Builder
+}
+
+func (p *printer) newline(column int) {
+	p.buf.WriteString("\n")
+	p.buf.WriteString(strings.Repeat(" ", column))
+}
+
+// column returns the column at which output continues.
+func (p *printer) column() int {
+	s := p.buf.String()
+	return len(s) - 1 - strings.LastIndex(s, "\n")
+}
+
+func (p *printer) fits(s string, column int) bool {
+	return !strings.Contains(s, "\n") && column+len(s) <= p.opts.Width
+}
+
+func quoteShorthand(xs []types.Value) (string, bool) {
+	if len(xs) != 2 {
+		return "", false
+	}
+	name, err := symbol.Name(xs[0])
+	if err != nil {
+		return "", false
+	}
+	prefix, ok := quoteShorthands[name]
+	return prefix, ok
+}
+
+// unwrapList returns the elements of a (possibly improper) list, and the
+// tail (nil for a proper list).
+func unwrapList(v types.Value) ([]types.Value, types.Value) {
+	var xs []types.Value
+	for {
+		car, cdr, ok := cons.Decompose(v)
+		if !ok {
+			if null.IsNil(v) {
+				return xs, nil
+			}
+			return xs, v
+		}
+		xs = append(xs, car)
+		v = cdr
+	}
+}
+
+// anyOfElements returns the elements of an any-of to print, and how many
+// were elided.
+func (p *printer) anyOfElements(v types.Value) ([]types.Value, int, bool) {
+	if !anyof.Is(v) || anyof.IsMaybe(v) {
+		return nil, 0, false
+	}
+	xs, _ := anyof.PossibleValues(v)
+	max := p.opts.MaxAnyOfElements
+	if max <= 0 || len(xs) <= max {
+		return xs, 0, true
+	}
+	return xs[:max], len(xs) - max, true
+}
+
+func elisionString(elided int) string {
+	return fmt.Sprintf("... +%d more", elided)
+}
+
+func (p *printer) flat(v types.Value) string {
+	if xs, elided, ok := p.anyOfElements(v); ok {
+		var parts []string
+		for _, x := range xs {
+			parts = append(parts, p.flat(x))
+		}
+		if elided > 0 {
+			parts = append(parts, elisionString(elided))
+		}
+		return fmt.Sprintf("#any-of(%s)", strings.Join(parts, " "))
+	}
+
+	if !cons.IsCons(v) {
+		return v.String()
+	}
+
+	xs, tail := unwrapList(v)
+	if tail == nil {
+		if prefix, ok := quoteShorthand(xs); ok {
+			return prefix + p.flat(xs[1])
+		}
+	}
+
+	var parts []string
+	for _, x := range xs {
+		parts = append(parts, p.flat(x))
+	}
+	if tail != nil {
+		parts = append(parts, ".", p.flat(tail))
+	}
+	return fmt.Sprintf("(%s)", strings.Join(parts, " "))
+}
+
+// layout writes v starting at the given column, breaking lines as needed.
+func (p *printer) layout(v types.Value, column int) {
+	flat := p.flat(v)
+	if p.fits(flat, column) {
+		p.buf.WriteString(flat)
+		return
+	}
+
+	if xs, elided, ok := p.anyOfElements(v); ok {
+		opener := "#any-of("
+		p.buf.WriteString(opener)
+		p.fill(xs, column+len(opener), elided)
+		p.buf.WriteString(")")
+		return
+	}
+
+	if !cons.IsCons(v) {
+		p.buf.WriteString(flat)
+		return
+	}
+
+	xs, tail := unwrapList(v)
+
+	if tail != nil {
+		p.buf.WriteString("(")
+		p.fill(xs, column+1, 0)
+		p.newline(column + 1)
+		p.buf.WriteString(". ")
+		p.layout(tail, column+3)
+		p.buf.WriteString(")")
+		return
+	}
+
+	if prefix, ok := quoteShorthand(xs); ok {
+		p.buf.WriteString(prefix)
+		p.layout(xs[1], column+len(prefix))
+		return
+	}
+
+	name, err := symbol.Name(xs[0])
+	if err != nil {
+		// Not code; treat as data.
+		p.buf.WriteString("(")
+		p.fill(xs, column+1, 0)
+		p.buf.WriteString(")")
+		return
+	}
+
+	if distinguished, ok := bodyForms[name]; ok {
+		p.layoutBodyForm(xs, column, distinguished)
+		return
+	}
+
+	p.layoutCall(xs, column)
+}
+
+// fill lays out elements packed onto as few lines as possible, with
+// continuation lines starting at column.
+func (p *printer) fill(xs []types.Value, column int, elided int) {
+	current := column
+	mustBreak := false
+
+	separate := func(s string) {
+		if !mustBreak && p.fits(s, current+1) {
+			p.buf.WriteString(" ")
+			current++
+			return
+		}
+		p.newline(column)
+		current = column
+	}
+
+	for i, x := range xs {
+		flat := p.flat(x)
+		if i > 0 {
+			separate(flat)
+		}
+		mustBreak = false
+		if p.fits(flat, current) {
+			p.buf.WriteString(flat)
+			current += len(flat)
+			continue
+		}
+		p.layout(x, current)
+		mustBreak = true
+	}
+
+	if elided > 0 {
+		s := elisionString(elided)
+		if len(xs) > 0 {
+			separate(s)
+		}
+		p.buf.WriteString(s)
+	}
+}
+
+// layoutBodyForm lays out forms like (let (bindings) body...), with the
+// distinguished arguments on the first line and the body indented by two.
+// A distinguished argument after one that takes several lines goes on a
+// line of its own, lined up with the first.
+func (p *printer) layoutBodyForm(xs []types.Value, column int, distinguished int) {
+	head := p.flat(xs[0])
+	p.buf.WriteString("(")
+	p.buf.WriteString(head)
+	aligned := column + 2 + len(head)
+
+	rest := xs[1:]
+	multiline := false
+	for i := 0; i < distinguished && len(rest) > 0; i++ {
+		if multiline {
+			p.newline(aligned)
+		} else {
+			p.buf.WriteString(" ")
+		}
+		start := p.buf.Len()
+		p.layoutBindingsOrValue(rest[0], p.column(), i == 0 && functionBindingForms[head])
+		multiline = strings.Contains(p.buf.String()[start:], "\n")
+		rest = rest[1:]
+	}
+
+	for _, x := range rest {
+		p.newline(column + 2)
+		p.layout(x, column+2)
+	}
+	p.buf.WriteString(")")
+}
+
+// layoutBindingsOrValue lays out a list such as a let binding list with one
+// element per line, so that bindings line up. Bindings of functions are laid
+// out like defun!s.
+func (p *printer) layoutBindingsOrValue(v types.Value, column int, functions bool) {
+	flat := p.flat(v)
+	if p.fits(flat, column) || !cons.IsCons(v) {
+		p.layout(v, column)
+		return
+	}
+	xs, tail := unwrapList(v)
+	if tail != nil || symbol.Is(xs[0]) {
+		p.layout(v, column)
+		return
+	}
+	p.buf.WriteString("(")
+	for i, x := range xs {
+		if i > 0 {
+			p.newline(column + 1)
+		}
+		if binding, tail := unwrapList(x); functions && tail == nil && len(binding) > 2 && symbol.Is(binding[0]) && !p.fits(p.flat(x), column+1) {
+			p.layoutBodyForm(binding, column+1, 1)
+			continue
+		}
+		p.layout(x, column+1)
+	}
+	p.buf.WriteString(")")
+}
+
+// layoutCall lays out a function call or a form like if or cond, with the
+// arguments aligned under the first argument, one per line.
+func (p *printer) layoutCall(xs []types.Value, column int) {
+	head := p.flat(xs[0])
+	p.buf.WriteString("(")
+	p.buf.WriteString(head)
+
+	argColumn := column + 1 + len(head) + 1
+	if len(xs) > 1 && !p.fits(p.flat(xs[1]), argColumn) && argColumn > column+2 {
+		// The head is too wide to align the arguments after it.
+		argColumn = column + 2
+		for _, x := range xs[1:] {
+			p.newline(argColumn)
+			p.layout(x, argColumn)
+		}
+		p.buf.WriteString(")")
+		return
+	}
+
+	for i, x := range xs[1:] {
+		if i == 0 {
+			p.buf.WriteString(" ")
+		} else {
+			p.newline(argColumn)
+		}
+		p.layout(x, argColumn)
+	}
+	p.buf.WriteString(")")
+}
@@ -0,0 +1,67 @@
+package pretty
+
+import (
+	"testing"
+
+	"github.com/steinarvk/heisenlisp/gen/parser"
+	"github.com/steinarvk/heisenlisp/types"
+	"github.com/steinarvk/heisenlisp/value/integer"
+	"github.com/steinarvk/heisenlisp/value/unknowns/anyof"
+
+	_ "github.com/steinarvk/heisenlisp/cyclebreaker/impl"
+)
+
+func parseValue(t *testing.T, s string) types.Value {
+	rv, err := parser.Parse("<testcase>", []byte(s))
+	if err != nil {
+		t.Fatalf("parser.Parse(%q) = err: %v", s, err)
+	}
+	return rv.([]interface{})[0].(types.Value)
+}
+
+func TestSprint(t *testing.T) {
+	testcases := []struct {
+		width int
+		input string
+		want  string
+	}{
+		{80, "(+ 1 2)", "(+ 1 2)"},
+		{80, "(quote (a b))", "'(a b)"},
+		{80, "(quasiquote (a (unquote b) (unquote-splicing c)))", "`(a ,b ,@c)"},
+		{80, "(1 2 . 3)", "(1 2 . 3)"},
+		{20, "(if (= x 1) (foo bar baz) (quux quuux))", "(if (= x 1)\n    (foo bar baz)\n    (quux quuux))"},
+		{20, "(cond ((= x 1) 2) ((= x 2) 3))", "(cond ((= x 1) 2)\n      ((= x 2) 3))"},
+		{15, "(let ((x 1) (y 2)) (+ x y) (* x y))", "(let ((x 1)\n      (y 2))\n  (+ x y)\n  (* x y))"},
+		{30, "(defun! f (x y) (+ x (* y y)) (- x y))", "(defun! f (x y)\n  (+ x (* y y))\n  (- x y))"},
+		{20, "(1 2 3 4 5 6 7 8 9 10 11 12)", "(1 2 3 4 5 6 7 8 9\n 10 11 12)"},
+		{30, "(labels ((f (x) (+ x (* x x x)))) (f 2))", "(labels ((f (x)\n           (+ x (* x x x))))\n  (f 2))"},
+		{30, "(do ((i 0 (+ i 1)) (j 0 (+ j 1))) ((= i 10000) (list i j)) (f i))", "(do ((i 0 (+ i 1))\n     (j 0 (+ j 1)))\n    ((= i 10000) (list i j))\n  (f i))"},
+	}
+
+	for _, testcase := range testcases {
+		opts := Options{Width: testcase.width}
+		got := opts.Sprint(parseValue(t, testcase.input))
+		if got != testcase.want {
+			t.Errorf("Sprint(%q) [width %d] = %q want %q", testcase.input, testcase.width, got, testcase.want)
+		}
+	}
+}
+
+func TestAnyOfElision(t *testing.T) {
+	var xs []types.Value
+	for i := int64(0); i < 30; i++ {
+		xs = append(xs, integer.FromInt64(i))
+	}
+	v := anyof.NewOrPanic(xs)
+
+	opts := Options{Width: 200, MaxAnyOfElements: 3}
+	want := "#any-of(0 1 2 ... +27 more)"
+	if got := opts.Sprint(v); got != want {
+		t.Errorf("Sprint(%v) = %q want %q", v, got, want)
+	}
+
+	opts = Options{Width: 200}
+	if got := opts.Sprint(v); got != v.String() {
+		t.Errorf("Sprint(%v) = %q want %q", v, got, v.String())
+	}
+}
@@ -0,0 +1,224 @@
+// Package reader is a comment-preserving reader for Heisenlisp source code.
+//
+// Unlike the parser in gen/parser, it does not produce values. Instead it
+// produces a syntax tree that keeps the source text of atoms, comments and
+// the position of blank lines, for use by tools such as the formatter.
+package reader
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type Kind int
+
+const (
+	Atom Kind = iota
+	List
+	Quote
+	Comment
+)
+
+type Node struct {
+	Kind Kind
+
+	// Text is the source text of an atom or a comment, or the prefix
+	// of a quote (one of ' ` , ,@).
+	Text string
+
+	// Children are the elements of a list, or the single quoted node.
+	Children []*Node
+
+	// BlankLineBefore is set if the node was preceded by at least one
+	// blank line.
+	BlankLineBefore bool
+
+	// SameLine is set for a comment that was on the same line as the
+	// preceding token.
+	SameLine bool
+
+	// Line is the line on which the node starts. For lists, EndLine is
+	// the line of the closing parenthesis.
+	Line    int
+	EndLine int
+}
+
+// IsMultiline is true for a list that spanned several lines in the source.
+func (n *Node) IsMultiline() bool { return n.EndLine > n.Line }
+
+func (n *Node) IsComment() bool { return n.Kind == Comment }
+
+type reader struct {
+	name string
+	src  []rune
+	pos  int
+	line int
+
+	// set while skipping whitespace before a token
+	newlinesBefore int
+}
+
+func (r *reader) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s:%d: %s", r.name, r.line, fmt.Sprintf(format, args...))
+}
+
+func (r *reader) eof() bool { return r.pos >= len(r.src) }
+
+func (r *reader) peek() rune { return r.src[r.pos] }
+
+func (r *reader) skipWhitespace() {
+	r.newlinesBefore = 0
+	for !r.eof() && unicode.IsSpace(r.peek()) {
+		if r.peek() == '\n' {
+			r.newlinesBefore++
+			r.line++
+		}
+		r.pos++
+	}
+}
+
+func isDelimiter(c rune) bool {
+	return unicode.IsSpace(c) || c == '(' || c == ')' || c == '"' || c == ';' || c == '\'' || c == '`' || c == ','
+}
+
+func (r *reader) readComment() *Node {
+	start := r.pos
+	for !r.eof() && r.peek() != '\n' {
+		r.pos++
+	}
+	return &Node{
+		Kind: Comment,
+		Text: strings.TrimRightFunc(string(r.src[start:r.pos]), unicode.IsSpace),
+		Line: r.line,
+	}
+}
+
+func (r *reader) readString() (*Node, error) {
+	start := r.pos
+	line := r.line
+	r.pos++ // opening quote
+	for {
+		if r.eof() {
+			return nil, r.errorf("unterminated string starting on line %d", line)
+		}
+		c := r.peek()
+		r.pos++
+		switch c {
+		case '\\':
+			if r.eof() {
+				return nil, r.errorf("unterminated string starting on line %d", line)
+			}
+			r.pos++
+		case '\n':
+			r.line++
+		case '"':
+			return &Node{Kind: Atom, Text: string(r.src[start:r.pos]), Line: line}, nil
+		}
+	}
+}
+
+func (r *reader) readAtom() *Node {
+	start := r.pos
+	for !r.eof() && !isDelimiter(r.peek()) {
+		r.pos++
+	}
+	return &Node{Kind: Atom, Text: string(r.src[start:r.pos]), Line: r.line}
+}
+
+// readSequence reads nodes (including comments) until a closing parenthesis
+// or the end of input.
+func (r *reader) readSequence(inList bool) ([]*Node, error) {
+	var rv []*Node
+	hasPreviousToken := inList
+
+	for {
+		r.skipWhitespace()
+		if r.eof() {
+			if inList {
+				return nil, r.errorf("unexpected end of input in list")
+			}
+			return rv, nil
+		}
+
+		blankLineBefore := r.newlinesBefore >= 2 && len(rv) > 0
+		sameLine := r.newlinesBefore == 0 && hasPreviousToken
+
+		if r.peek() == ')' {
+			if !inList {
+				return nil, r.errorf("unexpected ')'")
+			}
+			r.pos++
+			return rv, nil
+		}
+
+		var node *Node
+		if r.peek() == ';' {
+			node = r.readComment()
+			node.SameLine = sameLine
+		} else {
+			var err error
+			node, err = r.readExpr()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		node.BlankLineBefore = blankLineBefore
+		rv = append(rv, node)
+		hasPreviousToken = true
+	}
+}
+
+func (r *reader) readExpr() (*Node, error) {
+	c := r.peek()
+	switch {
+	case c == '(':
+		line := r.line
+		r.pos++
+		children, err := r.readSequence(true)
+		if err != nil {
+			return nil, err
+		}
+		return &Node{Kind: List, Children: children, Line: line, EndLine: r.line}, nil
+
+	case c == ')':
+		return nil, r.errorf("unexpected ')'")
+
+	case c == '"':
+		return r.readString()
+
+	case c == '\'' || c == '`' || c == ',':
+		line := r.line
+		prefix := string(c)
+		r.pos++
+		if c == ',' && !r.eof() && r.peek() == '@' {
+			prefix = ",@"
+			r.pos++
+		}
+		r.skipWhitespace()
+		if r.eof() {
+			return nil, r.errorf("unexpected end of input after %q", prefix)
+		}
+		if r.peek() == ';' || r.peek() == ')' {
+			return nil, r.errorf("expected expression after %q", prefix)
+		}
+		quoted, err := r.readExpr()
+		if err != nil {
+			return nil, err
+		}
+		return &Node{Kind: Quote, Text: prefix, Children: []*Node{quoted}, Line: line}, nil
+	}
+
+	return r.readAtom(), nil
+}
+
+// Read reads all top-level nodes (expressions and comments) from src.
+func Read(name string, src []byte) ([]*Node, error) {
+	r := &reader{
+		name: name,
+		src:  []rune(string(src)),
+		line: 1,
+	}
+	return r.readSequence(false)
+}
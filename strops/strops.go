@@ -0,0 +1,319 @@
+// Package strops implements the string builtins.
+//
+// Like the numeric builtins, they are lifted over uncertain arguments: given
+// any-ofs, they return the any-of of their results for each combination of
+// possible arguments.
+package strops
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/steinarvk/heisenlisp/lisperr"
+	"github.com/steinarvk/heisenlisp/listops"
+	"github.com/steinarvk/heisenlisp/number"
+	"github.com/steinarvk/heisenlisp/types"
+	"github.com/steinarvk/heisenlisp/value/cons"
+	"github.com/steinarvk/heisenlisp/value/integer"
+	"github.com/steinarvk/heisenlisp/value/null"
+	"github.com/steinarvk/heisenlisp/value/real"
+	"github.com/steinarvk/heisenlisp/value/str"
+	"github.com/steinarvk/heisenlisp/value/symbol"
+	"github.com/steinarvk/heisenlisp/value/unknowns/anyof"
+	"github.com/steinarvk/heisenlisp/value/unknowns/fullyunknown"
+)
+
+// lift applies f to each combination of the possible values of args, and
+// returns the possible results. If there are too many combinations, the
+// result is unknown.
+func lift(args []types.Value, f func(args []types.Value) (types.Value, error)) (types.Value, error) {
+	possible := make([][]types.Value, len(args))
+	combinations := int64(1)
+	for i, arg := range args {
+		values, ok := anyof.PossibleValues(arg)
+		if !ok {
+			return nil, lisperr.NotImplemented("string operation with non-enumerable uncertainty")
+		}
+		possible[i] = values
+		combinations *= int64(len(values))
+		if combinations > anyof.MaxAnyOfElements {
+			return fullyunknown.Value, nil
+		}
+	}
+
+	if combinations == 1 {
+		return f(args)
+	}
+
+	var results []types.Value
+	current := make([]types.Value, len(args))
+	var product func(i int) error
+	product = func(i int) error {
+		if i == len(args) {
+			rv, err := f(append([]types.Value(nil), current...))
+			if err != nil {
+				return err
+			}
+			results = append(results, rv)
+			return nil
+		}
+		for _, v := range possible[i] {
+			current[i] = v
+			if err := product(i + 1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := product(0); err != nil {
+		return nil, err
+	}
+	return anyof.New(results)
+}
+
+func liftUnary(a types.Value, f func(a types.Value) (types.Value, error)) (types.Value, error) {
+	return lift([]types.Value{a}, func(args []types.Value) (types.Value, error) {
+		return f(args[0])
+	})
+}
+
+func toString(v types.Value) (string, error) {
+	s, err := str.ToString(v)
+	if err != nil {
+		return "", lisperr.UnexpectedValue{"string", v}
+	}
+	return s, nil
+}
+
+// liftStrings is lift for functions taking only strings.
+func liftStrings(args []types.Value, f func(ss []string) (types.Value, error)) (types.Value, error) {
+	return lift(args, func(args []types.Value) (types.Value, error) {
+		ss := make([]string, len(args))
+		for i, arg := range args {
+			s, err := toString(arg)
+			if err != nil {
+				return nil, err
+			}
+			ss[i] = s
+		}
+		return f(ss)
+	})
+}
+
+func liftString(a types.Value, f func(s string) (types.Value, error)) (types.Value, error) {
+	return liftStrings([]types.Value{a}, func(ss []string) (types.Value, error) {
+		return f(ss[0])
+	})
+}
+
+// Length returns the number of characters in a string.
+func Length(s types.Value) (types.Value, error) {
+	return liftString(s, func(s string) (types.Value, error) {
+		return integer.FromInt64(int64(utf8.RuneCountInString(s))), nil
+	})
+}
+
+// Substring returns the characters of a string from the index start up to
+// (but not including) the index end, or to the end of the string if end is
+// nil.
+func Substring(s, start, end types.Value) (types.Value, error) {
+	return lift([]types.Value{s, start, end}, func(args []types.Value) (types.Value, error) {
+		s, err := toString(args[0])
+		if err != nil {
+			return nil, err
+		}
+		runes := []rune(s)
+
+		index := func(v types.Value) (int, error) {
+			i, err := integer.ToInt64(v)
+			if err != nil || i < 0 || i > int64(len(runes)) {
+				return 0, lisperr.UnexpectedValue{"index within string", v}
+			}
+			return int(i), nil
+		}
+
+		from, err := index(args[1])
+		if err != nil {
+			return nil, err
+		}
+		to := len(runes)
+		if !null.IsNil(args[2]) {
+			to, err = index(args[2])
+			if err != nil {
+				return nil, err
+			}
+		}
+		if to < from {
+			return nil, lisperr.UnexpectedValue{"end index not before start", args[2]}
+		}
+
+		return str.New(string(runes[from:to])), nil
+	})
+}
+
+// Append returns the strings concatenated.
+func Append(xs []types.Value) (types.Value, error) {
+	return liftStrings(xs, func(ss []string) (types.Value, error) {
+		return str.New(strings.Join(ss, "")), nil
+	})
+}
+
+// Split returns the substrings of a string separated by sep, or by runs of
+// whitespace if sep is nil.
+func Split(s, sep types.Value) (types.Value, error) {
+	return lift([]types.Value{s, sep}, func(args []types.Value) (types.Value, error) {
+		s, err := toString(args[0])
+		if err != nil {
+			return nil, err
+		}
+
+		var parts []string
+		if null.IsNil(args[1]) {
+			parts = strings.Fields(s)
+		} else {
+			sep, err := str.ToString(args[1])
+			if err != nil {
+				return nil, lisperr.UnexpectedValue{"string separator", args[1]}
+			}
+			parts = strings.Split(s, sep)
+		}
+
+		return stringList(parts), nil
+	})
+}
+
+func stringList(ss []string) types.Value {
+	xs := make([]types.Value, len(ss))
+	for i, s := range ss {
+		xs[i] = str.New(s)
+	}
+	return cons.NewChain(xs, null.Nil)
+}
+
+// Join returns the strings of a list concatenated with sep between them.
+// The list may be uncertain, like those folded over by fold-left.
+func Join(xs, sep types.Value) (types.Value, error) {
+	// The joined strings so far are nil until the first.
+	joined, err := listops.FoldLeft(func(acc, x types.Value) (types.Value, error) {
+		return lift([]types.Value{acc, x, sep}, func(args []types.Value) (types.Value, error) {
+			s, err := toString(args[1])
+			if err != nil {
+				return nil, err
+			}
+			if null.IsNil(args[0]) {
+				return str.New(s), nil
+			}
+			sofar, err := toString(args[0])
+			if err != nil {
+				return nil, err
+			}
+			sep, err := toString(args[2])
+			if err != nil {
+				return nil, err
+			}
+			return str.New(sofar + sep + s), nil
+		})
+	}, null.Nil, xs)
+	if err != nil {
+		return nil, err
+	}
+
+	return liftUnary(joined, func(joined types.Value) (types.Value, error) {
+		if null.IsNil(joined) {
+			return str.New(""), nil
+		}
+		return joined, nil
+	})
+}
+
+// Upcase returns a string in upper case.
+func Upcase(s types.Value) (types.Value, error) {
+	return liftString(s, func(s string) (types.Value, error) {
+		return str.New(strings.ToUpper(s)), nil
+	})
+}
+
+// Downcase returns a string in lower case.
+func Downcase(s types.Value) (types.Value, error) {
+	return liftString(s, func(s string) (types.Value, error) {
+		return str.New(strings.ToLower(s)), nil
+	})
+}
+
+// Trim returns a string without leading and trailing whitespace.
+func Trim(s types.Value) (types.Value, error) {
+	return liftString(s, func(s string) (types.Value, error) {
+		return str.New(strings.TrimSpace(s)), nil
+	})
+}
+
+// Index returns the index of the first occurrence of sub in a string, or nil
+// if there is none.
+func Index(s, sub types.Value) (types.Value, error) {
+	return liftStrings([]types.Value{s, sub}, func(ss []string) (types.Value, error) {
+		i := strings.Index(ss[0], ss[1])
+		if i < 0 {
+			return null.Nil, nil
+		}
+		return integer.FromInt64(int64(utf8.RuneCountInString(ss[0][:i]))), nil
+	})
+}
+
+// ToList returns the characters of a string, as a list of strings.
+func ToList(s types.Value) (types.Value, error) {
+	return liftString(s, func(s string) (types.Value, error) {
+		return stringList(strings.Split(s, "")), nil
+	})
+}
+
+// ToSymbol returns the symbol named by a string.
+func ToSymbol(s types.Value) (types.Value, error) {
+	return liftString(s, func(s string) (types.Value, error) {
+		return symbol.New(s), nil
+	})
+}
+
+// FromSymbol returns the name of a symbol.
+func FromSymbol(v types.Value) (types.Value, error) {
+	return liftUnary(v, func(v types.Value) (types.Value, error) {
+		name, err := symbol.Name(v)
+		if err != nil {
+			return nil, lisperr.UnexpectedValue{"symbol", v}
+		}
+		return str.New(name), nil
+	})
+}
+
+// FromNumber returns a number written as a string, which ToNumber reads
+// back as the same number.
+func FromNumber(v types.Value) (types.Value, error) {
+	return liftUnary(v, func(v types.Value) (types.Value, error) {
+		n, ok := v.(types.Numeric)
+		if !ok {
+			return nil, lisperr.UnexpectedValue{"number", v}
+		}
+
+		// Floats are written as precisely as needed to read them back.
+		if x, ok := n.AsDouble(); ok && n.TypeName() == real.TypeName {
+			s := strconv.FormatFloat(x, 'g', -1, 64)
+			if !strings.ContainsAny(s, ".eIN") {
+				s += ".0"
+			}
+			return str.New(s), nil
+		}
+
+		return str.New(n.String()), nil
+	})
+}
+
+// ToNumber returns the number written in a string, or nil if it is not one.
+func ToNumber(s types.Value) (types.Value, error) {
+	return liftString(s, func(s string) (types.Value, error) {
+		n, err := number.FromString(strings.TrimSpace(s))
+		if err != nil {
+			return null.Nil, nil
+		}
+		return n, nil
+	})
+}
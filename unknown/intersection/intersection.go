@@ -51,6 +51,10 @@ func unknownCanHaveValue(a types.Unknown, val types.Value) (bool, error) {
 
 	// Can exclude on left side: fullyunknown, typed.
 
+	if contains, ok := anyof.Contains(a, val); ok {
+		return contains, nil
+	}
+
 	if vals, ok := anyof.PossibleValues(a); ok {
 		for _, aVal := range vals {
 			tv, err := cyclebreaker.Equals(aVal, val)
@@ -0,0 +1,136 @@
+// Package condition implements condition objects: the values caught by try,
+// describing an exception by a kind (a symbol) and a message.
+package condition
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/steinarvk/heisenlisp/hashcode"
+	"github.com/steinarvk/heisenlisp/lisperr"
+	"github.com/steinarvk/heisenlisp/types"
+)
+
+const TypeName = "condition"
+
+// Kinds of the conditions made from errors raised by builtins.
+const (
+	UnexpectedValue = "unexpected-value"
+	UnboundVariable = "unbound-variable"
+	DivisionByZero  = "division-by-zero"
+	NotImplemented  = "not-implemented"
+	WrongArity      = "wrong-arity"
+	NoMatch         = "no-matching-clause"
+
+	// Exception is the kind of values thrown with throw-exception that are
+	// not themselves conditions.
+	Exception = "exception"
+)
+
+var (
+	metricNewCondition = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "hlisp",
+			Name:      "new_condition",
+			Help:      "New condition values created",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(metricNewCondition)
+}
+
+type conditionValue struct {
+	kind    string
+	message string
+}
+
+func New(kind, message string) types.Value {
+	metricNewCondition.Inc()
+	return conditionValue{kind, message}
+}
+
+func (c conditionValue) AtomEquals(other types.Atom) bool {
+	o, ok := other.(conditionValue)
+	return ok && o == c
+}
+
+func (c conditionValue) String() string {
+	return fmt.Sprintf("#<condition %s %q>", c.kind, c.message)
+}
+
+func (c conditionValue) Eval(_ types.Env) (types.Value, error) { return c, nil }
+func (c conditionValue) Falsey() bool                          { return false }
+func (c conditionValue) TypeName() string                      { return TypeName }
+func (c conditionValue) Hashcode() uint32 {
+	return hashcode.Hash("condition:", []byte(c.kind), []byte(c.message))
+}
+
+func Is(v types.Value) bool {
+	_, ok := v.(conditionValue)
+	return ok
+}
+
+// Kind returns the kind of a condition.
+func Kind(v types.Value) (string, error) {
+	c, ok := v.(conditionValue)
+	if !ok {
+		return "", lisperr.UnexpectedValue{Expectation: TypeName, Value: v}
+	}
+	return c.kind, nil
+}
+
+// Message returns the message of a condition.
+func Message(v types.Value) (string, error) {
+	c, ok := v.(conditionValue)
+	if !ok {
+		return "", lisperr.UnexpectedValue{Expectation: TypeName, Value: v}
+	}
+	return c.message, nil
+}
+
+// FromError returns the value that can be caught for an error, and its kind.
+// For an exception that is the thrown value; errors raised by builtins are
+// converted to conditions. Other errors cannot be caught.
+func FromError(err error) (types.Value, string, bool) {
+	var exc lisperr.LispException
+	if errors.As(err, &exc) {
+		if kind, err := Kind(exc.Value()); err == nil {
+			return exc.Value(), kind, true
+		}
+		return exc.Value(), Exception, true
+	}
+
+	var unexpected lisperr.UnexpectedValue
+	if errors.As(err, &unexpected) {
+		return New(UnexpectedValue, unexpected.Error()), UnexpectedValue, true
+	}
+
+	var unbound lisperr.UnboundVariable
+	if errors.As(err, &unbound) {
+		return New(UnboundVariable, unbound.Error()), UnboundVariable, true
+	}
+
+	if errors.Is(err, lisperr.DivisionByZero) {
+		return New(DivisionByZero, lisperr.DivisionByZero.Error()), DivisionByZero, true
+	}
+
+	var notImplemented lisperr.NotImplemented
+	if errors.As(err, &notImplemented) {
+		return New(NotImplemented, notImplemented.Error()), NotImplemented, true
+	}
+
+	var wrongArity lisperr.WrongArity
+	if errors.As(err, &wrongArity) {
+		return New(WrongArity, wrongArity.Error()), WrongArity, true
+	}
+
+	var noMatch lisperr.NoMatchingClause
+	if errors.As(err, &noMatch) {
+		return New(NoMatch, noMatch.Error()), NoMatch, true
+	}
+
+	return nil, "", false
+}
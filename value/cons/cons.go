@@ -5,14 +5,17 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/steinarvk/heisenlisp/hashcode"
+	"github.com/steinarvk/heisenlisp/hashcons"
 	"github.com/steinarvk/heisenlisp/tracing"
 	"github.com/steinarvk/heisenlisp/types"
 	"github.com/steinarvk/heisenlisp/value/null"
+	"github.com/steinarvk/heisenlisp/value/unknowns/maythrow"
 )
 
 const TypeName = "cons"
@@ -42,24 +45,33 @@ type consValue struct {
 
 	h uint32
 
-	conversionsToList int
-	cachedListForm    []types.Value
+	// Conses may be shared between goroutines (see package parallel), so
+	// the caches are accessed atomically.
+	conversionsToList int32
+	cachedListForm    atomic.Value
+
+	// certain is set if the cons contains no uncertain values.
+	certain bool
+
+	// shared is set if the cons was hash-consed, so that the same cons may
+	// be constructed in unrelated places.
+	shared bool
 
 	// does _not_ count for equality.
 	// purely an optimization.
-	markedAsMacroexpanded bool
+	markedAsMacroexpanded uint32
 }
 
+var table = hashcons.NewTable()
+
 func MarkAsMacroexpanded(v types.Value) bool {
 	c, ok := v.(*consValue)
-	if !ok {
-		return false
-	}
-	if c.markedAsMacroexpanded {
+	if !ok || c.shared {
+		// A hash-consed form may also be constructed later, when it would
+		// expand differently (e.g. once a macro has been defined).
 		return false
 	}
-	c.markedAsMacroexpanded = true
-	return true
+	return atomic.CompareAndSwapUint32(&c.markedAsMacroexpanded, 0, 1)
 }
 
 func IsMarkedAsMacroexpanded(v types.Value) bool {
@@ -67,7 +79,7 @@ func IsMarkedAsMacroexpanded(v types.Value) bool {
 	if !ok {
 		return false
 	}
-	return c.markedAsMacroexpanded
+	return atomic.LoadUint32(&c.markedAsMacroexpanded) != 0
 }
 
 func (_ *consValue) TypeName() string { return TypeName }
@@ -114,12 +126,46 @@ func New(car, cdr types.Value) types.Value {
 		cdr = null.Nil
 	}
 
-	metricNewCons.Inc()
-	return &consValue{
-		car: car,
-		cdr: cdr,
-		h:   hashcode.Hash("cons:", []byte(string(car.Hashcode())), []byte(string(cdr.Hashcode()))),
+	h := hashcode.Hash("cons:", hashcode.Bytes(car.Hashcode()), hashcode.Bytes(cdr.Hashcode()))
+
+	create := func() types.Value {
+		metricNewCons.Inc()
+		return &consValue{
+			car:     car,
+			cdr:     cdr,
+			h:       h,
+			certain: isCertain(car) && isCertain(cdr),
+			shared:  hashcons.Enabled,
+		}
+	}
+
+	if !hashcons.Enabled {
+		return create()
 	}
+
+	return table.Intern(h, func(v types.Value) bool {
+		other := v.(*consValue)
+		return hashcons.Identical(other.car, car) && hashcons.Identical(other.cdr, cdr)
+	}, create)
+}
+
+// isCertain checks whether a value is certain to be equal to itself: an atom,
+// or a cons of certain values.
+func isCertain(v types.Value) bool {
+	if c, ok := v.(*consValue); ok {
+		return c.certain
+	}
+	_, isAtom := v.(types.Atom)
+	_, isUnknown := v.(types.Unknown)
+	return isAtom && !isUnknown
+}
+
+// Same checks whether two values are the same cons, containing no uncertain
+// values, so that they are certainly equal. With hash-consing, equal lists
+// are usually the same cons.
+func Same(a, b types.Value) bool {
+	c, ok := a.(*consValue)
+	return ok && c.certain && a == b
 }
 
 func Decompose(v types.Value) (types.Value, types.Value, bool) {
@@ -196,6 +242,10 @@ func (c *consValue) Eval(e types.Env) (types.Value, error) {
 		return nil, errors.New("impure call in pure context")
 	}
 
+	// Arguments that may throw are passed on as their normal values; the
+	// result may throw the same exceptions.
+	var exceptions []error
+
 	n := len(unevaluatedParams)
 	params := make([]types.Value, n, n)
 	for i, unevaled := range unevaluatedParams {
@@ -203,10 +253,19 @@ func (c *consValue) Eval(e types.Env) (types.Value, error) {
 		if err != nil {
 			return nil, err
 		}
-		params[i] = evaled
+		var paramExceptions []error
+		params[i], paramExceptions = maythrow.Split(evaled)
+		exceptions = append(exceptions, paramExceptions...)
 	}
 
+	return Call(callable, params, exceptions)
+}
+
+// Call calls a function with evaluated parameters, which may throw the given
+// exceptions instead (so the result may too).
+func Call(callable types.Callable, params []types.Value, exceptions []error) (types.Value, error) {
 	var rv types.Value
+	var err error
 	run := func() {
 		rv, err = callable.Call(params)
 	}
@@ -225,18 +284,17 @@ func (c *consValue) Eval(e types.Env) (types.Value, error) {
 		tracing.WriteJSON(w, tracing.EndEvent, time.Now(), pid, tid, callable.CallableName(), nil)
 	}
 	tracing.Run(run, tracePre, tracePost)
-	return rv, err
+	if err != nil {
+		return nil, err
+	}
+	return maythrow.New(rv, exceptions), nil
 }
 
 func (c *consValue) asProperList() ([]types.Value, bool) {
-	if c.cachedListForm != nil {
-		return c.cachedListForm, true
-	}
-	cache := false
-	c.conversionsToList++
-	if c.conversionsToList > 2 {
-		cache = true
+	if cached, ok := c.cachedListForm.Load().([]types.Value); ok {
+		return cached, true
 	}
+	cache := atomic.AddInt32(&c.conversionsToList, 1) > 2
 
 	var rv []types.Value
 
@@ -245,7 +303,7 @@ func (c *consValue) asProperList() ([]types.Value, bool) {
 		rv = append(rv, node.car)
 		if null.IsNil(node.cdr) {
 			if cache {
-				c.cachedListForm = rv
+				c.cachedListForm.Store(rv)
 			}
 			return rv, true
 		}
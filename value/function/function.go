@@ -7,9 +7,11 @@ import (
 	"github.com/steinarvk/heisenlisp/hashcode"
 	"github.com/steinarvk/heisenlisp/lambdalist"
 	"github.com/steinarvk/heisenlisp/lisperr"
+	"github.com/steinarvk/heisenlisp/outcome"
 	"github.com/steinarvk/heisenlisp/macroexpand"
 	"github.com/steinarvk/heisenlisp/purity"
 	"github.com/steinarvk/heisenlisp/types"
+	"github.com/steinarvk/heisenlisp/value/unknowns/maythrow"
 )
 
 var (
@@ -35,12 +37,22 @@ func init() {
 	prometheus.MustRegister(metricLispFunctionCall)
 }
 
+// Code is a compiled expression (see package compile).
+type Code func(e types.Env) (types.Value, error)
+
 type functionValue struct {
 	name       string
 	lexicalEnv types.Env
 	lambdaList *lambdalist.LambdaList
 	body       []types.Value
 	pure       bool
+
+	// code is the compiled body, if any.
+	code Code
+
+	// program is the body compiled to bytecode, if any (see package
+	// compile).
+	program interface{}
 }
 
 var _ types.Value = &functionValue{}
@@ -72,6 +84,54 @@ func New(env types.Env, name string, formalParams types.Value, body []types.Valu
 	return rv, nil
 }
 
+// NewCompiled creates a function with an already parsed lambda list and a
+// compiled body. If the body is compiled to bytecode, program is its
+// bytecode program, run by code.
+func NewCompiled(env types.Env, name string, ll *lambdalist.LambdaList, body []types.Value, code Code, program interface{}) types.Value {
+	metricNewLispFunction.Inc()
+	return &functionValue{
+		name:       name,
+		lexicalEnv: env,
+		lambdaList: ll,
+		body:       body,
+		pure:       purity.NameIsPure(name),
+		code:       code,
+		program:    program,
+	}
+}
+
+// Enter binds the arguments of a call to a function compiled to bytecode,
+// returning the environment in which to run its program. The bytecode VM
+// runs the program itself instead of calling the function, so that tail
+// calls do not grow the stack. The program is nil for other callables, and
+// for functions that destructure their arguments (which may run in several
+// possible worlds).
+func Enter(v types.Value, params []types.Value) (types.Env, interface{}, error) {
+	f, ok := v.(*functionValue)
+	if !ok || f.program == nil || f.lambdaList.Destructures() {
+		return nil, nil, nil
+	}
+
+	env, err := f.lambdaList.BindArgs(f.lexicalEnv, params, f.pure)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	metricLispFunctionCall.Inc()
+	return env, f.program, nil
+}
+
+// ErrorContext returns the context with which errors in a function are
+// wrapped when it is called, for the bytecode VM to use for functions it
+// enters. It is empty for other callables.
+func ErrorContext(v types.Value) string {
+	f, ok := v.(*functionValue)
+	if !ok {
+		return ""
+	}
+	return f.errorcontext()
+}
+
 func (_ *functionValue) TypeName() string { return "function" }
 func (f *functionValue) errorcontext() string {
 	if f.name == "" {
@@ -81,23 +141,43 @@ func (f *functionValue) errorcontext() string {
 }
 
 func (f *functionValue) Call(params []types.Value) (types.Value, error) {
+	envs, exceptions, err := f.lambdaList.BindArgsInWorlds(f.lexicalEnv, params, f.pure)
+	if err != nil {
+		return nil, err
+	}
+
+	return outcome.InWorlds(len(envs), exceptions, func(i int) (types.Value, error) {
+		return f.run(envs[i])
+	})
+}
+
+// run runs the body of the function in the environment of a call.
+func (f *functionValue) run(env types.Env) (types.Value, error) {
 	var rv types.Value
 	var err error
 
-	env, err := f.lambdaList.BindArgs(f.lexicalEnv, params, f.pure)
-	if err != nil {
-		return nil, err
+	if f.code != nil {
+		rv, err = f.code(env)
+		if err != nil {
+			return nil, lisperr.Wrap(f.errorcontext(), err)
+		}
+		metricLispFunctionCall.Inc()
+		return rv, nil
 	}
 
+	var exceptions []error
 	for _, stmt := range f.body {
 		rv, err = stmt.Eval(env)
 		if err != nil {
 			return nil, lisperr.Wrap(f.errorcontext(), err)
 		}
+		var stmtExceptions []error
+		rv, stmtExceptions = maythrow.Split(rv)
+		exceptions = append(exceptions, stmtExceptions...)
 	}
 
 	metricLispFunctionCall.Inc()
-	return rv, nil
+	return maythrow.New(rv, exceptions), nil
 }
 
 func (f *functionValue) String() string {
@@ -7,6 +7,7 @@ import (
 	"strconv"
 
 	"github.com/steinarvk/heisenlisp/hashcode"
+	"github.com/steinarvk/heisenlisp/hashcons"
 	"github.com/steinarvk/heisenlisp/types"
 )
 
@@ -39,7 +40,25 @@ func (i integer) AsBigrat() (*big.Rat, bool) { return big.NewRat(int64(i), 1), t
 
 func FromInt(v int) types.Numeric { return FromInt64(int64(v)) }
 
+const (
+	minSmall = -128
+	maxSmall = 1023
+)
+
+// small holds the small integers, so that they need not be allocated anew
+// when hash-consing.
+var small [maxSmall - minSmall + 1]types.Numeric
+
+func init() {
+	for i := range small {
+		small[i] = integer(i + minSmall)
+	}
+}
+
 func FromInt64(v int64) types.Numeric {
+	if hashcons.Enabled && v >= minSmall && v <= maxSmall {
+		return small[v-minSmall]
+	}
 	return integer(v)
 }
 
@@ -56,7 +75,7 @@ func Parse(s string) (types.Numeric, error) {
 	if err != nil {
 		return ParseBig(s)
 	}
-	return integer(n), nil
+	return FromInt64(n), nil
 }
 
 func (i integer) Hashcode() uint32 {
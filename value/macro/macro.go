@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/steinarvk/heisenlisp/expr"
 	"github.com/steinarvk/heisenlisp/hashcode"
 	"github.com/steinarvk/heisenlisp/lambdalist"
 	"github.com/steinarvk/heisenlisp/macroexpand"
@@ -52,6 +53,7 @@ func (f *macroValue) Expand(params []types.Value) (types.Value, error) {
 	if err != nil {
 		return nil, fmt.Errorf("%s%v", f.errorprefix(), err)
 	}
+	expr.MarkMacroExpansion(env)
 
 	for _, stmt := range f.body {
 		rv, err = stmt.Eval(env)
@@ -0,0 +1,112 @@
+// Package record implements immutable records, as defined by defstruct!.
+package record
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/steinarvk/heisenlisp/hashcode"
+	"github.com/steinarvk/heisenlisp/types"
+)
+
+var (
+	metricNewRecord = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "hlisp",
+			Name:      "new_record",
+			Help:      "New record values created",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(metricNewRecord)
+}
+
+// Type is a record type. Each definition creates a distinct type, even if
+// the name is reused; the name is used as the TypeName of its records.
+type Type struct {
+	name       string
+	fields     []string
+	fieldIndex map[string]int
+}
+
+func NewType(name string, fields []string) (*Type, error) {
+	rv := &Type{
+		name:       name,
+		fields:     fields,
+		fieldIndex: map[string]int{},
+	}
+	for i, field := range fields {
+		if _, present := rv.fieldIndex[field]; present {
+			return nil, fmt.Errorf("duplicate field %q in record type %q", field, name)
+		}
+		rv.fieldIndex[field] = i
+	}
+	return rv, nil
+}
+
+func (t *Type) Name() string { return t.name }
+
+func (t *Type) Fields() []string { return t.fields }
+
+// FieldIndex returns the position of a field in the records of this type.
+func (t *Type) FieldIndex(field string) (int, bool) {
+	i, ok := t.fieldIndex[field]
+	return i, ok
+}
+
+type recordValue struct {
+	typ    *Type
+	values []types.Value
+	h      uint32
+}
+
+// New creates a record of type t, with one value per field.
+func New(t *Type, values []types.Value) (types.Value, error) {
+	if len(values) != len(t.fields) {
+		return nil, fmt.Errorf("record type %q has %d fields, got %d values", t.name, len(t.fields), len(values))
+	}
+
+	hasher := hashcode.New()
+	hasher.Write([]byte("record:" + t.name))
+	for _, v := range values {
+		binary.Write(hasher, binary.LittleEndian, v.Hashcode())
+	}
+
+	metricNewRecord.Inc()
+	return &recordValue{
+		typ:    t,
+		values: values,
+		h:      hasher.Sum32(),
+	}, nil
+}
+
+func (r *recordValue) String() string {
+	var parts []string
+	for i, field := range r.typ.fields {
+		parts = append(parts, ":"+field, r.values[i].String())
+	}
+	return fmt.Sprintf("#%s(%s)", r.typ.name, strings.Join(parts, " "))
+}
+
+func (r *recordValue) Eval(_ types.Env) (types.Value, error) { return r, nil }
+func (r *recordValue) Falsey() bool                          { return false }
+func (r *recordValue) TypeName() string                      { return r.typ.name }
+func (r *recordValue) Hashcode() uint32                      { return r.h }
+
+// Decompose returns the type and field values of a record.
+func Decompose(v types.Value) (*Type, []types.Value, bool) {
+	r, ok := v.(*recordValue)
+	if !ok {
+		return nil, nil, false
+	}
+	return r.typ, r.values, true
+}
+
+func Is(v types.Value) bool {
+	_, ok := v.(*recordValue)
+	return ok
+}
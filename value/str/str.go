@@ -6,6 +6,7 @@ import (
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/steinarvk/heisenlisp/hashcode"
+	"github.com/steinarvk/heisenlisp/hashcons"
 	"github.com/steinarvk/heisenlisp/types"
 )
 
@@ -72,9 +73,21 @@ func ToString(v types.Value) (string, error) {
 	return string(rv), nil
 }
 
+var table = hashcons.NewTable()
+
 func New(s string) types.Value {
-	metricNewString.Inc()
-	return stringValue(s)
+	if !hashcons.Enabled {
+		metricNewString.Inc()
+		return stringValue(s)
+	}
+
+	// Equal strings then share their bytes, so comparing them is cheap.
+	return table.Intern(stringValue(s).Hashcode(), func(v types.Value) bool {
+		return v.(stringValue) == stringValue(s)
+	}, func() types.Value {
+		metricNewString.Inc()
+		return stringValue(s)
+	})
 }
 
 func (s stringValue) Hashcode() uint32 {
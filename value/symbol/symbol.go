@@ -2,7 +2,9 @@ package symbol
 
 import (
 	"errors"
+	"fmt"
 	"strings"
+	"sync/atomic"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/steinarvk/heisenlisp/hashcode"
@@ -13,6 +15,18 @@ import (
 
 const (
 	TypeName = "symbol"
+
+	// KeywordPrefix marks a symbol as a keyword. Keywords evaluate to
+	// themselves, and are used for keyword arguments (see &key in lambdalist).
+	KeywordPrefix = ":"
+
+	// UninternedPrefix starts the name of an uninterned symbol, as created
+	// by Gensym. No symbol read from source code can have this name.
+	UninternedPrefix = "#:"
+
+	// AutoGensymSuffix marks a symbol in a quasiquote template that should
+	// be replaced by a fresh uninterned symbol (see expr.ExpandQuasiQuote).
+	AutoGensymSuffix = "#"
 )
 
 var (
@@ -57,6 +71,8 @@ func init() {
 
 var (
 	symboltable = interntable.New()
+
+	gensymCounter uint64
 )
 
 type symbolValue uint32
@@ -70,6 +86,9 @@ func (i symbolValue) String() string {
 }
 
 func (i symbolValue) Eval(e types.Env) (types.Value, error) {
+	if i.isKeyword() {
+		return i, nil
+	}
 	val, ok := e.Lookup(uint32(i))
 	if !ok {
 		return nil, lisperr.UnboundVariable(i.String())
@@ -79,6 +98,10 @@ func (i symbolValue) Eval(e types.Env) (types.Value, error) {
 
 func (_ symbolValue) Falsey() bool { return false }
 
+func (i symbolValue) isKeyword() bool {
+	return strings.HasPrefix(i.String(), KeywordPrefix)
+}
+
 func (i symbolValue) AtomEquals(other types.Atom) bool {
 	o, ok := other.(symbolValue)
 	if !ok {
@@ -115,6 +138,11 @@ func IdOrPanic(v types.Value) uint32 {
 	return rv
 }
 
+// FromId returns the symbol with the given id.
+func FromId(id uint32) types.Value {
+	return symbolValue(id)
+}
+
 func StringToIdOrPanic(s string) uint32 {
 	return IdOrPanic(New(s))
 }
@@ -129,11 +157,45 @@ func New(s string) types.Value {
 	return symbolValue(n)
 }
 
+// Gensym returns a new uninterned symbol. It is distinct from every other
+// symbol, including any symbol with the same name.
+func Gensym(prefix string) types.Value {
+	n := atomic.AddUint64(&gensymCounter, 1)
+	metricNewSymbol.Inc()
+	return symbolValue(symboltable.Fresh(fmt.Sprintf("%s%s%d", UninternedPrefix, prefix, n)))
+}
+
+func IsUninterned(v types.Value) bool {
+	rv, ok := v.(symbolValue)
+	return ok && strings.HasPrefix(rv.String(), UninternedPrefix)
+}
+
 func Is(v types.Value) bool {
 	_, ok := v.(symbolValue)
 	return ok
 }
 
+func IsKeyword(v types.Value) bool {
+	rv, ok := v.(symbolValue)
+	return ok && rv.isKeyword()
+}
+
+func NewKeyword(name string) types.Value {
+	return New(KeywordPrefix + name)
+}
+
+// KeywordName returns the name of a keyword without its prefix.
+func KeywordName(v types.Value) (string, error) {
+	if !IsKeyword(v) {
+		return "", errors.New("not a keyword")
+	}
+	name, err := Name(v)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimPrefix(name, KeywordPrefix), nil
+}
+
 func (s symbolValue) Hashcode() uint32 {
 	return hashcode.Hash("sym:", []byte(string(s)))
 }
@@ -3,14 +3,15 @@ package anyof
 import (
 	"errors"
 	"fmt"
-	"io"
 	"sort"
 	"strings"
 
-	"github.com/steinarvk/heisenlisp/dedupe"
 	"github.com/steinarvk/heisenlisp/hashcode"
+	"github.com/steinarvk/heisenlisp/hashcons"
+	"github.com/steinarvk/heisenlisp/parallel"
 	"github.com/steinarvk/heisenlisp/types"
 	"github.com/steinarvk/heisenlisp/value/boolean"
+	"github.com/steinarvk/heisenlisp/value/integer"
 	"github.com/steinarvk/heisenlisp/value/unknowns/fullyunknown"
 	"github.com/steinarvk/heisenlisp/value/unknowns/optcons"
 )
@@ -24,15 +25,30 @@ var (
 )
 
 type anyOf struct {
-	vals  []types.Value
-	types []string
-	h     uint32
+	// vals are the possible values, in the order they were added, and set
+	// the same values for lookup.
+	vals []types.Value
+	set  set
+
+	// types are the type names of the values, unless anyType is set
+	// because some value is unknown and could have any type.
+	types   []string
+	anyType bool
+	sum     uint32
+	h       uint32
+
+	// canonical is set if all the values are canonical.
+	canonical bool
 }
 
-var MaybeValue = &anyOf{
-	vals:  []types.Value{boolean.True, boolean.False},
-	types: []string{boolean.TypeName},
-}
+var table = hashcons.NewTable()
+
+var MaybeValue = func() *anyOf {
+	b := newBuilder()
+	b.add(boolean.True)
+	b.add(boolean.False)
+	return b.anyOf()
+}()
 
 func (a *anyOf) Hashcode() uint32 {
 	return a.h
@@ -75,52 +91,152 @@ func (_ *anyOf) TypeName() string                      { return TypeName }
 func (_ *anyOf) HasNontypeInfo() bool { return true }
 
 func (a *anyOf) ActualTypeName() ([]string, bool) {
-	return a.types, true
+	return a.types, !a.anyType
 }
 
 func (a *anyOf) possibleValues() []types.Value {
 	return a.vals
 }
 
-func newRaw(xs []types.Value) types.Value {
-	rv := &anyOf{}
+// builder accumulates the distinct values of an any-of, stopping once there
+// are too many.
+type builder struct {
+	vals    []types.Value
+	set     set
+	types   []string
+	anyType bool
+	sum     uint32
+
+	// canonical is set if all the values are canonical.
+	canonical bool
+	tooMany   bool
+}
 
-	deduper := dedupe.New()
+func newBuilder() *builder {
+	return &builder{canonical: true}
+}
 
-	hasher := hashcode.New()
-	io.WriteString(hasher, "anyof:")
+// from starts a builder with the values of an any-of, sharing its set.
+func from(a *anyOf) *builder {
+	return &builder{
+		vals:      a.vals[:len(a.vals):len(a.vals)],
+		set:       a.set,
+		types:     a.types,
+		anyType:   a.anyType,
+		sum:       a.sum,
+		canonical: a.canonical,
+	}
+}
 
-	tps := map[string]struct{}{}
+func (b *builder) add(v types.Value) {
+	if b.tooMany {
+		return
+	}
 
-	addIfNew := func(singleValue types.Value) {
-		if deduper.Add(singleValue) {
-			tps[singleValue.TypeName()] = struct{}{}
-			hasher.Write([]byte(string(singleValue.Hashcode())))
-		}
+	newSet, added := b.set.add(v.Hashcode(), v)
+	if !added {
+		return
+	}
+	b.set = newSet
+
+	if int64(len(b.vals)) >= MaxAnyOfElements {
+		// The values are no longer needed.
+		b.tooMany = true
+		b.vals = nil
+		b.set = set{}
+		return
 	}
 
-	for _, x := range xs {
-		vals, ok := PossibleValues(x)
-		if ok {
-			for _, val := range vals {
-				addIfNew(val)
-			}
-			continue
+	b.vals = append(b.vals, v)
+	// The hashcode does not depend on the order of the values.
+	b.sum += v.Hashcode()
+	b.canonical = b.canonical && canonical(v)
+
+	// An unknown value (like a range) has the types of the values it may be.
+	typenames := []string{v.TypeName()}
+	if u, ok := v.(types.Unknown); ok {
+		actual, known := u.ActualTypeName()
+		b.anyType = b.anyType || !known
+		typenames = actual
+	}
+	for _, tp := range typenames {
+		b.addType(tp)
+	}
+}
+
+func (b *builder) addType(tp string) {
+	i := sort.SearchStrings(b.types, tp)
+	if i == len(b.types) || b.types[i] != tp {
+		tps := make([]string, 0, len(b.types)+1)
+		tps = append(tps, b.types[:i]...)
+		tps = append(tps, tp)
+		b.types = append(tps, b.types[i:]...)
+	}
+}
+
+// addAll adds the possible values of a value.
+func (b *builder) addAll(x types.Value) {
+	if vals, ok := PossibleValues(x); ok {
+		for _, val := range vals {
+			b.add(val)
 		}
-		addIfNew(x)
+		return
+	}
+	b.add(x)
+}
+
+func (b *builder) anyOf() *anyOf {
+	return &anyOf{
+		vals:      b.vals,
+		set:       b.set,
+		types:     b.types,
+		anyType:   b.anyType,
+		sum:       b.sum,
+		h:         hashcode.Hash("anyof:", hashcode.Bytes(b.sum)),
+		canonical: b.canonical,
+	}
+}
+
+func (b *builder) value() (types.Value, error) {
+	if b.tooMany {
+		// past a certain limit we start discarding information to not allow the
+		// work associated with keeping track of uncertainty to grow without bound.
+		// note that returning a FullyUnknown is the last resort; other options
+		// would be returning something with constrained type or value, e.g.
+		// a numerically constrained value.
+		return fullyunknown.Value, nil
 	}
 
-	var typesSlice []string
-	for k := range tps {
-		typesSlice = append(typesSlice, k)
+	if len(b.vals) == 1 {
+		return b.vals[0], nil
 	}
-	sort.Strings(typesSlice)
 
-	rv.vals = deduper.Slice()
-	rv.types = typesSlice
-	rv.h = hasher.Sum32()
+	rv := b.anyOf()
 
-	return rv
+	if hashcons.Enabled {
+		return table.Intern(rv.h, func(v types.Value) bool {
+			return hashcons.IdenticalSlices(v.(*anyOf).vals, rv.vals)
+		}, func() types.Value {
+			return rv
+		}), nil
+	}
+
+	return rv, nil
+}
+
+// canonical checks whether a value can only be equal to values with the same
+// hashcode: an atom other than a number, or an integer that is not a bigint
+// (since numbers of different kinds may be equal).
+func canonical(v types.Value) bool {
+	if _, ok := v.(types.Unknown); ok {
+		return false
+	}
+	if _, ok := v.(types.Numeric); ok {
+		_, err := integer.ToInt64(v)
+		return err == nil
+	}
+	_, ok := v.(types.Atom)
+	return ok
 }
 
 func NewOrPanic(xs []types.Value) types.Value {
@@ -146,24 +262,64 @@ func New(xs []types.Value) (types.Value, error) {
 		return xs[0], nil
 	}
 
-	rv := newRaw(xs).(*anyOf)
+	// The union with an any-of shares its set, so only the other values
+	// need be added.
+	b := newBuilder()
+	if a, ok := xs[0].(*anyOf); ok {
+		b = from(a)
+		xs = xs[1:]
+	}
+
+	for _, x := range xs {
+		b.addAll(x)
+	}
 
-	vals := rv.possibleValues()
+	return b.value()
+}
 
-	if len(vals) == 1 {
-		return vals[0], nil
+// MapProduct returns the any-of of f(x, y) for each x in xs and y in ys,
+// evaluated as by parallel.Map.
+func MapProduct(xs, ys []types.Value, f func(x, y types.Value) (types.Value, error)) (types.Value, error) {
+	rv, err := parallel.Map(len(xs)*len(ys), func(i int) (types.Value, error) {
+		return f(xs[i/len(ys)], ys[i%len(ys)])
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	if int64(len(vals)) > MaxAnyOfElements {
-		// past a certain limit we start discarding information to not allow the
-		// work associated with keeping track of uncertainty to grow without bound.
-		// note that returning a FullyUnknown is the last resort; other options
-		// would be returning something with constrained type or value, e.g.
-		// a numerically constrained value.
-		return fullyunknown.Value, nil
+	if len(rv) == 0 {
+		return New(rv)
 	}
 
-	return rv, nil
+	for _, x := range rv {
+		if fullyunknown.Is(x) {
+			return fullyunknown.Value, nil
+		}
+	}
+
+	b := newBuilder()
+	for _, x := range rv {
+		b.addAll(x)
+	}
+	return b.value()
+}
+
+// Contains checks whether v is one of the possible values of an any-of,
+// without enumerating them if possible. The result is only known (ok) if v
+// is found, or if neither v nor any of the possible values can be equal to
+// a value with a different hashcode.
+func Contains(a, v types.Value) (contains bool, ok bool) {
+	rv, isAnyOf := a.(*anyOf)
+	if !isAnyOf {
+		return false, false
+	}
+	if rv.set.has(v.Hashcode(), v) {
+		return true, true
+	}
+	if rv.canonical && canonical(v) {
+		return false, true
+	}
+	return false, false
 }
 
 func PossibleValues(v types.Value) ([]types.Value, bool) {
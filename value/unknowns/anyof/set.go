@@ -0,0 +1,141 @@
+package anyof
+
+import (
+	"math/bits"
+
+	"github.com/steinarvk/heisenlisp/cyclebreaker"
+	"github.com/steinarvk/heisenlisp/hashcons"
+	"github.com/steinarvk/heisenlisp/types"
+)
+
+// set is a persistent hash set of values (a hash array mapped trie): adding
+// a value returns a new set sharing all but the path to the new value with
+// the old one, so that a union need only add the values of the smaller set
+// to the larger one.
+//
+// As with valuemap, values are the same if they have the same hashcode and
+// must be equal.
+type set struct {
+	root *setNode
+}
+
+const (
+	setBits = 5
+	setMask = 1<<setBits - 1
+)
+
+// setNode has a child for each set bit of bitmap, in order. Children are
+// either *setNode or *setBucket.
+type setNode struct {
+	bitmap   uint32
+	children []interface{}
+}
+
+// setBucket holds the values with the same hashcode.
+type setBucket struct {
+	h    uint32
+	vals []types.Value
+}
+
+func sameValue(a, b types.Value) bool {
+	if hashcons.Identical(a, b) {
+		return true
+	}
+	tv, err := cyclebreaker.Equals(a, b)
+	if err != nil {
+		panic(err)
+	}
+	return tv == types.True
+}
+
+// bucket returns the values in the set with the hashcode h.
+func (s set) bucket(h uint32) []types.Value {
+	n := s.root
+	for shift := uint(0); n != nil; shift += setBits {
+		bit := uint32(1) << ((h >> shift) & setMask)
+		if n.bitmap&bit == 0 {
+			return nil
+		}
+		switch c := n.children[bits.OnesCount32(n.bitmap&(bit-1))].(type) {
+		case *setBucket:
+			if c.h == h {
+				return c.vals
+			}
+			return nil
+		case *setNode:
+			n = c
+		}
+	}
+	return nil
+}
+
+func (s set) has(h uint32, v types.Value) bool {
+	for _, x := range s.bucket(h) {
+		if sameValue(x, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// add returns the set with v added, and whether it was not already there.
+func (s set) add(h uint32, v types.Value) (set, bool) {
+	root := s.root
+	if root == nil {
+		root = &setNode{}
+	}
+	newRoot, added := root.add(0, h, v)
+	return set{newRoot}, added
+}
+
+func (n *setNode) with(i int, child interface{}) *setNode {
+	children := make([]interface{}, len(n.children))
+	copy(children, n.children)
+	children[i] = child
+	return &setNode{bitmap: n.bitmap, children: children}
+}
+
+func (n *setNode) add(shift uint, h uint32, v types.Value) (*setNode, bool) {
+	bit := uint32(1) << ((h >> shift) & setMask)
+	i := bits.OnesCount32(n.bitmap & (bit - 1))
+
+	if n.bitmap&bit == 0 {
+		children := make([]interface{}, len(n.children)+1)
+		copy(children, n.children[:i])
+		children[i] = &setBucket{h: h, vals: []types.Value{v}}
+		copy(children[i+1:], n.children[i:])
+		return &setNode{bitmap: n.bitmap | bit, children: children}, true
+	}
+
+	switch c := n.children[i].(type) {
+	case *setBucket:
+		if c.h == h {
+			for _, x := range c.vals {
+				if sameValue(x, v) {
+					return n, false
+				}
+			}
+			vals := make([]types.Value, len(c.vals), len(c.vals)+1)
+			copy(vals, c.vals)
+			return n.with(i, &setBucket{h: h, vals: append(vals, v)}), true
+		}
+
+		// The hashcodes differ at some later level.
+		next := shift + setBits
+		sub := &setNode{
+			bitmap:   uint32(1) << ((c.h >> next) & setMask),
+			children: []interface{}{c},
+		}
+		sub, _ = sub.add(next, h, v)
+		return n.with(i, sub), true
+
+	case *setNode:
+		sub, added := c.add(shift+setBits, h, v)
+		if !added {
+			return n, false
+		}
+		return n.with(i, sub), true
+	}
+
+	panic("invalid set node")
+}
@@ -0,0 +1,63 @@
+package anyof
+
+import (
+	"testing"
+
+	"github.com/steinarvk/heisenlisp/cyclebreaker"
+	"github.com/steinarvk/heisenlisp/types"
+	"github.com/steinarvk/heisenlisp/value/integer"
+)
+
+func init() {
+	// The set needs equality only for values with the same hashcode.
+	cyclebreaker.Equals = func(a, b types.Value) (types.TernaryTruthValue, error) {
+		x, _ := integer.ToInt64(a)
+		y, _ := integer.ToInt64(b)
+		if x == y {
+			return types.True, nil
+		}
+		return types.False, nil
+	}
+}
+
+func TestSet(t *testing.T) {
+	for _, hash := range []struct {
+		name string
+		f    func(v types.Value) uint32
+	}{
+		{"hashcode", func(v types.Value) uint32 { return v.Hashcode() }},
+		{"colliding", func(v types.Value) uint32 {
+			n, _ := integer.ToInt64(v)
+			return uint32(n%7) << 27
+		}},
+	} {
+		var s set
+		var sets []set
+		for i := 0; i < 500; i++ {
+			sets = append(sets, s)
+			v := integer.FromInt64(int64(i))
+			var added bool
+			s, added = s.add(hash.f(v), v)
+			if !added {
+				t.Errorf("%s: add(%v) = not added", hash.name, v)
+			}
+			if _, added := s.add(hash.f(v), v); added {
+				t.Errorf("%s: add(%v) twice = added", hash.name, v)
+			}
+		}
+
+		for i := 0; i < 500; i++ {
+			v := integer.FromInt64(int64(i))
+			if !s.has(hash.f(v), v) {
+				t.Errorf("%s: set does not have %v", hash.name, v)
+			}
+			// Earlier sets are unchanged.
+			if sets[i].has(hash.f(v), v) {
+				t.Errorf("%s: set %d has %v", hash.name, i, v)
+			}
+			if i > 0 && !sets[i].has(hash.f(integer.FromInt64(0)), integer.FromInt64(0)) {
+				t.Errorf("%s: set %d does not have 0", hash.name, i)
+			}
+		}
+	}
+}
@@ -0,0 +1,146 @@
+// Package maythrow implements uncertain outcomes that may be exceptions:
+// a value that is either some normal value, or one of some exceptions.
+//
+// Such a value arises e.g. when an if with a maybe condition evaluates both
+// branches and only one of them throws. Calling a function with a value that
+// may throw calls it with the normal value, and the result may throw the same
+// exceptions.
+package maythrow
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/steinarvk/heisenlisp/hashcode"
+	"github.com/steinarvk/heisenlisp/types"
+)
+
+const TypeName = "may-throw"
+
+var (
+	metricNewMayThrow = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "hlisp",
+			Name:      "new_may_throw",
+			Help:      "New may-throw values created",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(metricNewMayThrow)
+}
+
+var _ types.Unknown = &mayThrow{}
+
+type mayThrow struct {
+	value      types.Value
+	exceptions []error
+	h          uint32
+}
+
+// New returns a value that is either v or one of the exceptions. With no
+// exceptions, it is just v.
+func New(v types.Value, exceptions []error) types.Value {
+	v, more := Split(v)
+	exceptions = append(append([]error(nil), exceptions...), more...)
+	if len(exceptions) == 0 {
+		return v
+	}
+
+	unique := dedupe(exceptions)
+
+	var messages [][]byte
+	for _, err := range unique {
+		messages = append(messages, []byte(err.Error()))
+	}
+
+	metricNewMayThrow.Inc()
+	return &mayThrow{
+		value:      v,
+		exceptions: unique,
+		h:          hashcode.Hash(fmt.Sprintf("may-throw:%d:", v.Hashcode()), messages...),
+	}
+}
+
+// Fail returns the error of an evaluation that throws one of the given
+// exceptions in every possible world.
+func Fail(exceptions []error) error {
+	unique := dedupe(exceptions)
+	if len(unique) == 1 {
+		return unique[0]
+	}
+	return OneOf(unique)
+}
+
+func dedupe(exceptions []error) []error {
+	seen := map[string]bool{}
+	var unique []error
+	for _, err := range exceptions {
+		if !seen[err.Error()] {
+			seen[err.Error()] = true
+			unique = append(unique, err)
+		}
+	}
+	return unique
+}
+
+// Split returns the normal value of v and the exceptions it may throw
+// instead (none if v is not a may-throw value).
+func Split(v types.Value) (types.Value, []error) {
+	m, ok := v.(*mayThrow)
+	if !ok {
+		return v, nil
+	}
+	return m.value, m.exceptions
+}
+
+func Is(v types.Value) bool {
+	_, ok := v.(*mayThrow)
+	return ok
+}
+
+func (m *mayThrow) String() string {
+	var parts []string
+	for _, err := range m.exceptions {
+		parts = append(parts, fmt.Sprintf("%q", err.Error()))
+	}
+	return fmt.Sprintf("#may-throw(%v :or %s)", m.value, strings.Join(parts, " "))
+}
+
+func (m *mayThrow) Eval(_ types.Env) (types.Value, error) { return m, nil }
+func (m *mayThrow) Falsey() bool                          { return m.value.Falsey() }
+func (_ *mayThrow) TypeName() string                      { return TypeName }
+func (m *mayThrow) Hashcode() uint32                      { return m.h }
+func (_ *mayThrow) HasNontypeInfo() bool                  { return true }
+
+func (m *mayThrow) ActualTypeName() ([]string, bool) {
+	if unk, ok := m.value.(types.Unknown); ok {
+		return unk.ActualTypeName()
+	}
+	return []string{m.value.TypeName()}, true
+}
+
+// OneOf is the error of an evaluation that throws in every possible world,
+// but may throw any one of several exceptions.
+type OneOf []error
+
+func (o OneOf) Error() string {
+	var parts []string
+	for _, err := range o {
+		parts = append(parts, err.Error())
+	}
+	return fmt.Sprintf("one of: %s", strings.Join(parts, "; "))
+}
+
+// Exceptions returns the possible exceptions of an error: those of a OneOf,
+// or else just the error itself.
+func Exceptions(err error) []error {
+	var oneOf OneOf
+	if errors.As(err, &oneOf) {
+		return oneOf
+	}
+	return []error{err}
+}
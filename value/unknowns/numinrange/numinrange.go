@@ -8,6 +8,7 @@ import (
 	"github.com/steinarvk/heisenlisp/types"
 	"github.com/steinarvk/heisenlisp/typeset"
 	"github.com/steinarvk/heisenlisp/value/integer"
+	"github.com/steinarvk/heisenlisp/value/rational"
 	"github.com/steinarvk/heisenlisp/value/real"
 )
 
@@ -15,7 +16,7 @@ const TypeName = "number-in-range"
 
 var _ types.Unknown = &numinrangeValue{}
 
-var defaultTypeset = typeset.New(real.TypeName, integer.TypeName)
+var defaultTypeset = typeset.New(real.TypeName, rational.TypeName, integer.TypeName)
 
 type numinrangeValue struct {
 	ts *typeset.TypeSet
@@ -2,6 +2,7 @@ package optcons
 
 import (
 	"fmt"
+	"sync"
 
 	"github.com/steinarvk/heisenlisp/hashcode"
 	"github.com/steinarvk/heisenlisp/types"
@@ -16,9 +17,9 @@ type optCons struct {
 	mbCons types.Value
 	h      uint32
 
-	hasCachedActualTypename bool
-	cachedActualTypename    []string
-	cachedActualTypenameOK  bool
+	actualTypenameOnce     sync.Once
+	cachedActualTypename   []string
+	cachedActualTypenameOK bool
 }
 
 func (o *optCons) Hashcode() uint32 {
@@ -32,7 +33,7 @@ func New(car, cdr types.Value) types.Value {
 		car:    car,
 		cdr:    cdr,
 		mbCons: cons.New(car, cdr),
-		h:      hashcode.Hash("optcons:", []byte(string(car.Hashcode())), []byte(string(cdr.Hashcode()))),
+		h:      hashcode.Hash("optcons:", hashcode.Bytes(car.Hashcode()), hashcode.Bytes(cdr.Hashcode())),
 	}
 }
 
@@ -51,10 +52,9 @@ func (o *optCons) Falsey() bool {
 }
 
 func (o *optCons) ActualTypeName() ([]string, bool) {
-	if !o.hasCachedActualTypename {
+	o.actualTypenameOnce.Do(func() {
 		o.cachedActualTypename, o.cachedActualTypenameOK = o.calculateActualTypeName()
-		o.hasCachedActualTypename = true
-	}
+	})
 	return o.cachedActualTypename, o.cachedActualTypenameOK
 }
 